@@ -1,4 +1,7 @@
 @echo off
 set GOARCH=386
-go build -ldflags "-w -s"
-upx --lzma m4s-converter.exe
\ No newline at end of file
+for /f %%i in ('git rev-parse --short HEAD') do set COMMIT=%%i
+for /f %%i in ('powershell -NoProfile -Command "Get-Date -Format yyyy-MM-dd"') do set BUILD_DATE=%%i
+set PKG=m4s-converter/common
+go build -ldflags "-w -s -X %PKG%.commit=%COMMIT% -X %PKG%.buildDate=%BUILD_DATE%"
+upx --lzma m4s-converter.exe
@@ -0,0 +1,209 @@
+package common
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+// zipCache 作为缓存路径的zip压缩包，videoInfo等小文件打开时解压到dir，m4s文件转换前按需解压
+type zipCache struct {
+	path string // zip文件
+	dir  string // 解压的临时目录，替换zip作为缓存路径
+	r    *zip.ReadCloser
+	m4s  map[string]*zip.File // 解压后的路径对应的m4s文件
+}
+
+// isArchive 判断缓存路径是否为zip压缩包
+func isArchive(path string) bool {
+	if !strings.EqualFold(filepath.Ext(path), ".zip") {
+		return false
+	}
+	fi, err := os.Stat(path)
+	return err == nil && fi.Mode().IsRegular()
+}
+
+// archiveStage 返回zip解压到的临时目录，同一个zip每次使用相同的目录，-keep-temp保留时下次不用重新解压
+func archiveStage(zipPath string) string {
+	sum := sha256.Sum256([]byte(zipPath))
+	name := strings.TrimSuffix(filepath.Base(zipPath), filepath.Ext(zipPath))
+	return filepath.Join(os.TempDir(), "m4s-converter", name+"-"+hex.EncodeToString(sum[:4]))
+}
+
+// zipName 返回zip中文件的相对路径，Windows压缩的zip中文件名通常为GBK编码，包含..等跳出目录的路径时返回错误
+func zipName(f *zip.File) (string, error) {
+	name := f.Name
+	if f.NonUTF8 && !utf8.ValidString(name) {
+		if decoded, err := simplifiedchinese.GBK.NewDecoder().String(name); err == nil {
+			name = decoded
+		}
+	}
+	name = filepath.FromSlash(strings.ReplaceAll(name, `\`, "/"))
+	if !filepath.IsLocal(name) {
+		return "", fmt.Errorf("zip中的路径无效：%s", f.Name)
+	}
+	return name, nil
+}
+
+// openZip 打开zip缓存，将m4s以外的文件解压到临时目录并记录其中的m4s文件
+func openZip(path string) (*zipCache, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := zip.OpenReader(abs)
+	if err != nil {
+		return nil, err
+	}
+	a := &zipCache{path: abs, dir: archiveStage(abs), r: r, m4s: make(map[string]*zip.File)}
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		name, err := zipName(f)
+		if err != nil {
+			_ = r.Close()
+			return nil, err
+		}
+		dst := filepath.Join(a.dir, name)
+		if isM4s(name) {
+			a.m4s[dst] = f
+			continue
+		}
+		if err = extractZipFile(f, dst); err != nil {
+			_ = r.Close()
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// extractZipFile 将zip中的文件解压到dst，修改时间与zip中相同，保证状态文件中的指纹不变，
+// dst已存在且大小和修改时间相同时不再解压
+func extractZipFile(f *zip.File, dst string) error {
+	if fi, err := os.Stat(dst); err == nil && fi.Size() == int64(f.UncompressedSize64) && fi.ModTime().Equal(f.Modified) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return err
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return fmt.Errorf("读取zip中的%s失败：%w", f.Name, err)
+	}
+	defer rc.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, rc)
+	if e := out.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		_ = os.Remove(dst)
+		return fmt.Errorf("解压%s失败：%w", f.Name, err)
+	}
+	return os.Chtimes(dst, f.Modified, f.Modified)
+}
+
+// openArchives 打开缓存路径中的zip压缩包，并将缓存路径替换为解压的临时目录，查找和合成都在临时目录中进行
+func (c *Config) openArchives() error {
+	roots := c.CacheRoots()
+	for i, root := range roots {
+		if !isArchive(root) {
+			continue
+		}
+		if c.Clean {
+			return errors.New("zip缓存不能使用-clean和-clean-only清理：" + root)
+		}
+		a, err := openZip(root)
+		if err != nil {
+			return fmt.Errorf("打开zip缓存失败：%w", err)
+		}
+		if c.archives == nil {
+			c.archives = make(map[string]*zipCache)
+		}
+		c.archives[a.dir] = a
+		roots[i] = a.dir
+		logrus.Infof("已读取zip缓存%s，临时目录:%s", root, a.dir)
+	}
+	// CachePaths为空时roots是新建的切片，需要写回CachePath
+	if len(roots) > 0 {
+		c.CachePath = roots[0]
+	}
+	return nil
+}
+
+// closeArchives 关闭zip缓存并删除解压的临时目录，指定-keep-temp时保留，缓存路径恢复为zip文件
+func (c *Config) closeArchives() {
+	roots := c.CacheRoots()
+	for i, root := range roots {
+		if a := c.archives[root]; a != nil {
+			roots[i] = a.path
+		}
+	}
+	if len(roots) > 0 {
+		c.CachePath = roots[0]
+	}
+	for dir, a := range c.archives {
+		_ = a.r.Close()
+		if !c.KeepTemp {
+			if err := os.RemoveAll(dir); err != nil {
+				logrus.Warn("删除zip缓存的临时目录失败:", err)
+			}
+		}
+	}
+	c.archives = nil
+}
+
+// extractM4s 解压zip缓存root中位于dirs的m4s文件，其它目录中的m4s不解压，root不是zip缓存时不做任何事
+func (c *Config) extractM4s(root string, dirs []string) error {
+	a := c.archives[root]
+	if a == nil {
+		return nil
+	}
+	for dst, f := range a.m4s {
+		for _, d := range dirs {
+			if filepath.Dir(dst) != d && !inDir(d, dst) {
+				continue
+			}
+			logrus.Debug("从zip中解压m4s:", f.Name)
+			if err := extractZipFile(f, dst); err != nil {
+				return err
+			}
+			break
+		}
+	}
+	return nil
+}
+
+// archiveOutput 返回zip缓存中的缓存目录v的合成文件根目录，为zip所在目录下的output，v不在zip缓存中时返回空
+func (c *Config) archiveOutput(v string) string {
+	for dir, a := range c.archives {
+		if inDir(dir, v) || v == dir {
+			return filepath.Join(filepath.Dir(a.path), "output")
+		}
+	}
+	return ""
+}
+
+// zipDir 将zip缓存临时目录中的路径转换为zip文件加相对路径，如friend.zip/1332097557，用于报告和列表中显示
+func (c *Config) zipDir(path string) string {
+	for dir, a := range c.archives {
+		if rel, err := filepath.Rel(dir, path); err == nil && !strings.HasPrefix(rel, "..") {
+			return filepath.Join(a.path, rel)
+		}
+	}
+	return path
+}
@@ -0,0 +1,61 @@
+package common
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// asciiPunct 中文标点对应的ASCII字符，全角字母数字和符号由NFKD转换
+var asciiPunct = map[rune]string{
+	'《': "(", '》': ")", '〈': "(", '〉': ")",
+	'【': "[", '】': "]", '〔': "[", '〕': "]",
+	'「': "'", '」': "'", '『': "'", '』': "'",
+	'“': "'", '”': "'", '‘': "'", '’': "'",
+	'，': ",", '、': ",", '。': ".", '·': ".",
+	'—': "-", '–': "-", '～': "~",
+}
+
+// fatInvalid FAT32、exFAT和NTFS文件名中不允许的ASCII字符
+const fatInvalid = `<>:"/\|?*`
+
+// FilterASCII 过滤文件名并只保留ASCII字符，用于FAT32、exFAT格式的U盘、移动硬盘和不支持中文的播放设备
+// 全角字母数字和中文标点转换为半角，去掉字母上的音调，中文、emoji等其它字符替换为_，连续的_合并为一个，
+// 没有剩下任何字母数字时返回空
+func FilterASCII(name string) string {
+	var b strings.Builder
+	for _, r := range norm.NFKD.String(name) {
+		if s, ok := asciiPunct[r]; ok {
+			b.WriteString(s)
+			continue
+		}
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			// 去掉NFKD分解出的音调符号，如é分解为e和´
+		case r >= 0x80 || strings.ContainsRune(fatInvalid, r):
+			b.WriteRune('_')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	name = b.String()
+	for strings.Contains(name, "__") {
+		name = strings.ReplaceAll(name, "__", "_")
+	}
+	// 替换的_与空格相邻时去掉，如"😀 Hello"为"Hello"而不是"_ Hello"
+	name = strings.ReplaceAll(strings.ReplaceAll(name, " _", " "), "_ ", " ")
+	name = strings.Trim(name, "_ ")
+	if strings.IndexFunc(name, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }) < 0 {
+		return ""
+	}
+	return Filter(name)
+}
+
+// filterName 过滤videoInfo中的名称用作文件名，-ascii-safe时只保留ASCII字符
+func (c *Config) filterName(name string) string {
+	if c.ASCIISafe {
+		return FilterASCII(name)
+	}
+	return Filter(name)
+}
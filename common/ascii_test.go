@@ -0,0 +1,69 @@
+package common
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFilterASCII(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"ASCII不变", "Hello World", "Hello World"},
+		{"emoji与空格相邻", "😀 Hello 🎉", "Hello"},
+		{"emoji在中间", "a😀b", "a_b"},
+		{"连续的emoji", "a😀🎉👍b", "a_b"},
+		{"组合emoji", "Family👨‍👩‍👧Video", "Family_Video"},
+		{"全角字母数字", "ＡＢＣ１２３", "ABC123"},
+		{"全角符号", "Ｑ＆Ａ！", "Q&A!"},
+		{"全角冒号和问号", "为什么？：Ｗｈｙ", "Why"},
+		{"中文标点", "【合集】《Go语言》，第1集", "[_](Go_),_1"},
+		{"音调", "Café Pokémon", "Cafe Pokemon"},
+		{"FAT不允许的字符", `a<b>c:d"e/f\g|h?i*j`, "a_b_c_d_e_f_g_h_i_j"},
+		{"只有中文", "蛇的工作原理", ""},
+		{"只有emoji", "😀🎉", ""},
+		{"只有标点", "【】", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := FilterASCII(tt.in)
+			if got != tt.want {
+				t.Errorf("FilterASCII(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+			for _, r := range got {
+				if r >= 0x80 || strings.ContainsRune(fatInvalid, r) {
+					t.Errorf("FilterASCII(%q) = %q 含有字符%q", tt.in, got, r)
+				}
+			}
+		})
+	}
+}
+
+func TestASCIISafeOutputName(t *testing.T) {
+	tests := []struct {
+		name  string
+		title string
+		ascii bool
+		want  string
+	}{
+		{"默认保留中文和emoji", "蛇的工作原理😀", false, "蛇的工作原理😀.mp4"},
+		{"-ascii-safe去掉emoji", "Snake 😀 工作原理", true, "Snake.mp4"},
+		{"-ascii-safe过滤后为空时使用cid", "蛇的工作原理😀", true, "1001.mp4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "1001")
+			writeFile(t, dir, "videoInfo.json", `{"title":"`+tt.title+`","groupTitle":"合集","uname":"UP主","cid":1001,"status":"completed"}`)
+			writeFile(t, dir, "1001-100-video.mp4", "video")
+			writeFile(t, dir, "1001-30280-audio.mp3", "audio")
+			got := planOutputs(t, &Config{ASCIISafe: tt.ascii, Layout: LayoutFlat}, dir, t.TempDir())
+			if len(got) != 1 || path.Base(got[0]) != tt.want {
+				t.Errorf("输出文件为%q，应为%q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// minSizeRatio -overwrite-if-better时新文件与已有文件的最小大小比例，更小时视为缓存有问题，保留已有文件
+const minSizeRatio = 0.8
+
+// betterTemp 返回-overwrite-if-better时先合成到的临时文件，与输出文件在同一目录，保证可以原子替换
+func betterTemp(outputFile string) string {
+	ext := filepath.Ext(outputFile)
+	return strings.TrimSuffix(outputFile, ext) + ".new" + ext
+}
+
+// sidecarPath 返回输出文件旁边复制的弹幕文件路径，扩展名与弹幕文件相同
+func sidecarPath(outputFile, assFile string) string {
+	return strings.TrimSuffix(outputFile, filepath.Ext(outputFile)) + filepath.Ext(assFile)
+}
+
+// replaceIfBetter 用ffprobe读取新合成的临时文件tmp，时长不短于且大小不明显小于已有的outputFile时替换，
+// 否则删除临时文件并返回保留已有文件的原因，已有文件无法读取时直接替换
+func (c *Config) replaceIfBetter(ctx context.Context, tmp, outputFile, assFile string) (reason string, err error) {
+	discard := func() {
+		_ = os.Remove(tmp)
+		if assFile != "" {
+			_ = os.Remove(sidecarPath(tmp, assFile))
+		}
+	}
+	newInfo, err := c.Probe(ctx, tmp)
+	if err != nil {
+		discard()
+		return "", fmt.Errorf("新合成的文件无法读取：%w", err)
+	}
+	if oldInfo, e := c.Probe(ctx, outputFile); e != nil {
+		logrus.Warn("已有文件无法读取，使用新合成的文件:", outputFile, " ", e)
+	} else if newInfo.Duration < oldInfo.Duration-durationTolerance.Seconds() {
+		reason = fmt.Sprintf("新合成的文件时长%.0f秒短于已有文件的%.0f秒", newInfo.Duration, oldInfo.Duration)
+	} else if float64(newInfo.Size) < float64(oldInfo.Size)*minSizeRatio {
+		reason = fmt.Sprintf("新合成的文件大小%s明显小于已有文件的%s", FormatSize(newInfo.Size), FormatSize(oldInfo.Size))
+	}
+	if reason != "" {
+		discard()
+		return reason, nil
+	}
+	if err = os.Rename(tmp, outputFile); err != nil {
+		discard()
+		return "", fmt.Errorf("替换已有文件失败：%w", err)
+	}
+	if assFile != "" && Exist(sidecarPath(tmp, assFile)) {
+		if e := os.Rename(sidecarPath(tmp, assFile), sidecarPath(outputFile, assFile)); e != nil {
+			logrus.Warn("替换弹幕文件失败:", e)
+		}
+	}
+	return "", nil
+}
+
+// settleBetter 按replaceIfBetter的结果设置f，返回是否已替换为新合成的文件
+// 保留已有文件时f记为已合成过，出错时记为失败
+func (c *Config) settleBetter(ctx context.Context, f *FileResult, tmp, outputFile, assFile string) bool {
+	reason, err := c.replaceIfBetter(ctx, tmp, outputFile, assFile)
+	if err != nil {
+		logrus.Error(err)
+		f.Error = err.Error()
+		return false
+	}
+	if reason != "" {
+		logrus.Warn("保留已有文件:", outputFile, " ", reason)
+		f.Success, f.Done = true, true
+		f.Warning = reason
+		return false
+	}
+	logrus.Info("已替换为新合成的文件:", outputFile)
+	return true
+}
@@ -0,0 +1,25 @@
+package common
+
+import "testing"
+
+func TestInitConfigOverwriteIfBetter(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr bool
+	}{
+		{"-overwrite-if-better", []string{"-overwrite-if-better"}, false},
+		{"-overwrite-if-better与-o", []string{"-overwrite-if-better", "-o"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := initConfig(t, tt.args...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("InitConfig(%q) error = %v, wantErr %v", tt.args, err, tt.wantErr)
+			}
+			if err == nil && (!c.OverwriteIfBetter || c.Overlay != "-n") {
+				t.Errorf("OverwriteIfBetter = %v, Overlay = %q", c.OverwriteIfBetter, c.Overlay)
+			}
+		})
+	}
+}
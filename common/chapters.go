@@ -0,0 +1,251 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"m4s-converter/conver"
+)
+
+// Chapter 合并多P视频时每个分P对应的章节
+type Chapter struct {
+	Title string
+	Start time.Duration
+	End   time.Duration
+}
+
+// mergePages 判断-chapters时是否将plan中的分P合并为一个文件，只合成音频或轨道时仍按分P输出
+func (c *Config) mergePages(plan *dirPlan) bool {
+	return c.Chapters && len(plan.pages) > 1 && !c.Mp3 && !c.VideoOnly && !c.AudioOnly
+}
+
+// pageDuration 返回分P的时长，优先用ffprobe读取视频文件，读取失败时使用playurl中的时长
+func (c *Config) pageDuration(ctx context.Context, p Page) time.Duration {
+	if info, err := c.Probe(ctx, p.Video); err == nil && info.Duration > 0 {
+		return time.Duration(info.Duration * float64(time.Second))
+	}
+	return GetDuration(c.cacheDir(p.Dir))
+}
+
+// pageChapters 按每个分P的时长依次计算章节，章节名称为分P名称，没有时为P加序号
+func (c *Config) pageChapters(ctx context.Context, pages []Page) ([]Chapter, error) {
+	chapters := make([]Chapter, 0, len(pages))
+	var start time.Duration
+	for _, p := range pages {
+		d := c.pageDuration(ctx, p)
+		if d <= 0 {
+			return nil, fmt.Errorf("无法获取分P%d的时长，不能添加章节标记", p.Index)
+		}
+		title := strings.TrimSpace(p.Title)
+		if title == "" {
+			title = fmt.Sprintf("P%d", p.Index)
+		}
+		chapters = append(chapters, Chapter{Title: title, Start: start, End: start + d})
+		start += d
+	}
+	return chapters, nil
+}
+
+// ChapterMetadata 生成ffmpeg元数据文件，每个章节为一个[CHAPTER]，时间单位为毫秒
+func ChapterMetadata(chapters []Chapter) string {
+	var b strings.Builder
+	b.WriteString(";FFMETADATA1\n")
+	for _, ch := range chapters {
+		_, _ = fmt.Fprintf(&b, "[CHAPTER]\nTIMEBASE=1/1000\nSTART=%d\nEND=%d\ntitle=%s\n",
+			ch.Start.Milliseconds(), ch.End.Milliseconds(), escapeMetadata(ch.Title))
+	}
+	return b.String()
+}
+
+// escapeMetadata 转义元数据文件值中的 = ; # \ 和换行
+var escapeMetadata = strings.NewReplacer(`\`, `\\`, "=", `\=`, ";", `\;`, "#", `\#`, "\n", "\\\n").Replace
+
+// concatList 生成concat分离器的文件列表，路径中的单引号按concat的规则转义
+func concatList(files []string) string {
+	var b strings.Builder
+	for _, f := range files {
+		_, _ = fmt.Fprintf(&b, "file '%s'\n", strings.ReplaceAll(f, "'", `'\''`))
+	}
+	return b.String()
+}
+
+// checkMergeable 用ffprobe检查各分P的编码和分辨率是否相同，不同时直接复制合并的视频无法正常播放
+// 没有ffprobe时不检查
+func (c *Config) checkMergeable(ctx context.Context, pages []Page) error {
+	if c.FFProbePath == "" && c.ProbeRunner == nil {
+		return nil
+	}
+	var first *ProbeInfo
+	for _, p := range pages {
+		info, err := c.Probe(ctx, p.Video)
+		if err != nil {
+			logrus.Debug("读取分P视频信息失败，不检查能否合并: ", err)
+			return nil
+		}
+		if first == nil {
+			first = info
+			continue
+		}
+		if info.Video != first.Video || info.Width != first.Width || info.Height != first.Height {
+			return fmt.Errorf("分P%d的视频编码或分辨率（%s %dx%d）与分P%d（%s %dx%d）不同，无法合并",
+				p.Index, info.Video, info.Width, info.Height, pages[0].Index, first.Video, first.Width, first.Height)
+		}
+	}
+	return nil
+}
+
+// MergePages 将多P视频的音视频按分P顺序合并为outputFile，并在每个分P处添加章节标记
+// 直接复制音视频流，不压制也不封装弹幕
+func (c *Config) MergePages(ctx context.Context, pages []Page, coverFile, outputFile string, metadata map[string]string) error {
+	if err := c.checkMergeable(ctx, pages); err != nil {
+		return err
+	}
+	chapters, err := c.pageChapters(ctx, pages)
+	if err != nil {
+		return err
+	}
+	// 文件列表和章节写入临时目录，合并后删除
+	dir, err := os.MkdirTemp("", "m4s-chapters-")
+	if err != nil {
+		return fmt.Errorf("创建章节临时目录失败：%w", err)
+	}
+	defer os.RemoveAll(dir)
+	var videos, audios []string
+	for _, p := range pages {
+		videos, audios = append(videos, p.Video), append(audios, p.Audio)
+	}
+	videoList := filepath.Join(dir, "video.txt")
+	audioList := filepath.Join(dir, "audio.txt")
+	chaptersFile := filepath.Join(dir, "chapters.txt")
+	for file, content := range map[string]string{
+		videoList:    concatList(videos),
+		audioList:    concatList(audios),
+		chaptersFile: ChapterMetadata(chapters),
+	} {
+		if err = os.WriteFile(file, []byte(content), 0644); err != nil {
+			return fmt.Errorf("写入章节临时文件失败：%w", err)
+		}
+	}
+	opts := conver.MuxOptions{
+		Overwrite: c.Overlay == "-y",
+		Metadata:  metadata,
+		Cover:     coverFile,
+		FastStart: c.fastStart() && !hasArg(c.FFmpegArgs, "-movflags"),
+		Concat:    true,
+		Chapters:  chaptersFile,
+	}
+	opts.ExtraArgs = c.extraArgs(conver.MuxArgs(videoList, audioList, outputFile, opts))
+	args := conver.MuxArgs(videoList, audioList, outputFile, opts)
+	logrus.Debug("ffmpeg参数:", args)
+
+	var total time.Duration
+	if c.Progress || c.ProgressFunc != nil {
+		total = chapters[len(chapters)-1].End
+	}
+	if err = c.runFFmpeg(ctx, args, outputFile, total); err != nil {
+		return err
+	}
+	logrus.Infof("已合并%d个分P并添加章节标记: %s", len(pages), filepath.Base(outputFile))
+	return nil
+}
+
+// composeMerged -chapters时将plan中的分P合并为一个文件，r为prepareDir返回的结果
+func composeMerged(ctx context.Context, c *Config, v string, plan *dirPlan, r result) result {
+	pages, js := plan.pages, plan.js
+	outputFile := plan.outputs[0]
+	f := FileResult{Dir: v, Output: outputFile, Title: mustString(js.Get("title")),
+		Uname: mustString(js.Get("uname")), Group: mustString(js.Get("groupTitle"))}
+	var warnings []string
+	for _, p := range pages {
+		if p.Err != nil {
+			warnings = append(warnings, fmt.Sprintf("分P%d: %v", p.Index, p.Err))
+		}
+		if p.Video == "" || p.Audio == "" {
+			logrus.Warn("合并分P时缺少音频或视频文件，跳过:", p.Dir)
+			f.Error = fmt.Sprintf("分P%d的音视频文件不完整，无法合并", p.Index)
+		}
+	}
+	f.Warning = strings.Join(warnings, "\n")
+	if f.Error != "" {
+		r.files = append(r.files, f)
+		return r
+	}
+	overwrite, ok := c.handleCollision(&f, plan.owners[0])
+	if !ok {
+		r.files = append(r.files, f)
+		return r
+	}
+	removeTemp := func() {
+		for _, p := range pages {
+			c.RemoveTemp(p)
+		}
+	}
+	if !overwrite && c.AlreadyComposed(outputFile, v) {
+		logrus.Info("已合成过，跳过:", outputFile)
+		f.Success, f.Done = true, true
+		removeTemp()
+		r.files = append(r.files, f)
+		return r
+	}
+	if c.DryRun {
+		f.Success = true
+		for _, p := range pages {
+			f.Success = dryRun(c, p, outputFile) && f.Success
+		}
+		if !f.Success {
+			f.Error = "音视频文件不完整"
+		}
+		r.files = append(r.files, f)
+		return r
+	}
+	for _, p := range pages {
+		if p.Ass != "" {
+			logrus.Warn("合并分P时不添加弹幕:", v)
+			break
+		}
+	}
+	target := outputFile
+	if overwrite {
+		_ = os.Remove(outputFile)
+	} else if c.OverwriteIfBetter && c.Overlay == "-n" && Exist(outputFile) {
+		target = betterTemp(outputFile)
+		_ = os.Remove(target)
+	}
+	var read int64
+	for _, p := range pages {
+		read += fileSize(p.Video) + fileSize(p.Audio)
+	}
+	if er := c.MergePages(ctx, pages, plan.cover, target, plan.metadata); er != nil {
+		logrus.Error("合并分P失败:", er)
+		f.Error = er.Error()
+		r.diskFull = r.diskFull || removeOnDiskFull(er, target)
+	} else if er = c.VerifyOutput(target); er != nil {
+		logrus.Error("合成的文件不完整，已删除:", target, " ", er)
+		_ = os.Remove(target)
+		f.Error = "合成的文件不完整: " + er.Error()
+	} else if target != outputFile && !c.settleBetter(ctx, &f, target, outputFile, "") {
+		if f.Done {
+			removeTemp()
+		}
+	} else {
+		f.Success = true
+		f.Info = c.probeInfo(ctx, outputFile)
+		r.read, r.written = r.read+read, r.written+fileSize(outputFile)
+		c.preserveMtime(outputFile, v, js)
+		removeTemp()
+		if er = c.runHook(ctx, outputFile, plan.title, plan.uname); er != nil {
+			logrus.Error(er)
+			if f.Warning != "" {
+				f.Warning += "\n"
+			}
+			f.Warning += er.Error()
+		}
+	}
+	r.files = append(r.files, f)
+	return r
+}
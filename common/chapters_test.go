@@ -0,0 +1,111 @@
+package common
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestChapterMetadata(t *testing.T) {
+	tests := []struct {
+		name     string
+		chapters []Chapter
+		want     string
+	}{
+		{"无章节", nil, ";FFMETADATA1\n"},
+		{"两个章节", []Chapter{{"第一集", 0, 90 * time.Second}, {"第二集", 90 * time.Second, 200500 * time.Millisecond}},
+			";FFMETADATA1\n[CHAPTER]\nTIMEBASE=1/1000\nSTART=0\nEND=90000\ntitle=第一集\n" +
+				"[CHAPTER]\nTIMEBASE=1/1000\nSTART=90000\nEND=200500\ntitle=第二集\n"},
+		{"转义特殊字符", []Chapter{{`a=b;c#d\e`, 0, time.Second}},
+			";FFMETADATA1\n[CHAPTER]\nTIMEBASE=1/1000\nSTART=0\nEND=1000\ntitle=a\\=b\\;c\\#d\\\\e\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ChapterMetadata(tt.chapters); got != tt.want {
+				t.Errorf("ChapterMetadata() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConcatList(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []string
+		want  string
+	}{
+		{"普通路径", []string{"/a/1.mp4", "/a/2.mp4"}, "file '/a/1.mp4'\nfile '/a/2.mp4'\n"},
+		{"含空格和单引号", []string{"/a b/it's.mp4"}, "file '/a b/it'\\''s.mp4'\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := concatList(tt.files); got != tt.want {
+				t.Errorf("concatList() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPageChapters(t *testing.T) {
+	dir := t.TempDir()
+	var pages []Page
+	for i, title := range []string{"开头", " ", "结尾"} {
+		video := filepath.Join(dir, string(rune('a'+i))+".mp4")
+		if err := os.WriteFile(video, []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, Page{Dir: dir, Video: video, Title: title, Index: i + 1})
+	}
+	c := &Config{FFProbePath: "ffprobe", ProbeRunner: &fakeRunner{stdout: probeJson},
+		probes: &probeCache{infos: make(map[string]probeEntry)}}
+	got, err := c.pageChapters(context.Background(), pages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := 12500 * time.Millisecond
+	want := []Chapter{{"开头", 0, d}, {"P2", d, 2 * d}, {"结尾", 2 * d, 3 * d}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("pageChapters() = %v, want %v", got, want)
+	}
+
+	// 没有ffprobe也没有playurl时无法计算章节
+	if _, err = (&Config{}).pageChapters(context.Background(), pages); err == nil {
+		t.Error("无法获取时长时pageChapters()应返回错误")
+	}
+}
+
+func TestClaimOutputsMerged(t *testing.T) {
+	group := t.TempDir()
+	plan := &dirPlan{pages: []Page{{Index: 1}, {Index: 2}}, title: "甲", name: "甲", groupDir: group}
+	c := &Config{Chapters: true, owners: newOutputOwners(nil)}
+	c.claimOutputs("a", plan)
+	if want := []string{filepath.Join(group, "甲.mp4")}; !reflect.DeepEqual(plan.outputs, want) {
+		t.Errorf("-chapters时输出文件为%v，应为%v", plan.outputs, want)
+	}
+}
+
+func TestInitConfigChapters(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr bool
+	}{
+		{"-chapters", []string{"-chapters"}, false},
+		{"-chapters与-embed-sub", []string{"-chapters", "-embed-sub"}, false},
+		{"-chapters与-burn", []string{"-chapters", "-burn"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := initConfig(t, tt.args...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("InitConfig(%q) error = %v, wantErr %v", tt.args, err, tt.wantErr)
+			}
+			if err == nil && !c.Chapters {
+				t.Error("Chapters = false, want true")
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// checkTimeout -check执行ffmpeg的超时时间
+const checkTimeout = 30 * time.Second
+
+// CheckItem -check检查的一项ffmpeg功能
+type CheckItem struct {
+	Name     string // 编码器或滤镜名称
+	Usage    string // 用于哪种模式
+	OK       bool   // ffmpeg是否支持
+	Required bool   // 当前参数是否需要该功能
+}
+
+// CheckResult -check的检查结果
+type CheckResult struct {
+	FFmpegPath  string
+	FFProbePath string // 找不到ffprobe时为空
+	Version     string
+	Items       []CheckItem
+}
+
+// Failed 当前参数需要但ffmpeg不支持的功能
+func (r *CheckResult) Failed() []CheckItem {
+	var failed []CheckItem
+	for _, it := range r.Items {
+		if it.Required && !it.OK {
+			failed = append(failed, it)
+		}
+	}
+	return failed
+}
+
+// Print 打印检查结果
+func (r *CheckResult) Print(w io.Writer) {
+	_, _ = fmt.Fprintln(w, "ffmpeg路径:", r.FFmpegPath)
+	_, _ = fmt.Fprintln(w, "ffmpeg版本:", r.Version)
+	if r.FFProbePath != "" {
+		_, _ = fmt.Fprintln(w, "ffprobe路径:", r.FFProbePath)
+	} else {
+		_, _ = fmt.Fprintln(w, "ffprobe路径: 未找到，合成后不显示时长和分辨率")
+	}
+	for _, it := range r.Items {
+		status := "支持"
+		if !it.OK {
+			status = "不支持"
+			if it.Required {
+				status = "不支持（当前参数需要）"
+			}
+		}
+		usage := it.Usage + strings.Repeat(" ", 20-displayWidth(it.Usage)) // 按显示宽度对齐中文
+		_, _ = fmt.Fprintf(w, "  %-18s %s %s\n", it.Name, usage, status)
+	}
+}
+
+// CheckFFmpeg 检查ffmpeg能否运行及支持的编码器和滤镜，不读取缓存目录
+// Windows下使用自带的ffmpeg时先释放并校验文件
+func (c *Config) CheckFFmpeg(ctx context.Context) (*CheckResult, error) {
+	if c.FFMpegPath == "" {
+		if err := c.GetFFmpegPath(); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.verifyFFmpeg(); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	out, stderr, err := c.output(ctx, "-hide_banner", "-version")
+	if err != nil {
+		return nil, fmt.Errorf("无法运行ffmpeg %s：%w\n%s", c.FFMpegPath, err, bytes.TrimSpace(stderr))
+	}
+	version := ffmpegVersion(out)
+	if version == "" {
+		return nil, errors.New("无法识别ffmpeg版本，请确认 " + c.FFMpegPath + " 是ffmpeg")
+	}
+	encoders, _, err := c.output(ctx, "-hide_banner", "-encoders")
+	if err != nil {
+		return nil, fmt.Errorf("读取ffmpeg编码器失败：%w", err)
+	}
+	filters, _, err := c.output(ctx, "-hide_banner", "-filters")
+	if err != nil {
+		return nil, fmt.Errorf("读取ffmpeg滤镜失败：%w", err)
+	}
+	hasEncoder, hasFilter := ffmpegNames(encoders), ffmpegNames(filters)
+
+	r := &CheckResult{FFmpegPath: c.FFMpegPath, FFProbePath: c.FFProbePath, Version: version}
+	if r.FFProbePath == "" {
+		r.FFProbePath = findFFprobe(c.FFMpegPath)
+	}
+	// 不支持硬件编码器时合成会改用libx264，所以只有libx264是必需的
+	hw := c.HWAccel != "" && c.HWAccel != HWAccelNone && hasEncoder[hwEncoder[c.HWAccel]]
+	r.Items = []CheckItem{
+		{Name: "libx264", Usage: "-burn压制弹幕", OK: hasEncoder["libx264"], Required: c.Burn && !hw},
+		{Name: "ass", Usage: "-burn压制ass弹幕", OK: hasFilter["ass"],
+			Required: c.Burn && c.DanmakuFormat != DanmakuSrt && !c.AssOFF},
+		{Name: "subtitles", Usage: "-burn压制srt字幕", OK: hasFilter["subtitles"],
+			Required: c.Burn && c.DanmakuFormat == DanmakuSrt && !c.AssOFF},
+		{Name: "mov_text", Usage: "mp4/mov内嵌字幕", OK: hasEncoder["mov_text"],
+			Required: c.EmbedAss && c.Format != FormatMkv},
+		{Name: "libmp3lame", Usage: "-mp3提取音频", OK: hasEncoder["libmp3lame"], Required: c.Mp3 && c.AudioFormat == AudioMp3},
+		{Name: "flac", Usage: "-audio-format flac", OK: hasEncoder["flac"], Required: c.Mp3 && c.AudioFormat == AudioFlac},
+	}
+	if enc := hwEncoder[c.HWAccel]; c.HWAccel != "" && c.HWAccel != HWAccelNone {
+		r.Items = append(r.Items, CheckItem{Name: enc, Usage: "-hwaccel " + c.HWAccel, OK: hasEncoder[enc]})
+	}
+	return r, nil
+}
+
+// checkFeatures 需要重新编码或特定编码器时，合成前检查ffmpeg是否支持，不支持时返回错误，
+// 指定-fallback-copy时关闭不支持的功能，改为直接复制音视频流，返回改为复制的功能
+func (c *Config) checkFeatures(ctx context.Context) ([]string, error) {
+	embed := c.EmbedAss && c.Format != FormatMkv
+	encode := c.Mp3 && (c.AudioFormat == AudioMp3 || c.AudioFormat == AudioFlac)
+	if !c.Burn && !embed && !encode {
+		return nil, nil
+	}
+	r, err := c.CheckFFmpeg(ctx)
+	if err != nil {
+		// 读取不到编码器列表时不阻止合成，由合成时的ffmpeg报错
+		logrus.Warn("无法检查ffmpeg支持的编码器:", err)
+		c.probeHWAccel(nil)
+		return nil, nil
+	}
+	c.probeHWAccel(r)
+	failed := r.Failed()
+	if len(failed) == 0 {
+		return nil, nil
+	}
+	if !c.FallbackCopy {
+		var names []string
+		for _, it := range failed {
+			names = append(names, it.Name+"("+it.Usage+")")
+		}
+		return nil, fmt.Errorf("ffmpeg不支持%s，请换用完整版的ffmpeg，或加上-fallback-copy改为直接复制", strings.Join(names, "、"))
+	}
+	var fallback []string
+	for _, it := range failed {
+		var note string
+		switch it.Name {
+		case "libx264", "ass", "subtitles":
+			c.Burn, note = false, "不压制弹幕，直接复制视频流"
+		case "mov_text":
+			c.EmbedAss, note = false, "不内嵌字幕，弹幕文件复制到视频旁边"
+		case "libmp3lame", "flac":
+			c.AudioFormat, note = AudioAac, "音频直接复制为m4a"
+		default:
+			continue
+		}
+		fallback = append(fallback, fmt.Sprintf("ffmpeg不支持%s，%s", it.Name, note))
+		logrus.Warnf("ffmpeg不支持%s，无法使用%s，已改为%s", it.Name, it.Usage, note)
+	}
+	return fallback, nil
+}
+
+// ffmpegVersion 从ffmpeg -version的输出中读取版本号
+func ffmpegVersion(out []byte) string {
+	line, _, _ := bytes.Cut(bytes.TrimSpace(out), []byte("\n"))
+	fields := strings.Fields(string(line))
+	if len(fields) < 3 || fields[0] != "ffmpeg" || fields[1] != "version" {
+		return ""
+	}
+	return fields[2]
+}
+
+// ffmpegNames 读取ffmpeg -encoders和-filters输出的名称，每行第二列为名称
+func ffmpegNames(out []byte) map[string]bool {
+	names := make(map[string]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		if fields := strings.Fields(scanner.Text()); len(fields) >= 2 {
+			names[fields[1]] = true
+		}
+	}
+	return names
+}
@@ -0,0 +1,136 @@
+package common
+
+import (
+	"errors"
+	"m4s-converter/conver"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// isIntermediate 判断是否为合成过程中生成的中间文件：去掉文件头的音视频文件及转换的ass和srt
+// 原始的m4s、videoInfo和.playurl文件不会被删除；xml弹幕可能是客户端离线缓存的，由CleanTargets按状态文件判断
+func isIntermediate(name string) bool {
+	for _, suffix := range []string{conver.VideoSuffix, conver.AudioSuffix, conver.AssSuffix, conver.SrtSuffix} {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// CleanTargets 返回缓存目录dirs及其分P子目录中的中间文件，指定了-tmp时包括-tmp中对应的目录
+// 缓存目录中的xml弹幕只删除状态文件中记录为本程序下载的，客户端离线缓存的<cid>.xml会保留；-tmp中的xml都是本程序写入的
+func (c *Config) CleanTargets(dirs []string) []string {
+	downloaded := c.downloadedDanmaku(dirs)
+	seen := make(map[string]bool)
+	var files []string
+	walk := func(root string, ownXml bool) {
+		_ = filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if d.IsDir() {
+				if path != root && (d.Name() == "output" || isHiddenDir(d.Name())) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			generated := isIntermediate(d.Name()) ||
+				strings.HasSuffix(d.Name(), conver.XmlSuffix) && (ownXml || downloaded[path])
+			if generated && !seen[path] {
+				seen[path] = true
+				files = append(files, path)
+			}
+			return nil
+		})
+	}
+	for _, dir := range dirs {
+		walk(dir, false)
+		if c.Tmp != "" {
+			walk(c.tempDir(dir), true)
+		}
+	}
+	return files
+}
+
+// downloadedDanmaku 返回状态文件中记录的缓存目录dirs下载的xml弹幕，dirs可以是分P子目录
+func (c *Config) downloadedDanmaku(dirs []string) map[string]bool {
+	entries := make([]string, 0, len(dirs))
+	for _, d := range dirs {
+		if IsPageDir(d) {
+			d = filepath.Dir(d)
+		}
+		entries = append(entries, d)
+	}
+	downloaded := make(map[string]bool)
+	for _, s := range c.loadStates(entries) {
+		for _, f := range s.DanmakuFiles() {
+			downloaded[f] = true
+		}
+	}
+	return downloaded
+}
+
+// CleanableDirs 返回状态文件中记录为合成成功、且缓存文件未变化的缓存目录，用于单独执行-clean-only
+func (cv *Converter) CleanableDirs() ([]string, error) {
+	c := cv.Config
+	if len(c.CacheRoots()) == 0 {
+		return nil, errors.New("未指定 bilibili 缓存路径")
+	}
+	setM4sExts(c.M4sExts)
+	var dirs []string
+	for _, root := range c.CacheRoots() {
+		if isArchive(root) {
+			return nil, errors.New("zip缓存不能使用-clean和-clean-only清理：" + root)
+		}
+		found, err := c.cacheDirs(root)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range found {
+			if !IsPageDir(v) {
+				dirs = append(dirs, v)
+			}
+		}
+	}
+	states := c.loadStates(dirs)
+	var cleanable []string
+	for _, v := range dirs {
+		if fp, err := Fingerprint(v); err == nil && states[c.outputRoot(v)].Unchanged(v, fp) {
+			cleanable = append(cleanable, v)
+		}
+	}
+	return cleanable, nil
+}
+
+// FilesSize 返回文件的总大小
+func FilesSize(files []string) int64 {
+	var total int64
+	for _, f := range files {
+		if info, err := os.Stat(f); err == nil {
+			total += info.Size()
+		}
+	}
+	return total
+}
+
+// RemoveFiles 删除文件，返回释放的空间，删除失败的文件不影响其它文件
+func RemoveFiles(files []string) (reclaimed int64, err error) {
+	var errs []error
+	for _, f := range files {
+		info, e := os.Stat(f)
+		if e != nil {
+			continue
+		}
+		if e = os.Remove(f); e != nil {
+			errs = append(errs, e)
+			continue
+		}
+		logrus.Debug("已删除:", f)
+		reclaimed += info.Size()
+	}
+	return reclaimed, errors.Join(errs...)
+}
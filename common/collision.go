@@ -0,0 +1,80 @@
+package common
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// -on-collision可选的处理方式
+const (
+	CollisionSkip      = "skip"      // 跳过后处理的视频
+	CollisionOverwrite = "overwrite" // 后处理的视频覆盖已有文件
+	CollisionRename    = "rename"    // 后处理的视频在文件名后加上 (2)、(3)...
+)
+
+// outputOwners 记录每个输出文件属于哪个缓存目录，用于发现不同视频的输出文件名相同，如同名的视频组中title相同的视频
+type outputOwners struct {
+	mu   sync.Mutex
+	dirs map[string]string // 输出文件对应的缓存目录
+}
+
+// newOutputOwners 从状态文件中读取上次合成的文件属于的缓存目录，只记录仍然存在的文件，合成成功的目录优先
+func newOutputOwners(states map[string]*State) *outputOwners {
+	o := &outputOwners{dirs: make(map[string]string)}
+	for _, success := range []bool{true, false} {
+		for _, s := range states {
+			for dir, d := range s.Dirs {
+				if d.Success != success {
+					continue
+				}
+				for _, f := range d.Outputs {
+					if _, ok := o.dirs[f]; !ok && Exist(f) {
+						o.dirs[f] = dir
+					}
+				}
+			}
+		}
+	}
+	return o
+}
+
+// collisionPolicy 返回输出文件名相同时的处理方式，未指定-on-collision时按-o覆盖，否则跳过
+func (c *Config) collisionPolicy() string {
+	if c.OnCollision != "" {
+		return c.OnCollision
+	}
+	if c.Overlay == "-y" {
+		return CollisionOverwrite
+	}
+	return CollisionSkip
+}
+
+// claimOutput 为缓存目录dir申请输出文件outputFile，返回实际使用的文件和已使用该文件名的其它缓存目录，没有冲突时owner为空
+// rename时返回加上序号后未被其它视频使用的文件，上次已改名合成的文件仍使用原来的序号
+func (c *Config) claimOutput(dir, outputFile string) (file, owner string) {
+	o := c.owners
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	owner = o.dirs[outputFile]
+	if owner == "" || owner == dir {
+		o.dirs[outputFile] = dir
+		return outputFile, ""
+	}
+	switch c.collisionPolicy() {
+	case CollisionOverwrite:
+		o.dirs[outputFile] = dir
+	case CollisionRename:
+		ext := filepath.Ext(outputFile)
+		base := strings.TrimSuffix(outputFile, ext)
+		for n := 2; ; n++ {
+			file = fmt.Sprintf("%s (%d)%s", base, n, ext)
+			if d := o.dirs[file]; d == dir || d == "" && !Exist(file) {
+				o.dirs[file] = dir
+				return file, owner
+			}
+		}
+	}
+	return outputFile, owner
+}
@@ -0,0 +1,83 @@
+package common
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestClaimOutputs(t *testing.T) {
+	group := t.TempDir()
+	out := func(name string) string { return filepath.Join(group, name) }
+	single := func(name string) *dirPlan {
+		return &dirPlan{pages: []Page{{Index: 1}}, title: name, name: name, groupDir: group}
+	}
+	tests := []struct {
+		name   string
+		policy string
+		dirs   []string
+		plans  []*dirPlan
+		want   [][]string
+		owners [][]string
+	}{
+		{"不冲突", CollisionRename, []string{"a", "b"},
+			[]*dirPlan{single("甲"), single("乙")},
+			[][]string{{out("甲.mp4")}, {out("乙.mp4")}},
+			[][]string{{""}, {""}}},
+		{"rename按目录顺序加序号", CollisionRename, []string{"a", "b", "c"},
+			[]*dirPlan{single("甲"), single("甲"), single("甲")},
+			[][]string{{out("甲.mp4")}, {out("甲 (2).mp4")}, {out("甲 (3).mp4")}},
+			[][]string{{""}, {"a"}, {"a"}}},
+		{"skip时后面的目录记录冲突", CollisionSkip, []string{"a", "b"},
+			[]*dirPlan{single("甲"), single("甲")},
+			[][]string{{out("甲.mp4")}, {out("甲.mp4")}},
+			[][]string{{""}, {"a"}}},
+		{"多P视频按分P命名", CollisionRename, []string{"a"},
+			[]*dirPlan{{pages: []Page{{Index: 1, Title: "甲"}, {Index: 2, Title: "第二集"}}, title: "甲", name: "甲", groupDir: group}},
+			[][]string{{out("甲-P1.mp4"), out("甲-P2 第二集.mp4")}},
+			[][]string{{"", ""}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 多次运行时输出文件名不变
+			for n := 0; n < 3; n++ {
+				c := &Config{OnCollision: tt.policy, owners: newOutputOwners(nil)}
+				for i, plan := range tt.plans {
+					c.claimOutputs(tt.dirs[i], plan)
+					if !reflect.DeepEqual(plan.outputs, tt.want[i]) {
+						t.Errorf("%s的输出文件为%v，应为%v", tt.dirs[i], plan.outputs, tt.want[i])
+					}
+					if !reflect.DeepEqual(plan.owners, tt.owners[i]) {
+						t.Errorf("%s的冲突目录为%v，应为%v", tt.dirs[i], plan.owners, tt.owners[i])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestInitConfigOnCollision(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		policy  string
+		wantErr bool
+	}{
+		{"默认跳过", nil, CollisionSkip, false},
+		{"-o时覆盖", []string{"-o"}, CollisionOverwrite, false},
+		{"rename", []string{"-on-collision", CollisionRename}, CollisionRename, false},
+		{"指定时优先于-o", []string{"-o", "-on-collision", CollisionSkip}, CollisionSkip, false},
+		{"不支持的处理方式", []string{"-on-collision", "merge"}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := initConfig(t, tt.args...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("InitConfig(%q) error = %v, wantErr %v", tt.args, err, tt.wantErr)
+			}
+			if err == nil && c.collisionPolicy() != tt.policy {
+				t.Errorf("collisionPolicy() = %q, want %q", c.collisionPolicy(), tt.policy)
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package common
+
+import (
+	"gopkg.in/yaml.v3"
+	"os"
+)
+
+// ConfigFile 工作目录下的配置文件
+var ConfigFile = "config.yaml"
+
+// fileConfig 配置文件内容，字段含义与同名命令行参数一致
+type fileConfig struct {
+	CachePath  string `yaml:"cachePath"`  // 同 -c
+	FFMpegPath string `yaml:"ffmpegPath"` // 同 -f
+	AssOFF     bool   `yaml:"assOff"`     // 同 -a
+	Overlay    bool   `yaml:"overlay"`    // 同 -o
+	LogLevel   string `yaml:"logLevel"`   // 日志级别，debug、info、warn或error，-quiet和-verbose优先
+}
+
+// LoadFile 读取配置文件并填充Config
+// 优先级：命令行参数 > 配置文件 > 默认值，配置文件中的值会作为命令行参数的默认值
+func (c *Config) LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var fc fileConfig
+	if err = yaml.Unmarshal(data, &fc); err != nil {
+		return err
+	}
+	c.CachePath = fc.CachePath
+	c.FFMpegPath = fc.FFMpegPath
+	c.AssOFF = fc.AssOFF
+	c.LogLevel = fc.LogLevel
+	c.Overlay = "-n"
+	if fc.Overlay {
+		c.Overlay = "-y"
+	}
+	return nil
+}
@@ -0,0 +1,707 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/bitly/go-simplejson"
+	"github.com/sirupsen/logrus"
+	"m4s-converter/conver"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Converter 合成bilibili缓存中的音视频文件，出错时只返回错误，不弹窗也不退出程序，可作为库使用
+type Converter struct {
+	Config *Config
+}
+
+// NewConverter 使用配置创建Converter
+func NewConverter(c *Config) *Converter {
+	return &Converter{Config: c}
+}
+
+// Run 查找并合成缓存路径下的所有视频，ctx取消时停止合成，返回本次运行的结果报告
+func (cv *Converter) Run(ctx context.Context) (RunReport, error) {
+	c := cv.Config
+	begin := time.Now()
+	var report RunReport
+	if err := c.Prepare(); err != nil {
+		return report, err
+	}
+	defer c.closeArchives()
+	fallback, err := c.checkFeatures(ctx)
+	if err != nil {
+		return report, err
+	}
+	report.Fallback = fallback
+
+	// 依次查找每个缓存路径下的缓存目录，合并后一起合成
+	var dirs []string
+	failed := make(map[string]*M4sError) // m4s转换失败的缓存目录
+	for _, root := range c.CacheRoots() {
+		found, f, err := c.findCacheDirs(root)
+		if err != nil {
+			return report, err
+		}
+		dirs = append(dirs, found...)
+		for dir, e := range f {
+			failed[dir] = e
+		}
+	}
+
+	if c.hasIDFilter() && len(dirs) == 0 {
+		return report, fmt.Errorf("缓存中找不到%s的视频", c.idString())
+	}
+
+	// 分P子目录随所在的缓存目录一起合成
+	var entries []string
+	for _, v := range dirs {
+		if !IsPageDir(v) {
+			entries = append(entries, v)
+		}
+	}
+	dirs = entries
+
+	// 合成音视频文件，按-j指定的数量并发合成
+	results := make([]result, len(dirs))
+	states := c.loadStates(dirs)
+	c.owners = newOutputOwners(states)
+	fingerprints := make([]string, len(dirs))
+	unchanged := make([]bool, len(dirs))
+	for i, v := range dirs {
+		fingerprints[i], _ = Fingerprint(v)
+		if e := failed[v]; e != nil {
+			// 音视频不完整，合成必定失败，直接记为跳过
+			results[i].skipped, results[i].file = "m4s文件转换失败", e.File
+			if errors.Is(e, ErrBadM4sHeader) {
+				results[i].skipped = "m4s文件已损坏"
+			}
+			logrus.Error("m4s文件转换失败，跳过目录:", v)
+			continue
+		}
+		// 通过-cid或-bvid指定视频或只导出轨道时通常是为了排查问题，不跳过
+		if !c.Refresh && !c.hasIDFilter() && !c.VideoOnly && !c.AudioOnly && fingerprints[i] != "" && states[c.outputRoot(v)].Unchanged(v, fingerprints[i]) {
+			logrus.Info("缓存目录未变化，跳过:", v)
+			results[i].skipped = SkipUnchanged
+			unchanged[i] = true
+		}
+	}
+	c.checkFreeSpace(dirs, unchanged)
+
+	// 磁盘空间不足时剩余的目录也会失败，取消后停止合成
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var diskFull atomic.Bool
+	stopOnDiskFull := func(r result) {
+		if r.diskFull && !diskFull.Swap(true) {
+			logrus.Error("磁盘空间不足，停止合成剩余的目录")
+			cancel()
+		}
+	}
+	var todo []int
+	for i := range dirs {
+		if !unchanged[i] && results[i].skipped == "" {
+			todo = append(todo, i)
+		}
+	}
+	// 先并发读取videoInfo和下载弹幕，再按目录顺序依次确定输出文件，
+	// 同名时由排在前面的目录使用原文件名，不随-j并发合成的完成顺序变化，最后并发合成
+	plans := make([]*dirPlan, len(dirs))
+	c.forEachDir(runCtx, todo, func(i int) {
+		plans[i], results[i] = prepareDir(runCtx, c, i+1, dirs[i])
+		stopOnDiskFull(results[i])
+	})
+	for _, i := range todo {
+		if plans[i] != nil && runCtx.Err() == nil {
+			c.claimOutputs(dirs[i], plans[i])
+		}
+	}
+	c.forEachDir(runCtx, todo, func(i int) {
+		if plans[i] != nil && plans[i].outputs != nil {
+			results[i] = composePlan(runCtx, c, dirs[i], plans[i], results[i])
+			stopOnDiskFull(results[i])
+		}
+	})
+
+	c.saveStates(states, dirs, fingerprints, results, unchanged)
+	c.writePlaylists(results)
+
+	for i, r := range results {
+		if r.skipped != "" {
+			report.Skipped = append(report.Skipped, SkippedDir{Dir: c.zipDir(dirs[i]), Reason: r.skipped, File: c.zipDir(r.file)})
+		}
+		report.Read += r.read
+		report.Written += r.written
+		for _, f := range r.files {
+			f.Dir = c.zipDir(f.Dir)
+			report.Files = append(report.Files, f)
+			if f.Done {
+				report.Done = append(report.Done, f.Output)
+			} else if f.Success {
+				report.OutputDir = r.outputDir
+				report.Composed = append(report.Composed, f.Output)
+			}
+		}
+	}
+	report.Elapsed = int64(time.Since(begin).Seconds())
+	report.Interrupted = ctx.Err() != nil
+	report.DiskFull = diskFull.Load()
+	return report, nil
+}
+
+// forEachDir 按-j指定的数量并发对todo中的每个目录序号执行fn，ctx取消后不再执行剩余的目录
+func (c *Config) forEachDir(ctx context.Context, todo []int, fn func(i int)) {
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for n := 0; n < c.Jobs; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				fn(i)
+			}
+		}()
+	}
+dispatch:
+	for _, i := range todo {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// outputRoot 返回缓存目录v的合成文件根目录，指定了-out时为-out目录
+func (c *Config) outputRoot(v string) string {
+	if c.Out != "" {
+		return c.Out
+	}
+	if out := c.archiveOutput(v); out != "" {
+		return out
+	}
+	return filepath.Join(filepath.Dir(v), "output")
+}
+
+// loadStates 读取每个输出根目录中的状态文件
+func (c *Config) loadStates(dirs []string) map[string]*State {
+	states := make(map[string]*State)
+	for _, v := range dirs {
+		root := c.outputRoot(v)
+		if states[root] != nil {
+			continue
+		}
+		states[root] = &State{}
+		if err := states[root].Load(filepath.Join(root, StateFile)); err != nil {
+			logrus.Warn("读取状态文件失败，重新合成:", err)
+		}
+	}
+	return states
+}
+
+// saveStates 记录本次合成的结果并写入状态文件，dry-run和只导出轨道时不写入
+func (c *Config) saveStates(states map[string]*State, dirs, fingerprints []string, results []result, unchanged []bool) {
+	if c.DryRun || c.VideoOnly || c.AudioOnly {
+		return
+	}
+	for i, r := range results {
+		if unchanged[i] || fingerprints[i] == "" || r.files == nil && r.skipped == "" {
+			continue // 未变化或未处理（中断）的目录保留原有状态
+		}
+		d := DirState{Fingerprint: fingerprints[i], Success: r.skipped == ""}
+		// 上次下载的弹幕本次作为本地弹幕使用，仍需记录，-clean时才能删除
+		d.Danmaku = states[c.outputRoot(dirs[i])].Danmaku(dirs[i])
+		recorded := make(map[string]bool)
+		for _, f := range d.Danmaku {
+			recorded[f] = true
+		}
+		for _, f := range r.danmaku {
+			if !recorded[f] {
+				d.Danmaku = append(d.Danmaku, f)
+			}
+		}
+		for _, f := range r.files {
+			d.Success = d.Success && f.Success
+			d.Outputs = append(d.Outputs, f.Output)
+		}
+		states[c.outputRoot(dirs[i])].Record(dirs[i], d)
+	}
+	for root, s := range states {
+		if !Exist(root) {
+			continue
+		}
+		if err := s.Save(filepath.Join(root, StateFile)); err != nil {
+			logrus.Warn("写入状态文件失败:", err)
+		}
+	}
+}
+
+// findCacheDirs 将缓存路径root下的m4s文件转换为音视频文件，并返回其中的缓存目录和m4s转换失败的缓存目录
+func (c *Config) findCacheDirs(root string) ([]string, map[string]*M4sError, error) {
+	if c.hasIDFilter() {
+		return c.findCacheDirsByID(root)
+	}
+	// zip缓存先解压缓存目录中的m4s文件
+	if dirs, err := c.cacheDirs(root); err != nil {
+		return nil, nil, err
+	} else if err = c.extractM4s(root, dirs); err != nil {
+		return nil, nil, err
+	}
+	// 查找m4s文件，并转换为mp4和mp3
+	files, err := listM4sFiles(root)
+	if err != nil {
+		return nil, nil, fmt.Errorf("找不到 bilibili 目录下的 m4s 文件：%w", err)
+	}
+	err = c.ConvertM4sFiles(files)
+	if isDiskFull(err) {
+		return nil, nil, fmt.Errorf("%w，转换m4s文件失败：%v", ErrDiskFull, err)
+	} else if err != nil {
+		logrus.Error("部分m4s文件转换失败，对应的目录将被跳过:\n", err)
+	}
+	dirs, e := c.cacheDirs(root)
+	return dirs, m4sFailures(err), e
+}
+
+// findCacheDirsByID 只转换与-cid或-bvid匹配的缓存目录中的m4s文件，并返回这些目录
+func (c *Config) findCacheDirsByID(root string) ([]string, map[string]*M4sError, error) {
+	dirs, err := c.cacheDirs(root)
+	if err != nil {
+		return nil, nil, err
+	}
+	dirs = c.filterByID(dirs)
+	if err = c.extractM4s(root, dirs); err != nil {
+		return nil, nil, err
+	}
+	var files []string
+	for _, d := range dirs {
+		found, err := listM4sFiles(d)
+		if err != nil {
+			return nil, nil, fmt.Errorf("找不到 bilibili 目录下的 m4s 文件：%w", err)
+		}
+		logrus.Infof("%s 中有%d个m4s文件", d, len(found))
+		files = append(files, found...)
+	}
+	err = c.ConvertM4sFiles(files)
+	if isDiskFull(err) {
+		return nil, nil, fmt.Errorf("%w，转换m4s文件失败：%v", ErrDiskFull, err)
+	} else if err != nil {
+		logrus.Error("部分m4s文件转换失败，对应的目录将被跳过:\n", err)
+	}
+	return dirs, m4sFailures(err), nil
+}
+
+// cacheDirs 返回缓存路径root下的缓存目录，root本身为缓存目录时返回root
+func (c *Config) cacheDirs(root string) ([]string, error) {
+	if err := checkCacheRoot(root, c.zipDir(root)); err != nil {
+		return nil, err
+	}
+	dirs, err := c.GetCacheDir(root) // 缓存根目录模式
+	if err != nil {
+		return nil, fmt.Errorf("找不到 bilibili 的缓存目录：%w", err)
+	}
+
+	if dirs == nil {
+		// 判断非缓存根目录时，验证是否为子目录
+		if Exist(filepath.Join(root, conver.VideoInfoSuffix)) ||
+			Exist(filepath.Join(root, conver.VideoInfoJson)) {
+			dirs = append(dirs, root)
+		}
+	}
+	return dirs, nil
+}
+
+// result 单个缓存目录的合成结果
+type result struct {
+	outputDir string
+	files     []FileResult // 每个输出文件的合成结果，分P视频有多个
+	skipped   string       // 跳过的原因，为空表示未跳过
+	diskFull  bool         // 是否因磁盘空间不足失败
+	file      string       // 导致跳过的文件，如损坏的m4s
+	read      int64        // 合成成功的文件读取的音视频文件总大小
+	written   int64        // 合成成功的文件总大小
+	danmaku   []string     // 本次下载的xml弹幕
+}
+
+// dirPlan 准备合成的缓存目录，prepareDir读取videoInfo和音视频文件后生成，claimOutputs确定输出文件后由composePlan合成
+type dirPlan struct {
+	pages    []Page
+	js       *simplejson.Json
+	title    string
+	uname    string
+	name     string // 输出文件名（不含扩展名），多P视频再加上分P序号和名称
+	groupDir string
+	metadata map[string]string
+	cover    string
+	forced   bool     // 未缓存完成，通过-force强制合成
+	outputs  []string // 每个分P的输出文件
+	owners   []string // 每个分P的输出文件与之同名的其它缓存目录，没有冲突时为空
+}
+
+// prepareDir 查找缓存目录下的音视频文件并下载弹幕，读取videoInfo生成输出文件名，index为目录序号
+// 目录被跳过时返回的plan为nil，跳过的原因在r.skipped中
+func prepareDir(ctx context.Context, c *Config, index int, v string) (plan *dirPlan, r result) {
+	pages, e := c.GetAudioAndVideo(ctx, v)
+	for _, p := range pages {
+		if p.Xml != "" {
+			r.danmaku = append(r.danmaku, p.Xml)
+		}
+	}
+	if len(pages) == 0 {
+		logrus.Error("找不到已修复的音频和视频文件:", v, e)
+		r.skipped = "找不到音视频文件"
+		return
+	}
+	if c.hasIDFilter() {
+		logDiagnostics(v, pages)
+	}
+	info := filepath.Join(v, conver.VideoInfoJson)
+	if !Exist(info) {
+		info = filepath.Join(v, conver.VideoInfoSuffix)
+	}
+	infoStr, e := os.ReadFile(info)
+	if e != nil {
+		logrus.Error("找不到videoInfo相关文件: ", info)
+		r.skipped = "找不到videoInfo文件"
+		return
+	}
+	js, errb := parseVideoInfo(infoStr)
+	if errb != nil {
+		logrus.Error("videoInfo相关文件解析失败: ", info)
+		r.skipped = "videoInfo文件解析失败"
+		return
+	}
+	// 视频名称为空或过滤后为空时（如-ascii-safe时全是中文）使用cid，视频组名称过滤后为空时使用视频名称
+	title := c.filterName(mustString(js.Get("title")))
+	if title == "" {
+		title = Filter(dirCid(v))
+	}
+	groupTitle := c.filterName(mustString(js.Get("groupTitle")))
+	if groupTitle == "" && mustString(js.Get("groupTitle")) != "" {
+		groupTitle = title
+	}
+	uname := c.filterName(mustString(js.Get("uname")))
+
+	if !c.Selected(map[string]string{
+		MatchTitle:      mustString(js.Get("title")),
+		MatchGroupTitle: mustString(js.Get("groupTitle")),
+		MatchUname:      mustString(js.Get("uname")),
+	}) {
+		logrus.Debug("不匹配筛选条件，跳过:", v)
+		r.skipped = SkipFiltered
+		return
+	}
+	status := cacheStatus(js)
+	forced := status < c.MinStatus && c.Force
+	if status < c.MinStatus && !forced {
+		r.skipped = statusReason(js, status)
+		logrus.Warn(r.skipped, ",跳过合成", v, title+"-"+uname)
+		return
+	}
+	if forced {
+		logrus.Warnf("!!! 缓存状态为%s，已通过-force强制合成: %s %s", statusReason(js, status), v, title+"-"+uname)
+	} else if status < conver.StatusCompleted {
+		logrus.Warnf("缓存状态为%s，可能不完整: %s %s", statusReason(js, status), v, title+"-"+uname)
+	}
+	if c.RequireDanmaku {
+		for _, p := range pages {
+			if err := danmakuIssue(p.Err); err != nil {
+				r.skipped = "弹幕不完整，按-require-danmaku跳过合成: " + err.Error()
+				logrus.Error(r.skipped, " ", v)
+				return
+			}
+		}
+	}
+	r.outputDir = c.outputRoot(v)
+	groupDir := c.GroupDir(r.outputDir, groupTitle, uname)
+	if !c.DryRun {
+		if !Exist(groupDir) {
+			if err := os.MkdirAll(groupDir, os.ModePerm); err != nil {
+				logrus.Error("无法创建目录：", groupDir, " ", err)
+				r.skipped = "无法创建输出目录"
+				r.diskFull = isDiskFull(err)
+				return
+			}
+		}
+	}
+	name := c.OutputName(NameData{
+		Title:      title,
+		Uname:      uname,
+		GroupTitle: groupTitle,
+		Index:      index,
+	})
+	metadata := map[string]string{
+		"title":   mustString(js.Get("title")),
+		"artist":  mustString(js.Get("uname")),
+		"comment": mustString(js.Get("groupTitle")),
+	}
+	var cover string
+	if c.Cover && !c.Mp3 && !c.DryRun {
+		cover = c.coverImage(ctx, v, js)
+	}
+	return &dirPlan{pages: pages, js: js, title: title, uname: uname, name: name, groupDir: groupDir,
+		metadata: metadata, cover: cover, forced: forced}, r
+}
+
+// claimOutputs 为plan中的每个分P申请输出文件，Run中按目录顺序依次调用，
+// 文件名冲突时先申请的目录使用原文件名，与-j并发合成的完成顺序无关，每次运行的结果相同
+func (c *Config) claimOutputs(v string, plan *dirPlan) {
+	if c.mergePages(plan) {
+		// -chapters时分P合并为一个文件，与单P视频命名相同
+		file, owner := c.claimOutput(v, filepath.Join(plan.groupDir, c.withSuffix(plan.name)+c.OutputSuffix()))
+		plan.outputs, plan.owners = []string{file}, []string{owner}
+		return
+	}
+	plan.outputs = make([]string, len(plan.pages))
+	plan.owners = make([]string, len(plan.pages))
+	for i, p := range plan.pages {
+		// 多P视频按分P序号和名称分别命名，单P视频保持原有命名
+		pageName := plan.name
+		if len(plan.pages) > 1 {
+			pageName = fmt.Sprintf("%s-P%d", plan.name, p.Index)
+			if pt := c.filterName(p.Title); pt != "" && pt != plan.title {
+				pageName += " " + pt
+			}
+		}
+		plan.outputs[i], plan.owners[i] = c.claimOutput(v, filepath.Join(plan.groupDir, c.withSuffix(pageName)+c.OutputSuffix()))
+	}
+}
+
+// composePlan 合成plan中的每个分P，r为prepareDir返回的结果
+func composePlan(ctx context.Context, c *Config, v string, plan *dirPlan, r result) result {
+	if c.mergePages(plan) {
+		return composeMerged(ctx, c, v, plan, r)
+	}
+	pages, js, title, uname := plan.pages, plan.js, plan.title, plan.uname
+	metadata, cover, forced := plan.metadata, plan.cover, plan.forced
+	for i, p := range pages {
+		if ctx.Err() != nil {
+			return r
+		}
+		outputFile, owner := plan.outputs[i], plan.owners[i]
+		f := FileResult{Dir: p.Dir, Output: outputFile, Title: mustString(js.Get("title")),
+			Uname: mustString(js.Get("uname")), Group: mustString(js.Get("groupTitle"))}
+		if len(pages) > 1 {
+			f.Page = p.Index
+		}
+		if p.Err != nil {
+			f.Warning = p.Err.Error()
+			if danmakuIssue(p.Err) != nil {
+				f.Warning += "\n弹幕为可选，已合成不含弹幕的视频，需要时加上-require-danmaku跳过"
+			}
+		}
+		if forced && (p.Audio == "" && c.needAudio() || p.Video == "" && c.needVideo()) {
+			logrus.Warn("强制合成时缺少音频或视频文件，跳过:", p.Dir)
+			f.Error = "音视频文件不完整"
+			r.files = append(r.files, f)
+			continue
+		}
+		overwrite, ok := c.handleCollision(&f, owner)
+		if !ok {
+			r.files = append(r.files, f)
+			continue
+		}
+		if !overwrite && c.AlreadyComposed(outputFile, p.Dir) {
+			logrus.Info("已合成过，跳过:", outputFile)
+			f.Success, f.Done = true, true
+			c.RemoveTemp(p)
+			r.files = append(r.files, f)
+			continue
+		}
+		if c.DryRun {
+			if f.Success = dryRun(c, p, outputFile); !f.Success {
+				f.Error = "音视频文件不完整"
+			}
+			r.files = append(r.files, f)
+			continue
+		}
+		// -overwrite-if-better时先合成到临时文件，比已有文件好时再替换
+		target := outputFile
+		if overwrite {
+			// 已有文件属于其它视频，不比较直接覆盖
+			_ = os.Remove(outputFile)
+		} else if c.OverwriteIfBetter && c.Overlay == "-n" && Exist(outputFile) {
+			target = betterTemp(outputFile)
+			_ = os.Remove(target) // 上次中断时留下的临时文件
+		}
+		// 合成成功后中间文件会被删除，先读取大小
+		var read int64
+		if c.needAudio() {
+			read += fileSize(p.Audio)
+		}
+		if c.needVideo() {
+			read += fileSize(p.Video)
+		}
+		if c.Mp3 || c.VideoOnly || c.AudioOnly {
+			var er error
+			switch {
+			case c.VideoOnly:
+				er = exportTrack(p.Video, target)
+			case c.AudioOnly:
+				er = exportTrack(p.Audio, target)
+			default:
+				er = c.ExtractAudio(ctx, p.Audio, target)
+			}
+			if er != nil {
+				logrus.Error("提取音频或视频失败:", er)
+				f.Error = er.Error()
+				r.diskFull = r.diskFull || removeOnDiskFull(er, target)
+			} else if target != outputFile && !c.settleBetter(ctx, &f, target, outputFile, "") {
+				if f.Done {
+					c.RemoveTemp(p)
+				}
+			} else {
+				f.Success = true
+				r.read, r.written = r.read+read, r.written+fileSize(outputFile)
+				c.preserveMtime(outputFile, p.Dir, js)
+				c.RemoveTemp(p)
+			}
+			r.files = append(r.files, f)
+			continue
+		}
+		if diff, msg := c.checkSync(ctx, p.Video, p.Audio); msg != "" {
+			f.Desync = diff
+			if c.StrictSync {
+				logrus.Error(msg, "，按-strict-sync跳过合成: ", p.Dir)
+				f.Error = msg
+				r.files = append(r.files, f)
+				continue
+			}
+			logrus.Warn(msg, ": ", p.Dir)
+			if f.Warning != "" {
+				f.Warning += "\n"
+			}
+			f.Warning += msg
+		}
+		if er := c.Composition(ctx, p.Video, p.Audio, p.Ass, cover, target, metadata); er != nil {
+			logrus.Error("合成失败:", er)
+			f.Error = er.Error()
+			r.diskFull = r.diskFull || removeOnDiskFull(er, target)
+		} else if er = c.VerifyOutput(target); er != nil {
+			// 合成的文件不完整，删除后记录为失败
+			logrus.Error("合成的文件不完整，已删除:", target, " ", er)
+			_ = os.Remove(target)
+			f.Error = "合成的文件不完整: " + er.Error()
+		} else if target != outputFile && !c.settleBetter(ctx, &f, target, outputFile, p.Ass) {
+			if f.Done {
+				c.RemoveTemp(p)
+			}
+		} else {
+			f.Success = true
+			f.Info = c.probeInfo(ctx, outputFile)
+			r.read, r.written = r.read+read, r.written+fileSize(outputFile)
+			c.preserveMtime(outputFile, p.Dir, js) // 在-exec之前修改，命令可能会移走文件
+			c.RemoveTemp(p)
+			if er = c.runHook(ctx, outputFile, title, uname); er != nil {
+				logrus.Error(er)
+				if f.Warning != "" {
+					f.Warning += "\n"
+				}
+				f.Warning += er.Error()
+			}
+		}
+		r.files = append(r.files, f)
+	}
+	return r
+}
+
+// handleCollision 输出文件f.Output与缓存目录owner的视频同名时按-on-collision处理，rename时f.Output已改为不冲突的文件名，
+// 返回是否需要覆盖已有文件，跳过时返回的ok为false
+func (c *Config) handleCollision(f *FileResult, owner string) (overwrite, ok bool) {
+	if owner == "" {
+		return false, true
+	}
+	switch c.collisionPolicy() {
+	case CollisionSkip:
+		logrus.Warn("输出文件与", c.zipDir(owner), "的视频同名，跳过:", f.Output)
+		f.Error = "输出文件与" + c.zipDir(owner) + "的视频同名"
+		return false, false
+	case CollisionOverwrite:
+		logrus.Warn("输出文件与", c.zipDir(owner), "的视频同名，将覆盖:", f.Output)
+		return true, true
+	case CollisionRename:
+		logrus.Info("输出文件与", c.zipDir(owner), "的视频同名，改为:", f.Output)
+	}
+	return false, true
+}
+
+// danmakuIssue 返回分P问题中的弹幕下载或转换失败，没有时返回nil
+func danmakuIssue(err error) error {
+	if err == nil {
+		return nil
+	}
+	errs := []error{err}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		errs = joined.Unwrap()
+	}
+	for _, e := range errs {
+		if errors.Is(e, ErrDanmakuDownload) || errors.Is(e, ErrDanmakuConvert) {
+			return e
+		}
+	}
+	return nil
+}
+
+// fileSize 返回文件大小，文件不存在时为0
+func fileSize(path string) int64 {
+	if fi, err := os.Stat(path); err == nil {
+		return fi.Size()
+	}
+	return 0
+}
+
+// probeInfo 读取合成文件的信息，失败时只记录日志
+func (c *Config) probeInfo(ctx context.Context, outputFile string) *ProbeInfo {
+	info, err := c.Probe(ctx, outputFile)
+	if err != nil {
+		logrus.Debug("读取合成文件信息失败:", err)
+		return nil
+	}
+	return info
+}
+
+// logDiagnostics 通过-cid或-bvid只处理一个视频时，打印找到的文件，便于排查问题
+func logDiagnostics(v string, pages []Page) {
+	if js, err := readVideoInfo(v); err == nil {
+		data, _ := js.EncodePretty()
+		logrus.Infof("videoInfo:\n%s", data)
+	}
+	logrus.Infof("时长: %v，共%d个分P", GetDuration(v), len(pages))
+	for _, p := range pages {
+		logrus.Infof("分P%d %s\n  目录: %s\n  视频: %s\n  音频: %s\n  弹幕: %s", p.Index, p.Title, p.Dir, p.Video, p.Audio, p.Ass)
+		if p.Err != nil {
+			logrus.Warnf("分P%d的问题:\n%v", p.Index, p.Err)
+		}
+	}
+}
+
+// dryRun 只打印将要合成的音视频文件和输出文件，不执行ffmpeg，返回输入文件是否齐全
+func dryRun(c *Config, p Page, outputFile string) bool {
+	ok := true
+	if c.needVideo() && (p.Video == "" || !c.exists(p.Video)) {
+		logrus.Warn("[dry-run] 找不到视频文件:", p.Dir)
+		ok = false
+	}
+	if c.needAudio() && (p.Audio == "" || !c.exists(p.Audio)) {
+		logrus.Warn("[dry-run] 找不到音频文件:", p.Dir)
+		ok = false
+	}
+	if !c.AssOFF && !c.Mp3 && p.Ass == "" {
+		logrus.Warn("[dry-run] 缺少弹幕文件:", p.Dir)
+	}
+	if ok {
+		logrus.Infof("[dry-run] 视频: %s 音频: %s 输出: %s", p.Video, p.Audio, outputFile)
+	}
+	return ok
+}
+
+// mustString 读取json中的字符串字段，不存在或类型不是字符串时返回空字符串
+func mustString(js *simplejson.Json) string {
+	s, _ := js.String()
+	return s
+}
@@ -0,0 +1,61 @@
+package common
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bitly/go-simplejson"
+)
+
+func TestMustString(t *testing.T) {
+	js, err := simplejson.NewJson([]byte(`{"title":"蛇的工作原理","cid":111,"tags":["a"],"empty":"","null":null}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"title", "蛇的工作原理"},
+		{"empty", ""},
+		{"cid", ""},
+		{"tags", ""},
+		{"null", ""},
+		{"missing", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			if got := mustString(js.Get(tt.key)); got != tt.want {
+				t.Errorf("mustString(%s) = %q, want %q", tt.key, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrepareDirFiltered(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "videoInfo.json", `{"title":"蛇的工作原理","groupTitle":"3D动画之工作原理","uname":"珂姬与科技","status":"completed"}`)
+	writeFile(t, dir, "1-100-video.mp4", "video")
+	writeFile(t, dir, "1-30280-audio.mp3", "audio")
+	tests := []struct {
+		name    string
+		match   string
+		skipped string
+	}{
+		{"匹配", "工作原理", ""},
+		{"不匹配时跳过", "猫", SkipFiltered},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match, _ := compileMatch(tt.match)
+			c := &Config{Match: match, AssOFF: true, DryRun: true, Layout: LayoutGroupUname}
+			plan, r := prepareDir(context.Background(), c, 1, dir)
+			if r.skipped != tt.skipped {
+				t.Errorf("跳过的原因为%q，应为%q", r.skipped, tt.skipped)
+			}
+			if (plan == nil) != (tt.skipped != "") {
+				t.Errorf("prepareDir() plan = %v", plan)
+			}
+		})
+	}
+}
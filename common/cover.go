@@ -0,0 +1,62 @@
+package common
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/bitly/go-simplejson"
+	"github.com/sirupsen/logrus"
+)
+
+// coverName 下载的封面保存在缓存目录中的文件名，不含扩展名
+const coverName = "cover"
+
+// coverImage 返回缓存目录dir中视频的封面图片，优先使用videoInfo中的本地封面，
+// 否则下载封面地址，没有封面或下载失败时返回空
+func (c *Config) coverImage(ctx context.Context, dir string, js *simplejson.Json) string {
+	if p := mustString(js.Get("coverPath")); p != "" && Exist(p) {
+		return p
+	}
+	coverUrl := mustString(js.Get("coverUrl"))
+	if coverUrl == "" {
+		coverUrl = mustString(js.Get("cover"))
+	}
+	if coverUrl == "" {
+		logrus.Debug("videoInfo中没有封面地址:", dir)
+		return ""
+	}
+	if strings.HasPrefix(coverUrl, "//") {
+		coverUrl = "https:" + coverUrl
+	}
+	// 指定-tmp时封面也下载到临时目录，不写入缓存目录
+	file := filepath.Join(c.tempDir(dir), coverName+coverExt(coverUrl))
+	if Exist(file) {
+		return file
+	}
+	if err := os.MkdirAll(filepath.Dir(file), os.ModePerm); err != nil {
+		logrus.Warn("无法创建封面目录，不添加封面:", err)
+		return ""
+	}
+	if err := DownloadFile(ctx, c.Client, coverUrl, file, c.Retry); err != nil {
+		logrus.Warn("封面下载失败，不添加封面:", err)
+		return ""
+	}
+	return file
+}
+
+// coverExt 返回封面地址中图片的扩展名，无法识别时按jpg处理
+func coverExt(coverUrl string) string {
+	u, err := url.Parse(coverUrl)
+	if err != nil {
+		return ".jpg"
+	}
+	switch ext := strings.ToLower(path.Ext(u.Path)); ext {
+	case ".jpg", ".jpeg", ".png", ".webp":
+		return ext
+	}
+	return ".jpg"
+}
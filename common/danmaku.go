@@ -0,0 +1,103 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"m4s-converter/conver"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DanmakuXml = "xml" // comment.bilibili.com/<cid>.xml，只有部分弹幕
+	DanmakuSeg = "seg" // api.bilibili.com/x/v2/dm/web/seg.so，protobuf分段弹幕
+
+	DanmakuAss = "ass" // 转换为ass弹幕，保留滚动、顶部、底部等位置
+	DanmakuSrt = "srt" // 转换为srt字幕，同一秒内的弹幕合并为一条
+
+	// segDuration seg.so每段弹幕的时长
+	segDuration = 6 * time.Minute
+	// maxSegments 不知道视频时长时最多下载的分段数
+	maxSegments = 100
+
+	// DefaultDanmakuBase 默认的xml弹幕接口地址
+	DefaultDanmakuBase = "https://comment.bilibili.com"
+)
+
+// parseDanmakuBase 检查-dm-base是否为http或https地址，去掉末尾的斜杠
+func parseDanmakuBase(base string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(base))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", errors.New("弹幕接口地址无效：" + base + "，应为http或https地址")
+	}
+	return strings.TrimSuffix(u.String(), "/"), nil
+}
+
+func segUrl(cid string, index int) string {
+	return "https://api.bilibili.com/x/v2/dm/web/seg.so?type=1&oid=" + cid + "&segment_index=" + strconv.Itoa(index)
+}
+
+// localDanmaku 判断缓存目录中是否已有弹幕文件，离线观看时bilibili会缓存<cid>.xml
+func localDanmaku(xmlPath string) bool {
+	info, err := os.Stat(xmlPath)
+	return err == nil && !info.IsDir() && info.Size() > 0
+}
+
+// fetchDanmaku 下载xml弹幕，先使用-dm-api指定的接口，失败时换用另一个接口
+func (c *Config) fetchDanmaku(ctx context.Context, cid, dir string) ([]byte, error) {
+	apis := []string{DanmakuXml, DanmakuSeg}
+	if c.DanmakuAPI == DanmakuSeg {
+		apis = []string{DanmakuSeg, DanmakuXml}
+	}
+	var data []byte
+	var err error
+	for i, api := range apis {
+		if i > 0 {
+			logrus.Warnf("%s接口下载弹幕失败，改用%s接口: %v", apis[i-1], api, err)
+		}
+		switch api {
+		case DanmakuSeg:
+			data, err = c.fetchSeg(ctx, cid, dir)
+		default:
+			data, err = Fetch(ctx, c.Client, joinUrl(c.DanmakuBase, cid), c.Retry)
+		}
+		if err == nil || ctx.Err() != nil {
+			return data, err
+		}
+	}
+	return nil, err
+}
+
+// fetchSeg 按6分钟一段下载seg.so弹幕，合并后转换为xml
+func (c *Config) fetchSeg(ctx context.Context, cid, dir string) ([]byte, error) {
+	segments := maxSegments
+	if d := GetDuration(dir); d > 0 {
+		segments = int((d + segDuration - 1) / segDuration)
+	}
+	var list []conver.Danmaku
+	for i := 1; i <= segments; i++ {
+		data, err := Fetch(ctx, c.Client, segUrl(cid, i), c.Retry)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) == 0 && segments == maxSegments {
+			break // 不知道时长时，遇到空分段即结束
+		}
+		seg, err := conver.ParseSeg(data)
+		if err != nil {
+			return nil, fmt.Errorf("第%d段弹幕解析失败: %v", i, err)
+		}
+		list = append(list, seg...)
+	}
+	var buf bytes.Buffer
+	if err := conver.WriteXml(&buf, cid, list); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
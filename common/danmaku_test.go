@@ -0,0 +1,84 @@
+package common
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseDanmakuBase(t *testing.T) {
+	tests := []struct {
+		name    string
+		base    string
+		want    string
+		wantErr bool
+	}{
+		{"默认地址", DefaultDanmakuBase, DefaultDanmakuBase, false},
+		{"去掉结尾的斜杠", "https://dm.example.com/", "https://dm.example.com", false},
+		{"带路径和端口", " http://127.0.0.1:8080/dm/ ", "http://127.0.0.1:8080/dm", false},
+		{"缺少协议", "comment.bilibili.com", "", true},
+		{"不支持的协议", "ftp://comment.bilibili.com", "", true},
+		{"缺少主机", "https://", "", true},
+		{"无法解析", "http://[::1", "", true},
+		{"空地址", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDanmakuBase(tt.base)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseDanmakuBase(%q) error = %v, wantErr %v", tt.base, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseDanmakuBase(%q) = %q, want %q", tt.base, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFetchDanmakuFromBase(t *testing.T) {
+	const xml = `<?xml version="1.0" encoding="UTF-8"?><i><d p="1.0,1,25,16777215,0,0,0,0">弹幕</d></i>`
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		_, _ = w.Write([]byte(xml))
+	}))
+	defer srv.Close()
+	base, err := parseDanmakuBase(srv.URL + "/dm/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &Config{Client: srv.Client(), DanmakuBase: base}
+	data, err := c.fetchDanmaku(context.Background(), "1001", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != xml {
+		t.Errorf("fetchDanmaku() = %q, want %q", data, xml)
+	}
+	if len(paths) != 1 || paths[0] != "/dm/1001.xml" {
+		t.Errorf("请求的路径为%q，应为/dm/1001.xml", paths)
+	}
+}
+
+func TestInitConfigRequireDanmaku(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr bool
+	}{
+		{"-require-danmaku", []string{"-require-danmaku"}, false},
+		{"-require-danmaku与-a", []string{"-require-danmaku", "-a"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := initConfig(t, tt.args...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("InitConfig(%q) error = %v, wantErr %v", tt.args, err, tt.wantErr)
+			}
+			if err == nil && !c.RequireDanmaku {
+				t.Error("RequireDanmaku = false, want true")
+			}
+		})
+	}
+}
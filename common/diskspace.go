@@ -0,0 +1,98 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrDiskFull 输出目录所在磁盘空间不足，出现后停止合成剩余的目录
+var ErrDiskFull = errors.New("磁盘空间不足")
+
+// diskFullMessages ffmpeg输出中表示磁盘已满的信息
+var diskFullMessages = []string{
+	"No space left on device",
+	"There is not enough space on the disk",
+	"磁盘空间不足",
+}
+
+// isDiskFull 判断错误是否由磁盘空间不足引起，包括os.Mkdir、copyFile返回的错误和ffmpeg的错误输出
+func isDiskFull(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, ErrDiskFull) {
+		return true
+	}
+	for _, errno := range diskFullErrnos {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	msg := err.Error()
+	for _, m := range diskFullMessages {
+		if strings.Contains(msg, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// existingParent 返回path本身或最近的已存在的上级目录，用于查询还未创建的输出目录的剩余空间
+func existingParent(path string) string {
+	for {
+		if Exist(path) {
+			return path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
+
+// checkFreeSpace 估计合成dirs需要的空间，按输出根目录分别与磁盘剩余空间比较，可能不足时只警告
+// 合成只复制音视频流，输出文件的大小与m4s文件大致相同
+func (c *Config) checkFreeSpace(dirs []string, skip []bool) {
+	need := make(map[string]int64)
+	for i, v := range dirs {
+		if skip[i] {
+			continue
+		}
+		files, _ := listM4sFiles(v)
+		need[c.outputRoot(v)] += FilesSize(files)
+	}
+	for root, size := range need {
+		free, err := freeSpace(existingParent(root))
+		if err != nil {
+			logrus.Debug("无法获取剩余空间:", root, " ", err)
+			continue
+		}
+		logrus.Debugf("输出目录%s预计需要%s，剩余%s", root, FormatSize(size), FormatSize(free))
+		if size > free {
+			logrus.Warnf("输出目录%s的磁盘剩余%s，预计需要%s，空间可能不足", root, FormatSize(free), FormatSize(size))
+		}
+	}
+}
+
+// FormatSize 将字节数格式化为MB或GB
+func FormatSize(n int64) string {
+	if n >= 1<<30 {
+		return fmt.Sprintf("%.2fGB", float64(n)/(1<<30))
+	}
+	return fmt.Sprintf("%.1fMB", float64(n)/(1<<20))
+}
+
+// removeOnDiskFull 错误由磁盘空间不足引起时删除写了一半的输出文件，释放空间
+func removeOnDiskFull(err error, outputFile string) bool {
+	if !isDiskFull(err) {
+		return false
+	}
+	_ = os.Remove(outputFile)
+	return true
+}
@@ -0,0 +1,104 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/sirupsen/logrus"
+	"m4s-converter/conver"
+)
+
+// DanmakuSource 弹幕来源，Fetch返回cid对应的xml弹幕，由调用者关闭
+// 通过Config.DanmakuSources注册，下载弹幕时依次尝试，都失败时再从bilibili下载
+type DanmakuSource interface {
+	Fetch(cid string) (io.ReadCloser, error)
+}
+
+// DirDanmaku 从本地目录读取<cid>.xml弹幕，如第三方存档的弹幕，通过-dm-dir指定
+type DirDanmaku struct {
+	Dir string
+}
+
+func (d DirDanmaku) Fetch(cid string) (io.ReadCloser, error) {
+	path := filepath.Join(d.Dir, cid+conver.XmlSuffix)
+	if !localDanmaku(path) {
+		return nil, fmt.Errorf("%s中没有%s的弹幕", d.Dir, cid)
+	}
+	return os.Open(path)
+}
+
+func (d DirDanmaku) String() string {
+	return "目录" + d.Dir
+}
+
+// httpDanmaku 从bilibili下载弹幕，按-dm-api选择接口，seg接口需要缓存目录中的时长
+type httpDanmaku struct {
+	c   *Config
+	ctx context.Context
+	dir string
+}
+
+func (h httpDanmaku) Fetch(cid string) (io.ReadCloser, error) {
+	data, err := h.c.fetchDanmaku(h.ctx, cid, h.dir)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (h httpDanmaku) String() string {
+	return "bilibili"
+}
+
+// danmakuSources 返回下载弹幕时依次尝试的来源，注册的来源在前，bilibili在最后
+func (c *Config) danmakuSources(ctx context.Context, dir string) []DanmakuSource {
+	sources := make([]DanmakuSource, 0, len(c.DanmakuSources)+1)
+	sources = append(sources, c.DanmakuSources...)
+	return append(sources, httpDanmaku{c: c, ctx: ctx, dir: dir})
+}
+
+// downloadDanmaku 依次从各个来源获取弹幕并保存为xml，一个来源成功即返回，都失败时返回所有错误
+func (c *Config) downloadDanmaku(ctx context.Context, cid, dir, xmlPath string) error {
+	var errs []error
+	for _, src := range c.danmakuSources(ctx, dir) {
+		err := saveDanmaku(src, cid, xmlPath)
+		if err == nil {
+			logrus.Debugf("从%v获取到弹幕: %s", src, xmlPath)
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("%v: %w", src, err))
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// saveDanmaku 将来源返回的弹幕写入xmlPath，失败时删除写了一半的文件
+func saveDanmaku(src DanmakuSource, cid, xmlPath string) error {
+	rc, err := src.Fetch(cid)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	f, err := os.Create(xmlPath)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(f, rc)
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err == nil && n == 0 {
+		err = errors.New("弹幕为空")
+	}
+	if err != nil {
+		_ = os.Remove(xmlPath)
+	}
+	return err
+}
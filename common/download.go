@@ -2,54 +2,114 @@ package common
 
 import (
 	"compress/flate"
+	"context"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
+	"time"
 )
 
-func DownloadFile(url string, filepath string) error {
-	// 发起HTTP GET请求
-	httpReq, err := http.Get(url)
-	if err != nil {
-		return err
+// StatusError 服务器返回的非200状态码
+type StatusError struct {
+	Code int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("服务器返回状态码: %d", e.Code)
+}
+
+// retryable 判断下载错误是否可以重试，网络错误和412、429、5xx可以重试，其它4xx为永久错误
+func retryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
 	}
-	defer httpReq.Body.Close()
+	var se *StatusError
+	if errors.As(err, &se) {
+		return se.Code == http.StatusPreconditionFailed ||
+			se.Code == http.StatusTooManyRequests ||
+			se.Code >= http.StatusInternalServerError
+	}
+	return true
+}
+
+// NewHTTPClient 创建下载使用的http客户端，proxy为空时使用HTTP_PROXY/HTTPS_PROXY环境变量
+// proxy支持http://、https://和socks5://
+func NewHTTPClient(proxy string, timeout time.Duration) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	if proxy != "" {
+		u, err := url.Parse(proxy)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("代理地址格式错误: %s", proxy)
+		}
+		transport.Proxy = http.ProxyURL(u)
+	}
+	return &http.Client{Transport: transport, Timeout: timeout}, nil
+}
 
-	// 创建本地文件
-	localFile, err := os.Create(filepath)
+// DownloadFile 下载文件，失败时按指数退避重试，最多尝试attempts次，ctx超时或取消时停止重试
+// client为nil时使用http.DefaultClient
+func DownloadFile(ctx context.Context, client *http.Client, url string, filepath string, attempts int) error {
+	data, err := Fetch(ctx, client, url, attempts)
 	if err != nil {
 		return err
 	}
-	defer localFile.Close()
-
-	// 检查Content-Encoding是否为deflate
-	contentEncoding := httpReq.Header.Get("Content-Encoding")
-	if contentEncoding == "deflate" {
-		// 如果是deflate编码，解压缩数据
-		reader := flate.NewReader(httpReq.Body)
-		defer reader.Close()
-
-		// 读取并解压数据
-		bodyBytes, err := io.ReadAll(reader)
-		if err != nil {
-			return err
-		}
+	return os.WriteFile(filepath, data, 0644)
+}
 
-		// 将解压后的数据写入本地文件
-		if _, err := localFile.Write(bodyBytes); err != nil {
-			return err
+// Fetch 下载数据，失败时按指数退避重试，最多尝试attempts次，ctx超时或取消时停止重试
+// client为nil时使用http.DefaultClient
+func Fetch(ctx context.Context, client *http.Client, url string, attempts int) ([]byte, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if attempts < 1 {
+		attempts = 1
+	}
+	backoff := time.Second
+	var data []byte
+	var err error
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, fmt.Errorf("%v: %w", err, ctx.Err())
+			case <-time.After(backoff):
+			}
+			backoff *= 2
 		}
-	} else {
-		// 如果不是deflate编码，直接将Content-Encoding写入文件
-		if _, err := localFile.Write([]byte(contentEncoding)); err != nil {
-			return err
+		if data, err = fetch(ctx, client, url); err == nil || !retryable(err) {
+			return data, err
 		}
 	}
+	return nil, err
+}
 
-	// 检查文件是否成功写入
-	if err := localFile.Sync(); err != nil {
-		return err
+func fetch(ctx context.Context, client *http.Client, url string) ([]byte, error) {
+	// 发起HTTP GET请求
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer httpReq.Body.Close()
+	if httpReq.StatusCode != http.StatusOK {
+		return nil, &StatusError{Code: httpReq.StatusCode}
 	}
 
-	return nil
+	// 检查Content-Encoding是否为deflate
+	var reader io.Reader = httpReq.Body
+	if httpReq.Header.Get("Content-Encoding") == "deflate" {
+		// 如果是deflate编码，解压缩数据
+		fr := flate.NewReader(httpReq.Body)
+		defer fr.Close()
+		reader = fr
+	}
+	return io.ReadAll(reader)
 }
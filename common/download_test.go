@@ -0,0 +1,132 @@
+package common
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNewHTTPClient(t *testing.T) {
+	tests := []struct {
+		name    string
+		proxy   string
+		want    string // 请求使用的代理，为空时不使用代理
+		wantErr bool
+	}{
+		{"不指定代理", "", "", false},
+		{"http代理", "http://127.0.0.1:8080", "http://127.0.0.1:8080", false},
+		{"socks5代理", "socks5://127.0.0.1:1080", "socks5://127.0.0.1:1080", false},
+		{"缺少协议", "127.0.0.1:8080", "", true},
+		{"缺少主机", "http://", "", true},
+	}
+	t.Setenv("HTTP_PROXY", "")
+	t.Setenv("HTTPS_PROXY", "")
+	req, _ := http.NewRequest(http.MethodGet, "https://comment.bilibili.com/1.xml", nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := NewHTTPClient(tt.proxy, 5*time.Second)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewHTTPClient() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if client.Timeout != 5*time.Second {
+				t.Errorf("Timeout = %v", client.Timeout)
+			}
+			u, err := client.Transport.(*http.Transport).Proxy(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := ""
+			if u != nil {
+				got = u.String()
+			}
+			if got != tt.want {
+				t.Errorf("代理为%q，应为%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFetchThroughProxy(t *testing.T) {
+	// http代理收到的请求为完整的目标地址
+	var host atomic.Value
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host.Store(r.URL.Host)
+		_, _ = w.Write([]byte("<i></i>"))
+	}))
+	defer proxy.Close()
+	client, err := NewHTTPClient(proxy.URL, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := Fetch(context.Background(), client, "http://comment.bilibili.com/1.xml", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "<i></i>" {
+		t.Errorf("Fetch() = %q", data)
+	}
+	if got, _ := host.Load().(string); got != "comment.bilibili.com" {
+		t.Errorf("代理收到的请求主机为%q", got)
+	}
+}
+
+func TestFetchRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		codes    []int // 依次返回的状态码，用完后返回200
+		attempts int
+		wantErr  bool
+		requests int32
+	}{
+		{"成功", nil, 3, false, 1},
+		{"5xx后重试成功", []int{http.StatusBadGateway}, 3, false, 2},
+		{"404不重试", []int{http.StatusNotFound}, 3, true, 1},
+		{"超过重试次数", []int{http.StatusTooManyRequests, http.StatusTooManyRequests}, 2, true, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var n int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				i := atomic.AddInt32(&n, 1) - 1
+				if int(i) < len(tt.codes) {
+					w.WriteHeader(tt.codes[i])
+					return
+				}
+				_, _ = w.Write([]byte("ok"))
+			}))
+			defer srv.Close()
+			_, err := Fetch(context.Background(), srv.Client(), srv.URL, tt.attempts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Fetch() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := atomic.LoadInt32(&n); got != tt.requests {
+				t.Errorf("请求了%d次，应为%d次", got, tt.requests)
+			}
+		})
+	}
+}
+
+func TestFetchTimeout(t *testing.T) {
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(done)
+	client, err := NewHTTPClient("", 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Fetch(context.Background(), client, srv.URL, 1); err == nil {
+		t.Error("连接挂起时Fetch()应超时返回错误")
+	}
+}
@@ -0,0 +1,79 @@
+package common
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"m4s-converter/conver"
+)
+
+// plannedFiles -dry-run时将要生成但未写入的音视频文件
+type plannedFiles struct {
+	mu    sync.Mutex
+	files map[string]bool
+}
+
+// plan 记录dry-run时将要生成的文件，不写入磁盘
+func (c *Config) plan(path string) {
+	if c.planned == nil {
+		return
+	}
+	c.planned.mu.Lock()
+	defer c.planned.mu.Unlock()
+	c.planned.files[path] = true
+	logrus.Debug("[dry-run] 将生成:", path)
+}
+
+// exists 判断文件已存在，或dry-run时将会生成
+func (c *Config) exists(path string) bool {
+	if Exist(path) {
+		return true
+	}
+	if c.planned == nil {
+		return false
+	}
+	c.planned.mu.Lock()
+	defer c.planned.mu.Unlock()
+	return c.planned.files[path]
+}
+
+// findPlanned 查找dry-run时缓存目录dir将要生成的音视频文件，与findTempFiles相同
+func (c *Config) findPlanned(dir string, page func(string) *Page) {
+	if c.planned == nil {
+		return
+	}
+	tmp := c.tempDir(dir)
+	c.planned.mu.Lock()
+	defer c.planned.mu.Unlock()
+	for path := range c.planned.files {
+		if filepath.Dir(path) != tmp {
+			continue
+		}
+		if strings.HasSuffix(path, conver.VideoSuffix) {
+			page(dir).Video = path
+		}
+		if strings.HasSuffix(path, conver.AudioSuffix) {
+			page(dir).Audio = path
+		}
+	}
+}
+
+// plannedDanmaku 返回dry-run时缓存目录dir将要使用的弹幕文件，不下载、不复制也不转换
+func (c *Config) plannedDanmaku(dir, cid string) string {
+	xmlPath := filepath.Join(dir, cid+conver.XmlSuffix)
+	if !c.RefreshDm && localDanmaku(xmlPath) {
+		logrus.Info("[dry-run] 将使用本地弹幕:", xmlPath)
+	} else {
+		logrus.Info("[dry-run] 将下载弹幕:", xmlPath)
+	}
+	suffix := conver.AssSuffix
+	if c.DanmakuFormat == DanmakuSrt {
+		suffix = conver.SrtSuffix
+	}
+	if c.ReadonlyCache {
+		dir = c.tempDir(dir) // 只读缓存时弹幕写入-tmp目录
+	}
+	return filepath.Join(dir, cid+suffix)
+}
@@ -0,0 +1,181 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"m4s-converter/conver"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// runFFmpeg 执行ffmpeg命令并等待完成，total为媒体总时长，用于显示进度
+// ctx取消或超过-timeout时结束ffmpeg进程，并删除未完成的输出文件
+func (c *Config) runFFmpeg(ctx context.Context, args []string, outputFile string, total time.Duration) error {
+	err := c.execFFmpeg(ctx, args, outputFile, total)
+	if err != nil {
+		c.emit(ProgressEvent{File: outputFile, Phase: PhaseCompose, Err: err})
+	} else {
+		c.emit(ProgressEvent{File: outputFile, Phase: PhaseCompose, Percent: 100})
+	}
+	return err
+}
+
+// execFFmpeg 执行ffmpeg并转换结果为错误
+func (c *Config) execFFmpeg(ctx context.Context, args []string, outputFile string, total time.Duration) error {
+	//logrus.Info(c.FFMpegPath, args)
+	runCtx, cancel := c.ffmpegContext(ctx)
+	defer cancel()
+	existed := Exist(outputFile)
+
+	// 启动命令，获取输出和错误流
+	stdout, stderr, wait := c.runner().Run(runCtx, args)
+
+	// 读取并打印输出流
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		printOutput(stdout, outputFile)
+	}()
+
+	// 读取并打印错误流，设置了ProgressFunc时改为通知进度
+	var tail []string
+	var exists bool
+	var onTime func(time.Duration)
+	if c.ProgressFunc != nil {
+		c.emit(ProgressEvent{File: outputFile, Phase: PhaseCompose})
+		onTime = func(current time.Duration) {
+			if total > 0 {
+				c.emit(ProgressEvent{File: outputFile, Phase: PhaseCompose, Percent: progressPercent(current, total)})
+			}
+		}
+	}
+	go func() {
+		defer wg.Done()
+		tail, exists = printError(stderr, outputFile, total, onTime)
+	}()
+
+	// 进程被结束后关闭管道，ffmpeg的子进程仍占用管道时读取输出的goroutine也能退出
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-runCtx.Done():
+			closeReader(stdout)
+			closeReader(stderr)
+		case <-done:
+		}
+	}()
+
+	// 输出流读取完后才能调用Wait
+	wg.Wait()
+	close(done)
+	err := wait()
+	fmt.Println()
+	if runCtx.Err() != nil {
+		// 不删除未被覆盖的已存在文件
+		if !existed || c.Overlay == "-y" {
+			_ = os.Remove(outputFile)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("ffmpeg执行超过%v，已结束进程", c.Timeout)
+	}
+	if err != nil && exists && c.Overlay == "-n" {
+		return nil // 不覆盖已存在的文件，不算失败
+	}
+	if err != nil {
+		return fmt.Errorf("ffmpeg执行失败: %v\n%s", err, strings.Join(tail, "\n"))
+	}
+	return nil
+}
+
+// closeReader 关闭可以关闭的输出流
+func closeReader(r io.Reader) {
+	if closer, ok := r.(io.Closer); ok {
+		_ = closer.Close()
+	}
+}
+
+// ffmpegContext 返回单次执行ffmpeg的ctx，-timeout为0时不限制时间
+func (c *Config) ffmpegContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.Timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, c.Timeout)
+}
+
+// ExtractAudio 将音频文件转码为-audio-format指定的格式
+func (c *Config) ExtractAudio(ctx context.Context, audioFile, outputFile string) error {
+	if audioFile == "" || !Exist(audioFile) {
+		return fmt.Errorf("找不到音频文件: %s", audioFile)
+	}
+	af, err := c.loudnormFilter(ctx, audioFile)
+	if err != nil {
+		return err
+	}
+	args := []string{
+		"-i", audioFile,
+		"-vn", // 不处理视频
+	}
+	if af != "" {
+		// 统一音量，loudnorm会把采样率提高到192kHz，需要重新指定
+		args = append(args, "-af", af, "-ar", "48000")
+	}
+	args = append(args, c.audioCodecArgs(ctx, audioFile, af != "")...)
+	args = append(args,
+		c.Overlay, // 是否覆盖已存在文件
+		outputFile,
+		"-hide_banner", // 隐藏版本信息和版权声明
+		"-stats",       // 只显示统计信息
+	)
+	var total time.Duration
+	if c.Progress || c.ProgressFunc != nil {
+		total = GetDuration(c.cacheDir(filepath.Dir(audioFile)))
+	}
+	if err = c.runFFmpeg(ctx, args, outputFile, total); err != nil {
+		return err
+	}
+	logrus.Info("已提取音频文件:", filepath.Base(outputFile))
+	return nil
+}
+
+// audioCodecArgs 返回-audio-format对应的编码参数，filtered为是否使用了音频滤镜，使用滤镜时不能直接复制
+func (c *Config) audioCodecArgs(ctx context.Context, audioFile string, filtered bool) []string {
+	switch c.AudioFormat {
+	case AudioWav:
+		return []string{"-c:a", "pcm_s16le"}
+	case AudioFlac:
+		return []string{"-c:a", "flac"}
+	case AudioAac:
+		// 杜比和Hi-Res音轨为eac3和flac，不能直接放进m4a，没有ffprobe时按aac处理
+		if info, err := c.Probe(ctx, audioFile); !filtered && (err != nil || info.Audio == "aac") {
+			return []string{"-c:a", "copy"}
+		}
+		return []string{"-c:a", "aac", "-b:a", "320k"}
+	}
+	return []string{
+		"-c:a", "libmp3lame", // 编码为mp3
+		"-q:a", "2", // VBR质量，约190kbps
+	}
+}
+
+// subtitleFilter 返回压制弹幕的滤镜，ass弹幕使用ass滤镜保留样式，srt字幕使用subtitles滤镜
+func subtitleFilter(file string) string {
+	if strings.EqualFold(filepath.Ext(file), conver.SrtSuffix) {
+		return "subtitles=" + escapeFilterPath(file)
+	}
+	return "ass=" + escapeFilterPath(file)
+}
+
+// escapeFilterPath 转义滤镜参数中的文件路径，windows路径中的盘符冒号需要转义
+func escapeFilterPath(path string) string {
+	path = filepath.ToSlash(path)
+	path = strings.ReplaceAll(path, `:`, `\:`)
+	return "'" + path + "'"
+}
@@ -0,0 +1,107 @@
+package common
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// fakeFFmpeg 在dir中生成输出script内容的ffmpeg脚本，返回脚本路径
+func fakeFFmpeg(t *testing.T, dir, script string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows上不能直接执行sh脚本")
+	}
+	path := filepath.Join(dir, "ffmpeg")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCompositionExitError(t *testing.T) {
+	tests := []struct {
+		name    string
+		script  string
+		overlay string
+		wantErr string // 错误中应包含的内容，为空时不应出错
+	}{
+		{"成功", "exit 0", "-n", ""},
+		{"非0退出并返回错误输出", "echo 'Invalid data found when processing input' >&2\nexit 1", "-n", "Invalid data found"},
+		{"只保留最后的错误输出", "i=0\nwhile [ $i -lt 50 ]; do echo \"line $i\" >&2; i=$((i+1)); done\nexit 2", "-n", "line 49"},
+		{"不覆盖已存在的文件不算失败", "echo \"File 'out.mp4' already exists. Exiting.\" >&2\nexit 1", "-n", ""},
+		{"覆盖时已存在仍为失败", "echo \"File 'out.mp4' already exists. Exiting.\" >&2\nexit 1", "-y", "already exists"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			c := &Config{FFMpegPath: fakeFFmpeg(t, dir, tt.script), Overlay: tt.overlay, AssOFF: true}
+			err := c.Composition(context.Background(), filepath.Join(dir, "v.mp4"), filepath.Join(dir, "a.mp3"),
+				"", "", filepath.Join(dir, "out.mp4"), nil)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Composition() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Composition() error = %v, 应包含%q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCompositionMissingFFmpeg(t *testing.T) {
+	dir := t.TempDir()
+	c := &Config{FFMpegPath: filepath.Join(dir, "no-ffmpeg"), Overlay: "-n"}
+	if err := c.Composition(context.Background(), "v.mp4", "a.mp3", "", "", filepath.Join(dir, "out.mp4"), nil); err == nil {
+		t.Error("ffmpeg不存在时Composition()应返回错误")
+	}
+}
+
+func TestGetFFmpegPathFromEnv(t *testing.T) {
+	envDir, pathDir := t.TempDir(), t.TempDir()
+	envFFmpeg := fakeFFmpeg(t, envDir, "exit 0")
+	pathFFmpeg := fakeFFmpeg(t, pathDir, "exit 0")
+	notExec := writeFile(t, t.TempDir(), "ffmpeg", "#!/bin/sh\n")
+	missing := filepath.Join(t.TempDir(), "ffmpeg")
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    string
+		wantErr string // 错误中应包含的内容
+	}{
+		{"没有环境变量时使用PATH", nil, pathFFmpeg, ""},
+		{"M4S_FFMPEG优先于PATH", map[string]string{"M4S_FFMPEG": envFFmpeg}, envFFmpeg, ""},
+		{"FFMPEG", map[string]string{"FFMPEG": envFFmpeg}, envFFmpeg, ""},
+		{"M4S_FFMPEG优先于FFMPEG", map[string]string{"M4S_FFMPEG": envFFmpeg, "FFMPEG": missing}, envFFmpeg, ""},
+		{"空白的环境变量被忽略", map[string]string{"M4S_FFMPEG": "  "}, pathFFmpeg, ""},
+		{"文件不存在", map[string]string{"M4S_FFMPEG": missing}, "", "环境变量M4S_FFMPEG"},
+		{"文件不可执行", map[string]string{"FFMPEG": notExec}, "", "环境变量FFMPEG"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("PATH", pathDir)
+			for _, name := range FFmpegEnvs {
+				t.Setenv(name, tt.env[name])
+			}
+			c := &Config{}
+			err := c.GetFFmpegPath()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("GetFFmpegPath() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.FFMpegPath != tt.want {
+				t.Errorf("FFMpegPath = %q, want %q", c.FFMpegPath, tt.want)
+			}
+		})
+	}
+}
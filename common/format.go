@@ -0,0 +1,118 @@
+package common
+
+import (
+	"errors"
+	"github.com/sirupsen/logrus"
+	"m4s-converter/conver"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// 支持的输出视频格式
+const (
+	FormatMp4 = "mp4"
+	FormatMkv = "mkv"
+	FormatMov = "mov"
+)
+
+var formatSuffix = map[string]string{
+	FormatMp4: conver.Mp4Suffix,
+	FormatMkv: conver.MkvSuffix,
+	FormatMov: conver.MovSuffix,
+}
+
+// 提取音频支持的格式
+const (
+	AudioMp3  = "mp3"
+	AudioWav  = "wav"
+	AudioFlac = "flac"
+	AudioAac  = "aac" // 封装为m4a，源文件为aac时直接复制
+)
+
+var audioSuffix = map[string]string{
+	AudioMp3:  conver.Mp3Suffix,
+	AudioWav:  conver.WavSuffix,
+	AudioFlac: conver.FlacSuffix,
+	AudioAac:  conver.M4aSuffix,
+}
+
+// OutputSuffix 返回合成文件的扩展名，只提取音频时为-audio-format对应的扩展名，
+// -video-only和-audio-only为.video.mp4和.audio.m4a，不与合成的文件同名
+func (c *Config) OutputSuffix() string {
+	if c.VideoOnly {
+		return ".video" + conver.Mp4Suffix
+	}
+	if c.AudioOnly {
+		return ".audio" + conver.M4aSuffix
+	}
+	if c.Mp3 {
+		if suffix, ok := audioSuffix[c.AudioFormat]; ok {
+			return suffix
+		}
+		return conver.Mp3Suffix
+	}
+	if suffix, ok := formatSuffix[c.Format]; ok {
+		return suffix
+	}
+	return conver.Mp4Suffix
+}
+
+// needVideo 是否需要视频文件，只提取音频时不需要
+func (c *Config) needVideo() bool {
+	return !c.Mp3 && !c.AudioOnly
+}
+
+// needAudio 是否需要音频文件，-video-only时不需要
+func (c *Config) needAudio() bool {
+	return !c.VideoOnly
+}
+
+// exportTrack 将去掉文件头的音频或视频文件src复制为dst，-video-only和-audio-only时使用，不执行ffmpeg，
+// 缓存目录中的src保留，下次运行时不用重新转换
+func exportTrack(src, dst string) error {
+	if src == "" || !Exist(src) {
+		return errors.New("找不到去掉文件头的音视频文件")
+	}
+	if err := copyFile(src, dst, func(*os.File) {}); err != nil {
+		_ = os.Remove(dst)
+		return err
+	}
+	logrus.Info("已导出轨道文件:", filepath.Base(dst))
+	return nil
+}
+
+// VerifyOutput 检查合成的文件是否完整，mkv不是ISO BMFF格式，不做检查
+func (c *Config) VerifyOutput(path string) error {
+	if c.Format == FormatMkv {
+		return nil
+	}
+	return VerifyMP4(path)
+}
+
+// durationTolerance 已有文件的时长与缓存时长允许的误差
+const durationTolerance = 2 * time.Second
+
+// AlreadyComposed 不覆盖已有文件时，判断outputFile是否已经完整合成过，dir为缓存的分P目录
+// mp4和mov检查音视频轨道和时长，时长明显短于缓存时视为未合成完，mkv和mp3只检查文件大小
+func (c *Config) AlreadyComposed(outputFile, dir string) bool {
+	if c.Overlay != "-n" || c.OverwriteIfBetter {
+		return false
+	}
+	st, err := os.Stat(outputFile)
+	if err != nil || st.IsDir() || st.Size() == 0 {
+		return false
+	}
+	if c.Mp3 || c.VideoOnly || c.AudioOnly || c.Format == FormatMkv {
+		return true
+	}
+	if VerifyMP4(outputFile) != nil {
+		return false
+	}
+	info, err := ReadMP4Info(outputFile)
+	if err != nil {
+		return false
+	}
+	expected := GetDuration(dir)
+	return expected <= 0 || info.Duration >= expected-durationTolerance
+}
@@ -0,0 +1,60 @@
+package common
+
+import "testing"
+
+func TestInitConfigTrackOnly(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr bool
+		check   func(c *Config) bool
+	}{
+		{"-video-only不下载弹幕", []string{"-video-only"}, false, func(c *Config) bool { return c.VideoOnly && c.AssOFF }},
+		{"-audio-only", []string{"-audio-only"}, false, func(c *Config) bool { return c.AudioOnly && !c.VideoOnly }},
+		{"-video-only与-audio-only", []string{"-video-only", "-audio-only"}, true, nil},
+		{"-video-only与-mp3", []string{"-video-only", "-mp3"}, true, nil},
+		{"-audio-only与-burn", []string{"-audio-only", "-burn"}, true, nil},
+		{"-video-only与-embed-sub", []string{"-video-only", "-embed-sub"}, true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := initConfig(t, tt.args...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("InitConfig(%q) error = %v, wantErr %v", tt.args, err, tt.wantErr)
+			}
+			if err == nil && !tt.check(c) {
+				t.Errorf("InitConfig(%q) = %+v", tt.args, c)
+			}
+		})
+	}
+}
+
+func TestInitConfigAudioFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		format  string
+		mp3     bool
+		wantErr bool
+	}{
+		{"默认不提取音频", nil, AudioMp3, false, false},
+		{"-mp3", []string{"-mp3"}, AudioMp3, true, false},
+		{"mp3以外的格式自动只提取音频", []string{"-audio-format", "flac"}, AudioFlac, true, false},
+		{"不区分大小写", []string{"-audio-format", "WAV"}, AudioWav, true, false},
+		{"不支持的音频格式", []string{"-audio-format", "ogg"}, "", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := initConfig(t, tt.args...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("InitConfig(%q) error = %v, wantErr %v", tt.args, err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if c.AudioFormat != tt.format || c.Mp3 != tt.mp3 {
+				t.Errorf("AudioFormat = %q, Mp3 = %v, want %q, %v", c.AudioFormat, c.Mp3, tt.format, tt.mp3)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package common
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// HashFile 计算文件的SHA-256哈希值，返回小写十六进制字符串
+func HashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// verifyFFmpeg 指定-ffmpeg-sha256时校验ffmpeg文件，不一致时拒绝运行
+func (c *Config) verifyFFmpeg() error {
+	if c.FFmpegSHA256 == "" {
+		return nil
+	}
+	sum, err := HashFile(c.FFMpegPath)
+	if err != nil {
+		return fmt.Errorf("无法校验ffmpeg文件：%w", err)
+	}
+	if !strings.EqualFold(sum, c.FFmpegSHA256) {
+		return fmt.Errorf("ffmpeg文件 %s 校验失败，文件可能已损坏\n期望的SHA-256：%s\n实际的SHA-256：%s",
+			c.FFMpegPath, strings.ToLower(c.FFmpegSHA256), sum)
+	}
+	logrus.Debug("ffmpeg文件校验通过:", c.FFMpegPath)
+	return nil
+}
+
+// writeVerified 将data写入dst，先写入同目录下的临时文件，SHA-256与sum一致后再替换dst，
+// 写入中断或写入的内容不完整时删除临时文件并返回错误，不会留下不完整的dst
+func writeVerified(dst string, data []byte, sum string) (err error) {
+	dir, name := filepath.Split(dst)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := os.CreateTemp(dir, name+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+	_, err = tmp.Write(data)
+	if e := tmp.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		return err
+	}
+	got, err := HashFile(tmp.Name())
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(got, sum) {
+		return fmt.Errorf("写入的文件不完整，SHA-256为%s，应为%s", got, sum)
+	}
+	return os.Rename(tmp.Name(), dst)
+}
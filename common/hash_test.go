@@ -0,0 +1,61 @@
+package common
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteVerified(t *testing.T) {
+	data := []byte("embedded ffmpeg binary")
+	sum := fmt.Sprintf("%x", sha256.Sum256(data))
+	tests := []struct {
+		name    string
+		old     string // 已有的dst内容，为空时不创建
+		write   []byte // 实际写入的内容，短于data时模拟写入中断
+		wantErr bool
+	}{
+		{"第一次释放", "", data, false},
+		{"替换不完整的文件", "embedded", data, false},
+		{"写入中断", "", data[:8], true},
+		{"写入中断时保留原有文件", "old ffmpeg", data[:8], true},
+		{"写入为空", "", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			dst := filepath.Join(dir, "ffmpeg.exe")
+			if tt.old != "" {
+				writeFile(t, dir, "ffmpeg.exe", tt.old)
+			}
+			err := writeVerified(dst, tt.write, sum)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("writeVerified() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			want := string(data)
+			if err != nil {
+				want = tt.old
+			}
+			got, readErr := os.ReadFile(dst)
+			if want == "" {
+				if readErr == nil {
+					t.Errorf("写入失败时不应生成%s", dst)
+				}
+			} else if string(got) != want {
+				t.Errorf("%s的内容为%q，应为%q", dst, got, want)
+			}
+			// 临时文件在成功或失败时都不应留下
+			entries, _ := os.ReadDir(dir)
+			for _, e := range entries {
+				if e.Name() != "ffmpeg.exe" {
+					t.Errorf("留下了临时文件%s", e.Name())
+				}
+			}
+		})
+	}
+	if err := writeVerified(filepath.Join(t.TempDir(), "none", "ffmpeg.exe"), data, sum); err == nil {
+		t.Error("目录不存在时应返回错误")
+	}
+}
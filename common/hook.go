@@ -0,0 +1,60 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// hookOutputLimit 执行失败时日志中保留的输出长度
+const hookOutputLimit = 2000
+
+// hookCommand 替换-exec命令中的占位符，{file}为合成的文件，{title}为视频名称，{uname}为上传的用户名
+// 占位符按原样替换，不会加引号或转义，文件名中常有空格和引号，需要在命令中自行加引号，
+// 名称中含有引号或$等shell特殊字符时，建议改用环境变量M4S_FILE、M4S_TITLE、M4S_UNAME
+func hookCommand(command, file, title, uname string) string {
+	return strings.NewReplacer("{file}", file, "{title}", title, "{uname}", uname).Replace(command)
+}
+
+// runHook 合成成功后通过shell执行-exec指定的命令，超过-exec-timeout时结束命令
+// 命令失败时只返回错误，不影响其它文件的合成
+func (c *Config) runHook(ctx context.Context, file, title, uname string) error {
+	if c.Exec == "" {
+		return nil
+	}
+	if c.ExecTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.ExecTimeout)
+		defer cancel()
+	}
+	command := hookCommand(c.Exec, file, title, uname)
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	cmd.Env = append(os.Environ(), "M4S_FILE="+file, "M4S_TITLE="+title, "M4S_UNAME="+uname)
+	cmd.WaitDelay = time.Second // 命令启动的子进程仍占用输出时，结束命令后不再等待
+	logrus.Debug("执行-exec命令:", command)
+	out, err := cmd.CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("-exec命令执行超过%v，已结束: %s", c.ExecTimeout, command)
+	}
+	if err != nil {
+		if len(out) > hookOutputLimit {
+			out = out[len(out)-hookOutputLimit:]
+		}
+		return fmt.Errorf("-exec命令执行失败(%v): %s\n%s", err, command, strings.TrimSpace(string(out)))
+	}
+	if len(out) > 0 {
+		logrus.Info("-exec命令输出:\n", strings.TrimSpace(string(out)))
+	}
+	return nil
+}
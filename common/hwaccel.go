@@ -0,0 +1,89 @@
+package common
+
+import (
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 支持的硬件加速方式，只用于-burn等需要重新编码的模式
+const (
+	HWAccelNone         = "none"
+	HWAccelNvenc        = "nvenc"
+	HWAccelQsv          = "qsv"
+	HWAccelVideoToolbox = "videotoolbox"
+	HWAccelVaapi        = "vaapi"
+)
+
+// hwEncoder 硬件加速方式对应的ffmpeg编码器
+var hwEncoder = map[string]string{
+	HWAccelNone:         "libx264",
+	HWAccelNvenc:        "h264_nvenc",
+	HWAccelQsv:          "h264_qsv",
+	HWAccelVideoToolbox: "h264_videotoolbox",
+	HWAccelVaapi:        "h264_vaapi",
+}
+
+// vaapiDevice vaapi默认使用的渲染设备
+const vaapiDevice = "/dev/dri/renderD128"
+
+// probeHWAccel 按CheckFFmpeg读取的编码器检查硬件编码器是否可用，不可用或无法检查(r为nil)时回退到软件编码
+func (c *Config) probeHWAccel(r *CheckResult) {
+	if c.HWAccel == HWAccelNone || !c.Burn {
+		return // 直接复制视频流时不需要检查
+	}
+	enc := hwEncoder[c.HWAccel]
+	if r == nil {
+		logrus.Warn("无法检查ffmpeg编码器，使用软件编码")
+		c.HWAccel = HWAccelNone
+		return
+	}
+	for _, it := range r.Items {
+		if it.Name == enc && it.OK {
+			return
+		}
+	}
+	logrus.Warnf("ffmpeg不支持%s编码器，使用软件编码", enc)
+	c.HWAccel = HWAccelNone
+}
+
+// hwInputArgs 放在-i之前的硬件解码参数，解码后的帧在内存中，ass滤镜可以直接处理
+func (c *Config) hwInputArgs() []string {
+	switch c.HWAccel {
+	case HWAccelNvenc:
+		return []string{"-hwaccel", "cuda"}
+	case HWAccelQsv:
+		return []string{"-hwaccel", "qsv"}
+	case HWAccelVideoToolbox:
+		return []string{"-hwaccel", "videotoolbox"}
+	case HWAccelVaapi:
+		return []string{"-hwaccel", "vaapi", "-vaapi_device", vaapiDevice}
+	}
+	return nil
+}
+
+// encodeArgs 重新编码视频的参数，vf为视频滤镜，-crf按各编码器的质量参数换算
+func (c *Config) encodeArgs(vf string) []string {
+	crf := strconv.Itoa(c.CRF)
+	// 只重新编码第一个视频流，封面等其它视频流直接复制
+	args := []string{"-c:v:0", hwEncoder[c.HWAccel]}
+	switch c.HWAccel {
+	case HWAccelNvenc:
+		args = append(args, "-cq", crf)
+	case HWAccelQsv:
+		args = append(args, "-global_quality", crf)
+	case HWAccelVideoToolbox:
+		// videotoolbox的质量取值1-100，越大质量越高
+		q := 100 - 2*c.CRF
+		if q < 1 {
+			q = 1
+		}
+		args = append(args, "-q:v", strconv.Itoa(q))
+	case HWAccelVaapi:
+		vf += ",format=nv12,hwupload" // vaapi编码器需要上传到显存的帧
+		args = append(args, "-qp", crf)
+	default:
+		args = append(args, "-crf", crf)
+	}
+	return append([]string{"-filter:v:0", vf}, args...)
+}
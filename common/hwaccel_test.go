@@ -0,0 +1,28 @@
+package common
+
+import "testing"
+
+func TestProbeHWAccel(t *testing.T) {
+	nvenc := &CheckResult{Items: []CheckItem{{Name: "libx264", OK: true}, {Name: "h264_nvenc", OK: true}}}
+	noNvenc := &CheckResult{Items: []CheckItem{{Name: "libx264", OK: true}, {Name: "h264_nvenc", OK: false}}}
+	tests := []struct {
+		name string
+		c    Config
+		r    *CheckResult
+		want string
+	}{
+		{"支持硬件编码器", Config{Burn: true, HWAccel: HWAccelNvenc}, nvenc, HWAccelNvenc},
+		{"不支持时回退到软件编码", Config{Burn: true, HWAccel: HWAccelNvenc}, noNvenc, HWAccelNone},
+		{"无法检查时回退到软件编码", Config{Burn: true, HWAccel: HWAccelNvenc}, nil, HWAccelNone},
+		{"不压制弹幕时不检查", Config{HWAccel: HWAccelNvenc}, nil, HWAccelNvenc},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := tt.c
+			c.probeHWAccel(tt.r)
+			if c.HWAccel != tt.want {
+				t.Errorf("HWAccel = %q, want %q", c.HWAccel, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"m4s-converter/conver"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ListEntry -list列出的单个缓存目录
+type ListEntry struct {
+	Dir        string // 缓存目录
+	GroupTitle string // 视频组名称
+	Title      string // 视频名称
+	Uname      string // 上传的用户名
+	Status     string // 缓存状态，completed为缓存完成
+	Video      bool   // 是否有视频m4s
+	Audio      bool   // 是否有音频m4s
+	Danmaku    bool   // 是否有本地的xml弹幕
+}
+
+// List 列出缓存路径下的所有缓存目录，只读取videoInfo和文件列表，不转换m4s也不合成
+// 查找目录和判断音视频的方式与合成时相同，按视频组名称和视频名称排序
+func (cv *Converter) List() ([]ListEntry, error) {
+	c := cv.Config
+	if len(c.CacheRoots()) == 0 {
+		return nil, errors.New("未指定 bilibili 缓存路径")
+	}
+	setM4sExts(c.M4sExts)
+	if err := c.openArchives(); err != nil {
+		return nil, err
+	}
+	defer c.closeArchives()
+	var entries []ListEntry
+	for _, root := range c.CacheRoots() {
+		dirs, err := c.cacheDirs(root)
+		if err != nil {
+			return nil, err
+		}
+		// 识别音视频需要读取m4s的文件头和大小
+		if err = c.extractM4s(root, dirs); err != nil {
+			return nil, err
+		}
+		for _, v := range dirs {
+			if IsPageDir(v) {
+				continue // 分P子目录随所在的缓存目录一起列出
+			}
+			e := c.listEntry(v)
+			e.Dir = c.zipDir(v)
+			entries = append(entries, e)
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].GroupTitle != entries[j].GroupTitle {
+			return entries[i].GroupTitle < entries[j].GroupTitle
+		}
+		return entries[i].Title < entries[j].Title
+	})
+	return entries, nil
+}
+
+// listEntry 读取缓存目录的videoInfo，并检查目录及分P子目录中的音视频和弹幕文件
+func (c *Config) listEntry(v string) ListEntry {
+	e := ListEntry{Dir: v}
+	if js, err := readVideoInfo(v); err == nil {
+		e.GroupTitle = mustString(js.Get("groupTitle"))
+		e.Title = mustString(js.Get("title"))
+		e.Uname = mustString(js.Get("uname"))
+		e.Status = mustString(js.Get("status"))
+	}
+	_ = filepath.Walk(v, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() {
+			if localDanmaku(filepath.Join(path, dirCid(path)+conver.XmlSuffix)) {
+				e.Danmaku = true
+			}
+			return nil
+		}
+		if !isM4s(path) {
+			return nil
+		}
+		dst, _ := c.m4sDst(path)
+		switch {
+		case strings.HasSuffix(dst, conver.VideoSuffix):
+			e.Video = true
+		case strings.HasSuffix(dst, conver.AudioSuffix):
+			e.Audio = true
+		}
+		return nil
+	})
+	return e
+}
+
+// PrintList 以表格形式打印List的结果
+func PrintList(w io.Writer, entries []ListEntry) {
+	rows := [][]string{{"视频组", "视频名称", "UP主", "状态", "视频", "音频", "弹幕"}}
+	for _, e := range entries {
+		rows = append(rows, []string{e.GroupTitle, e.Title, e.Uname, e.Status, mark(e.Video), mark(e.Audio), mark(e.Danmaku)})
+	}
+	widths := make([]int, len(rows[0]))
+	for _, row := range rows {
+		for i, cell := range row {
+			if n := displayWidth(cell); n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+	for _, row := range rows {
+		var line strings.Builder
+		for i, cell := range row {
+			line.WriteString(cell)
+			if i < len(row)-1 {
+				line.WriteString(strings.Repeat(" ", widths[i]-displayWidth(cell)+2))
+			}
+		}
+		_, _ = fmt.Fprintln(w, line.String())
+	}
+	_, _ = fmt.Fprintf(w, "共%d个缓存目录\n", len(entries))
+}
+
+func mark(ok bool) string {
+	if ok {
+		return "✓"
+	}
+	return "-"
+}
+
+// displayWidth 返回字符串在终端中的显示宽度，中日韩文字和全角符号占两列
+func displayWidth(s string) int {
+	n := 0
+	for _, r := range s {
+		if r >= 0x1100 && (r <= 0x115F || r >= 0x2E80 && r <= 0xA4CF || r >= 0xAC00 && r <= 0xD7A3 ||
+			r >= 0xF900 && r <= 0xFAFF || r >= 0xFE30 && r <= 0xFE4F || r >= 0xFF00 && r <= 0xFF60 ||
+			r >= 0xFFE0 && r <= 0xFFE6 || r >= 0x20000) {
+			n += 2
+		} else {
+			n++
+		}
+	}
+	return n
+}
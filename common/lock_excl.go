@@ -0,0 +1,43 @@
+//go:build solaris || illumos
+
+package common
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// LockMutex solaris和illumos没有flock，用O_CREATE|O_EXCL创建写入进程号的锁文件，
+// 锁文件已存在且其中的进程仍在运行时返回ErrAlreadyRunning，进程已退出时视为上次异常退出留下的锁文件，删除后重新创建
+func (c *Config) LockMutex(name string) error {
+	path := filepath.Join(os.TempDir(), name+".lock")
+	for i := 0; i < 2; i++ {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			_, err = f.WriteString(strconv.Itoa(os.Getpid()))
+			if e := f.Close(); err == nil {
+				err = e
+			}
+			return err
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if pid, e := strconv.Atoi(strings.TrimSpace(string(data))); e == nil && pid != os.Getpid() &&
+			syscall.Kill(pid, 0) != syscall.ESRCH {
+			return ErrAlreadyRunning
+		}
+		if err = os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return ErrAlreadyRunning
+}
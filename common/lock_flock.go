@@ -0,0 +1,31 @@
+//go:build !windows && !solaris && !illumos
+
+package common
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// lockFile 单实例锁文件，进程退出时由系统释放锁
+var lockFile *os.File
+
+// LockMutex 非windows系统的单实例锁，在临时目录中创建锁文件并加flock排它锁，
+// 已被其它实例锁定时返回ErrAlreadyRunning
+func (c *Config) LockMutex(name string) error {
+	f, err := os.OpenFile(filepath.Join(os.TempDir(), name+".lock"), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	if err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		_ = f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return ErrAlreadyRunning
+		}
+		return err
+	}
+	lockFile = f // 保持文件打开，避免被回收时关闭文件释放锁
+	return nil
+}
@@ -2,11 +2,98 @@ package common
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
 	"github.com/bingoohuang/golog"
+	"github.com/bingoohuang/golog/pkg/logfmt"
+	"github.com/bingoohuang/golog/pkg/rotate"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	LogFile           = "m4s.log"
+	defaultLogMaxMB   = 10
+	defaultLogBackups = 5
 )
 
+var logResult *logfmt.Result
+
 func InitLog() {
+	SetupLog(LogFile, defaultLogMaxMB, defaultLogBackups)
+}
+
+// SetupLog 同时输出到控制台和日志文件，单个日志文件超过maxMB后轮转，最多保留backups个旧文件
+func SetupLog(path string, maxMB, backups int) {
+	CloseLog()
 	layout := `%t{yyyy-MM-dd_HH:mm:ss} [%-5l{length=5,printColor=true}] %msg{singleLine=false}%n`
-	spec := fmt.Sprintf("file=%s,stdout=true", "m4s.log")
-	golog.Setup(golog.Layout(layout), golog.Spec(spec))
+	spec := fmt.Sprintf("file=%s,stdout=true,maxSize=%dM", path, maxMB)
+	logResult = golog.Setup(golog.Layout(layout), golog.Spec(spec))
+	if logResult.Rotate != nil {
+		// golog只能按总大小删除旧日志，轮转后由backupHook按数量删除
+		logrus.AddHook(&backupHook{rotate: logResult.Rotate, backups: backups})
+	}
+}
+
+// backupHook 在日志文件轮转后删除超出数量的旧日志文件
+type backupHook struct {
+	rotate  *rotate.Rotate
+	backups int
+	mu      sync.Mutex
+	last    string // 上次检查时最新的旧日志文件，变化说明发生了轮转
+}
+
+func (h *backupHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *backupHook) Fire(*logrus.Entry) error {
+	last := h.rotate.CurrentFileName()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if last != h.last {
+		h.last = last
+		pruneLogs(h.rotate.LogFile(), h.backups)
+	}
+	return nil
+}
+
+// pruneLogs 按修改时间保留最新的backups个旧日志文件，
+// 正在写入的始终是path，轮转时改名为path加上日期和序号
+func pruneLogs(path string, backups int) {
+	files, _ := filepath.Glob(path + ".*")
+	type logFile struct {
+		name  string
+		mtime int64
+	}
+	var olds []logFile
+	for _, f := range files {
+		if st, err := os.Stat(f); err == nil && st.Mode().IsRegular() {
+			olds = append(olds, logFile{f, st.ModTime().UnixNano()})
+		}
+	}
+	sort.Slice(olds, func(i, j int) bool { return olds[i].mtime > olds[j].mtime })
+	for i := backups; i < len(olds); i++ {
+		_ = os.Remove(olds[i].name)
+	}
+}
+
+// CloseLog 将缓冲的日志写入文件并关闭
+func CloseLog() {
+	if logResult != nil && logResult.Rotate != nil {
+		_ = logResult.OnExit()
+	}
+	logResult = nil
+}
+
+// SetLogLevel 设置日志级别，可选debug、info、warn、error，可在InitConfig之后随时调整
+func SetLogLevel(level string) error {
+	l, err := logrus.ParseLevel(level)
+	if err != nil {
+		return fmt.Errorf("不支持的日志级别：%s", level)
+	}
+	logrus.SetLevel(l)
+	return nil
 }
@@ -0,0 +1,50 @@
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestSetupLogBackups(t *testing.T) {
+	line := strings.Repeat("日志", 1000)
+	tests := []struct {
+		name    string
+		backups int
+		old     []string // 上次运行留下的旧日志
+		want    int      // 保留的旧日志数量
+	}{
+		{"不保留旧日志", 0, nil, 0},
+		{"最多保留2个", 2, nil, 2},
+		{"删除上次运行留下的多余旧日志", 1, []string{"a.log.2020-01-01", "a.log.2020-01-02"}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, name := range tt.old {
+				writeFile(t, dir, name, "old")
+			}
+			restoreLog(t)
+			SetupLog(filepath.Join(dir, "a.log"), 1, tt.backups)
+			for i := 0; i < 1000; i++ { // 约6MB，轮转5次以上
+				logrus.Info(line)
+			}
+			CloseLog()
+			olds, _ := filepath.Glob(filepath.Join(dir, "a.log.*"))
+			if len(olds) != tt.want {
+				t.Errorf("保留了%d个旧日志%q，应为%d个", len(olds), olds, tt.want)
+			}
+			for _, name := range tt.old {
+				if Exist(filepath.Join(dir, name)) {
+					t.Errorf("上次运行留下的%s未删除", name)
+				}
+			}
+			if _, err := os.Stat(filepath.Join(dir, "a.log")); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
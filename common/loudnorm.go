@@ -0,0 +1,63 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// loudnorm目标响度，EBU R128推荐值
+const loudnormTarget = "I=-16:TP=-1.5:LRA=11"
+
+// loudness loudnorm第一遍分析输出的json
+type loudness struct {
+	InputI       string `json:"input_i"`
+	InputTP      string `json:"input_tp"`
+	InputLRA     string `json:"input_lra"`
+	InputThresh  string `json:"input_thresh"`
+	TargetOffset string `json:"target_offset"`
+}
+
+// loudnormFilter 返回音频转码时的loudnorm滤镜，未开启-loudnorm时返回空
+// 默认单遍处理，-loudnorm-2pass时先分析音频响度，再按测量值精确调整
+func (c *Config) loudnormFilter(ctx context.Context, audioFile string) (string, error) {
+	if !c.Loudnorm {
+		return "", nil
+	}
+	if !c.Loudnorm2Pass {
+		return "loudnorm=" + loudnormTarget, nil
+	}
+	l, err := c.measureLoudness(ctx, audioFile)
+	if err != nil {
+		return "", fmt.Errorf("分析音频响度失败: %w", err)
+	}
+	return fmt.Sprintf("loudnorm=%s:measured_I=%s:measured_TP=%s:measured_LRA=%s:measured_thresh=%s:offset=%s:linear=true",
+		loudnormTarget, l.InputI, l.InputTP, l.InputLRA, l.InputThresh, l.TargetOffset), nil
+}
+
+// measureLoudness 执行loudnorm第一遍分析，从ffmpeg输出的最后一个json中读取测量值
+func (c *Config) measureLoudness(ctx context.Context, audioFile string) (*loudness, error) {
+	ctx, cancel := c.ffmpegContext(ctx)
+	defer cancel()
+	_, out, err := c.output(ctx,
+		"-hide_banner", "-nostats",
+		"-i", audioFile,
+		"-vn",
+		"-af", "loudnorm="+loudnormTarget+":print_format=json",
+		"-f", "null", "-",
+	)
+	if err != nil {
+		return nil, err
+	}
+	start, end := bytes.LastIndexByte(out, '{'), bytes.LastIndexByte(out, '}')
+	if start < 0 || end < start {
+		return nil, errors.New("ffmpeg没有输出响度信息")
+	}
+	var l loudness
+	if err = json.Unmarshal(out[start:end+1], &l); err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
@@ -0,0 +1,189 @@
+package common
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"m4s-converter/conver"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// headerProbeSize 查找MP4 box时读取的文件头长度
+const headerProbeSize = 1024
+
+// mp4BoxTypes fMP4文件开头可能出现的box类型
+var mp4BoxTypes = [][]byte{[]byte("ftyp"), []byte("styp"), []byte("sidx"), []byte("moof")}
+
+// headerOffset 返回bilibili在m4s文件前附加的头部长度
+// 不同版本的头部不同，如9个字符0、单个0、$等，这里不判断具体内容，
+// 而是查找第一个合法的MP4 box（4字节长度+box类型），其之前的内容都视为头部。
+// 文件本身就以合法box开头时返回0，找不到合法box时ok为false
+func headerOffset(data []byte) (offset int, ok bool) {
+	for i := 0; i+8 <= len(data); i++ {
+		for _, t := range mp4BoxTypes {
+			if !bytes.Equal(data[i+4:i+8], t) {
+				continue
+			}
+			if size := binary.BigEndian.Uint32(data[i : i+4]); size >= 8 {
+				return i, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// trackProbeSize 判断轨道类型时读取的文件头长度，m4s开头的moov通常只有几KB
+const trackProbeSize = 64 * 1024
+
+// trackType 读取m4s开头的moov，返回轨道的handler类型：vide为视频，soun为音频，无法判断时为空
+func trackType(src string) string {
+	f, err := os.Open(src)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	data := make([]byte, trackProbeSize)
+	n, _ := io.ReadFull(f, data)
+	data = data[:n]
+	offset, ok := headerOffset(data)
+	if !ok {
+		return ""
+	}
+	var handler string
+	mp4Box(data[offset:], func(typ string, body []byte) bool {
+		if typ != "moov" {
+			return true
+		}
+		mp4Box(body, func(typ string, body []byte) bool {
+			if typ == "trak" {
+				handler = trakHandler(body)
+			}
+			return handler == ""
+		})
+		return false
+	})
+	return handler
+}
+
+// m4sDst 返回m4s文件去掉头部后的音频或视频文件路径，未选中的其它清晰度返回空
+// 优先根据moov中的轨道类型判断音视频，无法判断时再按.playurl中的ID或文件大小判断
+func (c *Config) m4sDst(src string) (string, error) {
+	return c.m4sDstAs(src, filepath.Base(src))
+}
+
+// m4sDstAs 与m4sDst相同，但按name生成文件名和匹配ID，用于分段的m4s按合并后的轨道名称转换
+func (c *Config) m4sDstAs(src, name string) (string, error) {
+	dir := c.tempDir(filepath.Dir(src))
+	stem := trimM4sExt(name)
+	audioDst := filepath.Join(dir, stem+conver.AudioSuffix)
+	videoDst := filepath.Join(dir, stem+conver.VideoSuffix)
+	videoId, audioId, err := GetVAId(src, c.Quality)
+	if err != nil && !errors.Is(err, ErrNoPlayUrl) {
+		return "", err
+	}
+	// 没有.playurl时不按ID筛选
+	hasId := func(id string) bool {
+		return err != nil || strings.HasSuffix(stem, "-"+id)
+	}
+	switch trackType(src) {
+	case "vide":
+		if !hasId(videoId) {
+			return "", nil
+		}
+		return videoDst, nil
+	case "soun":
+		if !hasId(audioId) {
+			return "", nil
+		}
+		return audioDst, nil
+	}
+	switch {
+	case errors.Is(err, ErrNoPlayUrl):
+		isVideo, e := guessBySize(src)
+		if e != nil {
+			return "", e
+		}
+		if isVideo {
+			return videoDst, nil
+		}
+		return audioDst, nil
+	case strings.HasSuffix(stem, "-"+audioId): // 音频文件
+		return audioDst, nil
+	case strings.HasSuffix(stem, "-"+videoId): // 视频文件
+		return videoDst, nil
+	}
+	return "", nil
+}
+
+// guessBySize 没有.playurl时按文件大小判断m4s是否为视频
+// 同一目录下通常只有一个视频和一个音频m4s，视频的码率远高于音频，
+// 所以最大的m4s视为视频，其它视为音频；目录下只有一个m4s时无法判断
+func guessBySize(src string) (isVideo bool, err error) {
+	st, err := os.Stat(src)
+	if err != nil {
+		return false, err
+	}
+	entries, err := os.ReadDir(filepath.Dir(src))
+	if err != nil {
+		return false, err
+	}
+	count := 0
+	for _, e := range entries {
+		if e.IsDir() || !isM4s(e.Name()) {
+			continue
+		}
+		count++
+		if info, err := e.Info(); err == nil && info.Size() > st.Size() {
+			return false, nil
+		}
+	}
+	if count < 2 {
+		return false, errors.New("没有.playurl文件，且只有一个m4s文件，无法判断是音频还是视频")
+	}
+	return true, nil
+}
+
+// ErrBadM4sHeader m4s文件开头找不到合法的MP4 box，文件可能已损坏
+var ErrBadM4sHeader = errors.New("无法识别的m4s文件头，文件可能已损坏")
+
+// M4sError 转换m4s文件失败，File为出错的m4s文件，分段的m4s为第一段
+type M4sError struct {
+	File string
+	Err  error
+}
+
+func (e *M4sError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *M4sError) Unwrap() error {
+	return e.Err
+}
+
+// m4sFailures 从ConvertM4sFiles返回的错误中取出每个缓存目录第一个转换失败的m4s，分P子目录归入所在的缓存目录
+func m4sFailures(err error) map[string]*M4sError {
+	failed := make(map[string]*M4sError)
+	var errs []error
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		errs = joined.Unwrap()
+	} else if err != nil {
+		errs = []error{err}
+	}
+	for _, e := range errs {
+		var me *M4sError
+		if !errors.As(e, &me) {
+			continue
+		}
+		dir := filepath.Dir(me.File)
+		if IsPageDir(dir) {
+			dir = filepath.Dir(dir)
+		}
+		if failed[dir] == nil {
+			failed[dir] = me
+		}
+	}
+	return failed
+}
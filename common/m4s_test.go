@@ -0,0 +1,207 @@
+package common
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"m4s-converter/conver"
+)
+
+// ftypBox 长度为16字节的ftyp box
+var ftypBox = []byte("\x00\x00\x00\x10ftypiso5\x00\x00\x00\x00")
+
+func TestHeaderOffset(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   []byte
+		offset int
+		ok     bool
+	}{
+		{"没有头部", ftypBox, 0, true},
+		{"9个字符0", append([]byte("000000000"), ftypBox...), 9, true},
+		{"单个0", append([]byte("0"), ftypBox...), 1, true},
+		{"$标记", append([]byte("$$$"), ftypBox...), 3, true},
+		{"styp开头", []byte("\x00\x00\x00\x18stypmsdh"), 0, true},
+		{"长度小于8的box", []byte("\x00\x00\x00\x04ftyp"), 0, false},
+		{"不完整的box", []byte("000\x00\x00\x00"), 0, false},
+		{"空文件", nil, 0, false},
+		{"不是mp4", []byte("<?xml version=\"1.0\"?>"), 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			offset, ok := headerOffset(tt.data)
+			if offset != tt.offset || ok != tt.ok {
+				t.Errorf("headerOffset() = %d, %v, want %d, %v", offset, ok, tt.offset, tt.ok)
+			}
+		})
+	}
+}
+
+func TestM4sToAV(t *testing.T) {
+	payload := append(append([]byte{}, ftypBox...), []byte("\x00\x00\x00\x08mdat")...)
+	tests := []struct {
+		name    string
+		data    []byte
+		want    []byte
+		wantErr error
+	}{
+		{"9个字符0", append([]byte("000000000"), payload...), payload, nil},
+		{"单个0", append([]byte("0"), payload...), payload, nil},
+		{"$标记", append([]byte("$"), payload...), payload, nil},
+		{"没有头部", payload, payload, nil},
+		{"头部被截断", []byte("000000000\x00\x00"), nil, ErrBadM4sHeader},
+		{"头部损坏", append([]byte("000000000"), bytes.Repeat([]byte{0xff}, 64)...), nil, ErrBadM4sHeader},
+	}
+	dir := t.TempDir()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := filepath.Join(dir, "src.m4s")
+			dst := filepath.Join(dir, "dst.mp4")
+			_ = os.Remove(dst)
+			if err := os.WriteFile(src, tt.data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			err := M4sToAV(src, dst)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("M4sToAV() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				if Exist(dst) {
+					t.Error("转换失败时不应生成输出文件")
+				}
+				return
+			}
+			got, err := os.ReadFile(dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("M4sToAV() 输出 %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestM4sDstWithoutPlayUrl(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]int // m4s文件及其大小
+		src     string
+		want    string // 输出文件的后缀
+		wantErr bool
+	}{
+		{"最大的为视频", map[string]int{"1-100.m4s": 1000, "1-30280.m4s": 100}, "1-100.m4s", conver.VideoSuffix, false},
+		{"较小的为音频", map[string]int{"1-100.m4s": 1000, "1-30280.m4s": 100}, "1-30280.m4s", conver.AudioSuffix, false},
+		{"只有一个m4s无法判断", map[string]int{"1-100.m4s": 1000}, "1-100.m4s", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, size := range tt.files {
+				if err := os.WriteFile(filepath.Join(dir, name), bytes.Repeat([]byte{1}, size), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := (&Config{}).m4sDst(filepath.Join(dir, tt.src))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("m4sDst() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if want := filepath.Join(dir, trimM4sExt(tt.src)+tt.want); got != want {
+				t.Errorf("m4sDst() = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestConvertM4sSkipsUpToDate(t *testing.T) {
+	payload := append(append([]byte{}, ftypBox...), bytes.Repeat([]byte{1}, 100)...)
+	video := append([]byte("000000000"), append(payload, bytes.Repeat([]byte{2}, 1000)...)...)
+	audio := append([]byte("000000000"), payload...)
+	tests := []struct {
+		name    string
+		overlay string
+		prepare func(src, dst string) // 第二次转换之前修改文件
+		copied  bool                  // 第二次是否重新转换
+	}{
+		{"已转换过时跳过", "-n", nil, false},
+		{"-o时重新转换", "-y", nil, true},
+		{"m4s更新后重新转换", "-n", func(src, dst string) {
+			later := time.Now().Add(2 * time.Hour)
+			_ = os.Chtimes(src, later, later)
+		}, true},
+		{"上次转换不完整时重新转换", "-n", func(src, dst string) { _ = os.Truncate(dst, 10) }, true},
+		{"转换结果被删除后重新转换", "-n", func(src, dst string) { _ = os.Remove(dst) }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			src := writeFile(t, dir, "1-100.m4s", string(video))
+			writeFile(t, dir, "1-30280.m4s", string(audio))
+			dst := filepath.Join(dir, "1-100"+conver.VideoSuffix)
+			c := &Config{Overlay: tt.overlay, Jobs: 1}
+			files := []string{src, filepath.Join(dir, "1-30280.m4s")}
+			if err := c.ConvertM4sFiles(files); err != nil {
+				t.Fatal(err)
+			}
+			// 记下第一次转换的修改时间，重新转换时文件的修改时间会变化
+			mark := time.Now().Add(time.Hour).Truncate(time.Second)
+			if err := os.Chtimes(dst, mark, mark); err != nil {
+				t.Fatal(err)
+			}
+			if tt.prepare != nil {
+				tt.prepare(src, dst)
+			}
+			if err := c.ConvertM4sFiles(files); err != nil {
+				t.Fatal(err)
+			}
+			st, err := os.Stat(dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if copied := !st.ModTime().Equal(mark); copied != tt.copied {
+				t.Errorf("第二次转换时重新复制了文件: %v，应为%v", copied, tt.copied)
+			}
+			if st.Size() != int64(len(video)-9) {
+				t.Errorf("转换后的文件大小为%d，应为%d", st.Size(), len(video)-9)
+			}
+		})
+	}
+}
+
+func TestConvertM4sFailures(t *testing.T) {
+	audio := append(append([]byte("000000000"), ftypBox...), bytes.Repeat([]byte{1}, 100)...)
+	tests := []struct {
+		name    string
+		files   map[string][]byte
+		bad     string // 应记录为失败的m4s
+		wantErr error  // 为nil时只检查有错误
+	}{
+		{"头部损坏", map[string][]byte{"1-100.m4s": append([]byte("000000000"), bytes.Repeat([]byte{0xff}, 1000)...), "1-30280.m4s": audio},
+			"1-100.m4s", ErrBadM4sHeader},
+		{"无法识别音视频", map[string][]byte{"1-100.m4s": audio}, "1-100.m4s", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			var files []string
+			for name, content := range tt.files {
+				files = append(files, writeFile(t, dir, name, string(content)))
+			}
+			err := (&Config{Jobs: 1}).ConvertM4sFiles(files)
+			if err == nil || tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ConvertM4sFiles() error = %v, want %v", err, tt.wantErr)
+			}
+			failed := m4sFailures(err)
+			if me := failed[dir]; me == nil || me.File != filepath.Join(dir, tt.bad) {
+				t.Errorf("m4sFailures() = %v，应记录%s", failed, tt.bad)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package common
+
+import (
+	"strings"
+
+	"m4s-converter/conver"
+)
+
+// partialSuffixes 客户端下载未完成时使用的临时文件后缀，如 xxx.m4s.download，不作为m4s处理
+var partialSuffixes = []string{".download", ".part", ".crdownload", ".tmp"}
+
+// m4sExts 识别为m4s的扩展名，不区分大小写，Prepare时加上-m4s-ext指定的扩展名
+var m4sExts = []string{conver.M4sSuffix}
+
+// setM4sExts 设置m4s以外额外识别的扩展名，没有点号时自动加上
+func setM4sExts(exts []string) {
+	m4sExts = []string{conver.M4sSuffix}
+	for _, ext := range exts {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext == "" {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		m4sExts = append(m4sExts, ext)
+	}
+}
+
+// isPartial 判断文件是否为下载未完成的临时文件
+func isPartial(name string) bool {
+	lower := strings.ToLower(name)
+	for _, s := range partialSuffixes {
+		if strings.HasSuffix(lower, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// m4sExt 返回文件名中的m4s扩展名（保留原有大小写），不是m4s或为下载未完成的临时文件时返回空
+func m4sExt(name string) string {
+	if isPartial(name) {
+		return ""
+	}
+	lower := strings.ToLower(name)
+	for _, ext := range m4sExts {
+		if strings.HasSuffix(lower, ext) && len(name) > len(ext) {
+			return name[len(name)-len(ext):]
+		}
+	}
+	return ""
+}
+
+// isM4s 判断文件是否为m4s文件，扩展名不区分大小写
+func isM4s(name string) bool {
+	return m4sExt(name) != ""
+}
+
+// trimM4sExt 去掉文件名中的m4s扩展名
+func trimM4sExt(name string) string {
+	return strings.TrimSuffix(name, m4sExt(name))
+}
@@ -0,0 +1,66 @@
+package common
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestIsM4s(t *testing.T) {
+	defer setM4sExts(nil)
+	tests := []struct {
+		name    string
+		exts    []string // -m4s-ext
+		file    string
+		m4s     bool
+		partial bool
+		trimmed string
+	}{
+		{"小写", nil, "1-100.m4s", true, false, "1-100"},
+		{"大写", nil, "1-100.M4S", true, false, "1-100"},
+		{"大小写混合", nil, "1-100.M4s", true, false, "1-100"},
+		{"下载未完成", nil, "1-100.m4s.download", false, true, "1-100.m4s.download"},
+		{"大写的临时文件后缀", nil, "1-100.m4s.PART", false, true, "1-100.m4s.PART"},
+		{"其它扩展名", nil, "1-100.blv", false, false, "1-100.blv"},
+		{"只有扩展名", nil, ".m4s", false, false, ".m4s"},
+		{"-m4s-ext", []string{"blv"}, "1-100.BLV", true, false, "1-100"},
+		{"-m4s-ext带点号", []string{" .blv "}, "1-100.blv", true, false, "1-100"},
+		{"-m4s-ext不影响m4s", []string{"blv"}, "1-100.m4s", true, false, "1-100"},
+		{"-m4s-ext的临时文件", []string{"blv"}, "1-100.blv.crdownload", false, true, "1-100.blv.crdownload"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setM4sExts(tt.exts)
+			if got := isM4s(tt.file); got != tt.m4s {
+				t.Errorf("isM4s(%q) = %v, want %v", tt.file, got, tt.m4s)
+			}
+			if got := isPartial(tt.file); got != tt.partial {
+				t.Errorf("isPartial(%q) = %v, want %v", tt.file, got, tt.partial)
+			}
+			if got := trimM4sExt(tt.file); got != tt.trimmed {
+				t.Errorf("trimM4sExt(%q) = %q, want %q", tt.file, got, tt.trimmed)
+			}
+		})
+	}
+}
+
+func TestListM4sFiles(t *testing.T) {
+	defer setM4sExts(nil)
+	setM4sExts([]string{"blv"})
+	root := t.TempDir()
+	for _, name := range []string{"1/a.m4s", "1/b.M4S", "1/c.m4s.download", "1/d.part", "2/e.blv", "2/f.mp4"} {
+		writeFile(t, root, filepath.FromSlash(name), "")
+	}
+	files, err := listM4sFiles(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, f := range files {
+		rel, _ := filepath.Rel(root, f)
+		got = append(got, filepath.ToSlash(rel))
+	}
+	if want := []string{"1/a.m4s", "1/b.M4S", "2/e.blv"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("listM4sFiles() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,97 @@
+package common
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// -match-field可选的videoInfo字段
+const (
+	MatchTitle      = "title"
+	MatchGroupTitle = "groupTitle"
+	MatchUname      = "uname"
+)
+
+// compileMatch 编译-match和-match-exclude的正则表达式，为空时返回nil
+func compileMatch(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("匹配规则 %q 不是有效的正则表达式：%w", pattern, err)
+	}
+	return re, nil
+}
+
+// Selected 判断videoInfo中的字段是否通过-match和-match-exclude的筛选
+func (c *Config) Selected(fields map[string]string) bool {
+	field := c.MatchField
+	if field == "" {
+		field = MatchTitle
+	}
+	value := fields[field]
+	if c.Match != nil && !c.Match.MatchString(value) {
+		return false
+	}
+	return c.NotMatch == nil || !c.NotMatch.MatchString(value)
+}
+
+// hasIDFilter 是否通过-cid或-bvid只处理一个视频
+func (c *Config) hasIDFilter() bool {
+	return c.Cid != "" || c.Bvid != ""
+}
+
+// matchID 判断缓存目录的videoInfo是否与-cid或-bvid相同，-bvid也可以是av号(aid)
+func (c *Config) matchID(dir string) bool {
+	js, err := readVideoInfo(dir)
+	if err != nil {
+		return false
+	}
+	if c.Cid != "" && jsonID(js.Get("cid")) != c.Cid {
+		return false
+	}
+	if c.Bvid != "" {
+		id := strings.TrimPrefix(strings.ToLower(c.Bvid), "av")
+		if !strings.EqualFold(mustString(js.Get("bvid")), c.Bvid) && jsonID(js.Get("aid")) != id {
+			return false
+		}
+	}
+	return true
+}
+
+// filterByID 返回与-cid或-bvid匹配的缓存目录，匹配的是分P子目录时返回所在的缓存目录
+func (c *Config) filterByID(dirs []string) []string {
+	seen := make(map[string]bool)
+	var matched []string
+	for _, d := range dirs {
+		if !c.matchID(d) {
+			continue
+		}
+		if IsPageDir(d) {
+			d = filepath.Dir(d)
+		}
+		if !seen[d] {
+			seen[d] = true
+			matched = append(matched, d)
+			logrus.Info("找到匹配的缓存目录:", d)
+		}
+	}
+	return matched
+}
+
+// idString 返回-cid和-bvid的描述，用于错误信息
+func (c *Config) idString() string {
+	var parts []string
+	if c.Cid != "" {
+		parts = append(parts, "cid为"+c.Cid)
+	}
+	if c.Bvid != "" {
+		parts = append(parts, "bvid为"+c.Bvid)
+	}
+	return strings.Join(parts, "、")
+}
@@ -0,0 +1,62 @@
+package common
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSelected(t *testing.T) {
+	videos := []map[string]string{
+		{MatchTitle: "蛇的工作原理", MatchGroupTitle: "3D动画之工作原理", MatchUname: "珂姬与科技"},
+		{MatchTitle: "猫的工作原理", MatchGroupTitle: "3D动画之工作原理", MatchUname: "珂姬与科技"},
+		{MatchTitle: "第1集 开始", MatchGroupTitle: "某番剧", MatchUname: "番剧出差"},
+		{MatchTitle: "第2集 PV", MatchGroupTitle: "某番剧", MatchUname: "番剧出差"},
+	}
+	tests := []struct {
+		name    string
+		match   string
+		exclude string
+		field   string
+		want    []int // 通过筛选的视频序号
+	}{
+		{"不筛选", "", "", "", []int{0, 1, 2, 3}},
+		{"默认匹配title", "工作原理", "", "", []int{0, 1}},
+		{"正则匹配", `^第\d+集`, "", "", []int{2, 3}},
+		{"排除", "", "PV", "", []int{0, 1, 2}},
+		{"匹配后排除", "集", "PV", MatchTitle, []int{2}},
+		{"匹配groupTitle", "番剧", "", MatchGroupTitle, []int{2, 3}},
+		{"匹配uname", "^珂姬", "", MatchUname, []int{0, 1}},
+		{"title不匹配uname的值", "珂姬", "", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match, err := compileMatch(tt.match)
+			if err != nil {
+				t.Fatal(err)
+			}
+			exclude, err := compileMatch(tt.exclude)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := &Config{Match: match, NotMatch: exclude, MatchField: tt.field}
+			var got []int
+			for i, v := range videos {
+				if c.Selected(v) {
+					got = append(got, i)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("通过筛选的视频为%v，应为%v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileMatch(t *testing.T) {
+	if re, err := compileMatch(""); re != nil || err != nil {
+		t.Errorf("compileMatch(\"\") = %v, %v", re, err)
+	}
+	if _, err := compileMatch("("); err == nil {
+		t.Error("无效的正则表达式应返回错误")
+	}
+}
@@ -0,0 +1,169 @@
+package common
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// MP4Info 从moov box中读取的基本信息
+type MP4Info struct {
+	Duration time.Duration
+	HasVideo bool // 有vide类型的轨道
+	HasAudio bool // 有soun类型的轨道
+}
+
+// mp4Box 遍历data中的box，fn返回false时停止遍历
+func mp4Box(data []byte, fn func(typ string, body []byte) bool) {
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data[:4]))
+		typ := string(data[4:8])
+		header := uint64(8)
+		if size == 1 && len(data) >= 16 {
+			size = binary.BigEndian.Uint64(data[8:16])
+			header = 16
+		} else if size == 0 {
+			size = uint64(len(data))
+		}
+		if size < header || size > uint64(len(data)) {
+			return
+		}
+		if !fn(typ, data[header:size]) {
+			return
+		}
+		data = data[size:]
+	}
+}
+
+// readMoov 查找并读取文件顶层的moov box
+func readMoov(f *os.File) ([]byte, error) {
+	header := make([]byte, 16)
+	var offset int64
+	for {
+		if _, err := f.ReadAt(header[:8], offset); err != nil {
+			if err == io.EOF {
+				return nil, errors.New("找不到moov")
+			}
+			return nil, err
+		}
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		typ := string(header[4:8])
+		headerSize := int64(8)
+		if size == 1 {
+			if _, err := f.ReadAt(header[8:16], offset+8); err != nil {
+				return nil, err
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerSize = 16
+		}
+		if size == 0 {
+			st, err := f.Stat()
+			if err != nil {
+				return nil, err
+			}
+			size = st.Size() - offset
+		}
+		if size < headerSize {
+			return nil, fmt.Errorf("box %q 长度错误", typ)
+		}
+		if typ == "moov" {
+			moov := make([]byte, size-headerSize)
+			if _, err := f.ReadAt(moov, offset+headerSize); err != nil {
+				return nil, fmt.Errorf("moov不完整: %v", err)
+			}
+			return moov, nil
+		}
+		offset += size
+	}
+}
+
+// ReadMP4Info 读取mp4文件的时长和音视频轨道
+func ReadMP4Info(path string) (MP4Info, error) {
+	var info MP4Info
+	f, err := os.Open(path)
+	if err != nil {
+		return info, err
+	}
+	defer f.Close()
+	moov, err := readMoov(f)
+	if err != nil {
+		return info, err
+	}
+	mp4Box(moov, func(typ string, body []byte) bool {
+		switch typ {
+		case "mvhd":
+			info.Duration = mvhdDuration(body)
+		case "trak":
+			switch trakHandler(body) {
+			case "vide":
+				info.HasVideo = true
+			case "soun":
+				info.HasAudio = true
+			}
+		}
+		return true
+	})
+	return info, nil
+}
+
+// mvhdDuration 解析mvhd中的时长
+func mvhdDuration(body []byte) time.Duration {
+	if len(body) < 20 {
+		return 0
+	}
+	var timescale, duration uint64
+	if body[0] == 1 { // version 1 使用64位时间
+		if len(body) < 32 {
+			return 0
+		}
+		timescale = uint64(binary.BigEndian.Uint32(body[20:24]))
+		duration = binary.BigEndian.Uint64(body[24:32])
+	} else {
+		timescale = uint64(binary.BigEndian.Uint32(body[12:16]))
+		duration = uint64(binary.BigEndian.Uint32(body[16:20]))
+	}
+	if timescale == 0 {
+		return 0
+	}
+	return time.Duration(float64(duration) / float64(timescale) * float64(time.Second))
+}
+
+// trakHandler 返回trak/mdia/hdlr中的handler类型，如vide、soun
+func trakHandler(trak []byte) string {
+	var handler string
+	mp4Box(trak, func(typ string, body []byte) bool {
+		if typ != "mdia" {
+			return true
+		}
+		mp4Box(body, func(typ string, body []byte) bool {
+			if typ == "hdlr" && len(body) >= 12 {
+				handler = string(body[8:12])
+				return false
+			}
+			return true
+		})
+		return false
+	})
+	return handler
+}
+
+// VerifyMP4 检查合成的文件是否完整：有音视频轨道且时长不为0
+func VerifyMP4(path string) error {
+	info, err := ReadMP4Info(path)
+	if err != nil {
+		return err
+	}
+	if !info.HasVideo {
+		return errors.New("没有视频轨道")
+	}
+	if !info.HasAudio {
+		return errors.New("没有音频轨道")
+	}
+	if info.Duration <= 0 {
+		return errors.New("时长为0")
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package common
+
+import (
+	"os"
+	"time"
+
+	"github.com/bitly/go-simplejson"
+	"github.com/sirupsen/logrus"
+)
+
+// -preserve-mtime可选的修改时间来源
+const (
+	MtimeSource  = "source"  // 缓存中m4s文件的修改时间，即缓存完成的时间
+	MtimePubdate = "pubdate" // videoInfo中的发布时间
+	MtimeCtime   = "ctime"   // videoInfo中的投稿时间
+)
+
+// sourceMtime 返回目录中最新的m4s文件的修改时间，没有m4s文件时返回零值
+func sourceMtime(dir string) time.Time {
+	var latest time.Time
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		if e.IsDir() || !isM4s(e.Name()) {
+			continue
+		}
+		if fi, err := e.Info(); err == nil && fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest
+}
+
+// videoInfoTime 读取videoInfo中的时间戳字段，支持秒和毫秒，没有该字段时返回零值
+func videoInfoTime(js *simplejson.Json, keys ...string) time.Time {
+	for _, key := range keys {
+		n, err := js.Get(key).Int64()
+		if err != nil || n <= 0 {
+			continue
+		}
+		if n > 1e12 {
+			return time.UnixMilli(n)
+		}
+		return time.Unix(n, 0)
+	}
+	return time.Time{}
+}
+
+// preserveMtime 按-preserve-mtime将合成的文件的修改时间改为m4s文件或videoInfo中的时间，
+// videoInfo中没有对应字段时使用m4s文件的修改时间
+func (c *Config) preserveMtime(outputFile, dir string, js *simplejson.Json) {
+	var t time.Time
+	switch c.PreserveMtime {
+	case "":
+		return
+	case MtimePubdate:
+		t = videoInfoTime(js, "pubdate", "pubDate", "pubTime")
+	case MtimeCtime:
+		t = videoInfoTime(js, "ctime", "cTime")
+	}
+	if t.IsZero() {
+		if c.PreserveMtime != MtimeSource {
+			logrus.Debugf("videoInfo中没有%s，使用m4s文件的修改时间: %s", c.PreserveMtime, dir)
+		}
+		t = sourceMtime(dir)
+	}
+	if t.IsZero() {
+		logrus.Warn("找不到可用的修改时间，保留当前时间:", outputFile)
+		return
+	}
+	if err := os.Chtimes(outputFile, t, t); err != nil {
+		logrus.Warn("修改文件时间失败:", outputFile, " ", err)
+	}
+}
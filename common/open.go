@@ -0,0 +1,34 @@
+package common
+
+import (
+	"os/exec"
+	"runtime"
+
+	"github.com/sirupsen/logrus"
+)
+
+// opener 返回系统goos上打开目录的程序
+func opener(goos string) string {
+	switch goos {
+	case "windows":
+		return "explorer"
+	case "darwin":
+		return "open"
+	}
+	return "xdg-open"
+}
+
+// OpenDir 使用系统文件管理器打开目录
+func OpenDir(path string) error {
+	name := opener(runtime.GOOS)
+	bin, err := exec.LookPath(name)
+	if err != nil {
+		logrus.Warn("找不到打开目录的程序 ", name, ": ", err)
+		return err
+	}
+	if err = exec.Command(bin, path).Start(); err != nil {
+		logrus.Warn("打开目录失败: ", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package common
+
+import "testing"
+
+func TestOpener(t *testing.T) {
+	tests := []struct {
+		goos string
+		want string
+	}{
+		{"windows", "explorer"},
+		{"darwin", "open"},
+		{"linux", "xdg-open"},
+		{"freebsd", "xdg-open"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.goos, func(t *testing.T) {
+			if got := opener(tt.goos); got != tt.want {
+				t.Errorf("opener(%q) = %q, want %q", tt.goos, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOpenDirWithoutOpener(t *testing.T) {
+	t.Setenv("PATH", t.TempDir()) // 找不到打开目录的程序
+	if err := OpenDir(t.TempDir()); err == nil {
+		t.Error("找不到打开目录的程序时OpenDir()应返回错误")
+	}
+}
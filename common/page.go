@@ -0,0 +1,185 @@
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/bitly/go-simplejson"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"io/fs"
+	"m4s-converter/conver"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Page 分P视频中单个分P的音视频文件
+type Page struct {
+	Dir   string // 分P所在目录
+	Video string // 视频文件
+	Audio string // 音频文件
+	Ass   string // ass弹幕文件
+	Xml   string // 本次下载的xml弹幕，使用缓存中已有的弹幕时为空
+	Title string // 分P名称
+	Index int    // 分P序号，从1开始
+	Err   error  // 查找文件时的问题，如弹幕下载失败、缺少音视频文件，多个问题用errors.Join合并
+}
+
+// sortPages 按目录名排序分P，目录名为数字时按数值排序
+func sortPages(pageByDir map[string]*Page, assByDir map[string]string) []Page {
+	pages := make([]Page, 0, len(pageByDir))
+	for dir, p := range pageByDir {
+		p.Ass = assByDir[dir]
+		p.Title = pageTitle(dir)
+		pages = append(pages, *p)
+	}
+	sort.Slice(pages, func(i, j int) bool {
+		a, b := filepath.Base(pages[i].Dir), filepath.Base(pages[j].Dir)
+		na, ea := strconv.Atoi(a)
+		nb, eb := strconv.Atoi(b)
+		if ea == nil && eb == nil {
+			return na < nb
+		}
+		return pages[i].Dir < pages[j].Dir
+	})
+	for i := range pages {
+		pages[i].Index = i + 1
+	}
+	return pages
+}
+
+// readVideoInfo 读取目录下的videoInfo.json或.videoInfo文件
+func readVideoInfo(dir string) (*simplejson.Json, error) {
+	var err error
+	for _, name := range []string{conver.VideoInfoJson, conver.VideoInfoSuffix} {
+		var data []byte
+		if data, err = os.ReadFile(filepath.Join(dir, name)); err != nil {
+			continue
+		}
+		return parseVideoInfo(data)
+	}
+	return nil, err
+}
+
+// utf8BOM UTF-8编码的BOM头
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// parseVideoInfo 解析videoInfo，旧版客户端保存的文件可能带有UTF-8 BOM或使用GBK编码
+func parseVideoInfo(data []byte) (*simplejson.Json, error) {
+	data = bytes.TrimPrefix(data, utf8BOM)
+	if !utf8.Valid(data) {
+		decoded, err := simplifiedchinese.GBK.NewDecoder().Bytes(data)
+		if err != nil {
+			return nil, fmt.Errorf("videoInfo既不是UTF-8也不是GBK编码: %w", err)
+		}
+		data = decoded
+	}
+	return simplejson.NewJson(data)
+}
+
+// pageTitle 读取分P目录下videoInfo中的分P名称，没有时使用目录名
+func pageTitle(dir string) string {
+	if js, err := readVideoInfo(dir); err == nil {
+		for _, key := range []string{"part", "title"} {
+			if v := js.Get(key).MustString(); v != "" {
+				return Filter(v)
+			}
+		}
+	}
+	return filepath.Base(dir)
+}
+
+// dirCid 返回目录对应的cid，用于下载弹幕
+// 优先读取videoInfo中的cid，分P目录没有videoInfo时从上级目录videoInfo的分P列表中查找，都没有时使用目录名
+func dirCid(dir string) string {
+	if js, err := readVideoInfo(dir); err == nil {
+		if cid := jsonID(js.Get("cid")); cid != "" {
+			return cid
+		}
+		if cid := jsonID(js.GetPath("page_data", "cid")); cid != "" {
+			return cid
+		}
+	}
+	name := strings.TrimPrefix(filepath.Base(dir), "c_") // 手机客户端的分P目录名为c_<cid>
+	if cid := pageCid(filepath.Dir(dir), name); cid != "" {
+		return cid
+	}
+	return name
+}
+
+// pageCid 在缓存目录videoInfo的pages中查找分P目录name对应的cid，
+// 目录名与某个分P的cid相同时直接使用，否则按分P序号匹配，找不到时返回空
+func pageCid(parent, name string) string {
+	js, err := readVideoInfo(parent)
+	if err != nil {
+		return ""
+	}
+	pages, _ := js.Get("pages").Array()
+	index, _ := strconv.Atoi(name)
+	var byIndex string
+	for i := range pages {
+		p := js.Get("pages").GetIndex(i)
+		cid := jsonID(p.Get("cid"))
+		if cid == "" {
+			continue
+		}
+		if cid == name {
+			return cid
+		}
+		if n, _ := p.Get("page").Int(); index > 0 && n == index {
+			byIndex = cid
+		}
+	}
+	return byIndex
+}
+
+// jsonID 读取数字或字符串形式的ID
+func jsonID(js *simplejson.Json) string {
+	if n, err := js.Int64(); err == nil && n > 0 {
+		return strconv.FormatInt(n, 10)
+	}
+	s, _ := js.String()
+	return s
+}
+
+// isCacheEntry 判断目录是否为缓存目录或分P子目录，即包含videoInfo或.playurl文件
+func isCacheEntry(dir string) bool {
+	return Exist(filepath.Join(dir, conver.VideoInfoJson)) ||
+		Exist(filepath.Join(dir, conver.VideoInfoSuffix)) ||
+		Exist(filepath.Join(dir, conver.PlayUrlSuffix))
+}
+
+// loadLog windows客户端缓存根目录中的下载记录文件
+const loadLog = "load_log"
+
+// IsCacheRoot 判断path是否为bilibili缓存路径，即本身或下级目录中有videoInfo、.playurl或load_log文件，
+// 用于发现误将合成文件的output目录等其它目录指定为缓存路径
+func IsCacheRoot(path string) bool {
+	found := false
+	_ = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == path {
+				return err
+			}
+			return nil // 跳过无法读取的下级目录
+		}
+		switch d.Name() {
+		case conver.VideoInfoJson, conver.VideoInfoSuffix, conver.PlayUrlSuffix, loadLog:
+			if !d.IsDir() {
+				found = true
+				return filepath.SkipAll
+			}
+		}
+		return nil
+	})
+	return found
+}
+
+// IsPageDir 判断目录是否为分P视频中的分P子目录，即上级目录也是缓存目录
+func IsPageDir(dir string) bool {
+	parent := filepath.Dir(dir)
+	return Exist(filepath.Join(parent, conver.VideoInfoJson)) ||
+		Exist(filepath.Join(parent, conver.VideoInfoSuffix))
+}
@@ -0,0 +1,133 @@
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+// writeFile 在dir下创建文件name，上级目录不存在时一并创建
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDirCid(t *testing.T) {
+	tests := []struct {
+		name      string
+		videoInfo string // 为空时不创建videoInfo.json
+		want      string
+	}{
+		{"数字cid", `{"cid":123456}`, "123456"},
+		{"字符串cid", `{"cid":"654321"}`, "654321"},
+		{"page_data中的cid", `{"page_data":{"cid":777}}`, "777"},
+		{"没有cid时使用目录名", `{"title":"x"}`, "100"},
+		{"没有videoInfo时使用目录名", "", "100"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "100")
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if tt.videoInfo != "" {
+				writeFile(t, dir, "videoInfo.json", tt.videoInfo)
+			}
+			if got := dirCid(dir); got != tt.want {
+				t.Errorf("dirCid() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseVideoInfo(t *testing.T) {
+	const info = `{"title":"蛇的工作原理","uname":"珂姬与科技"}`
+	gbk, err := simplifiedchinese.GBK.NewEncoder().Bytes([]byte(info))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr bool
+	}{
+		{"UTF-8", []byte(info), false},
+		{"UTF-8 BOM", append(append([]byte{}, utf8BOM...), info...), false},
+		{"GBK", gbk, false},
+		{"不是json", []byte("not json"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 与读取缓存目录时相同，通过readVideoInfo从文件读取
+			dir := t.TempDir()
+			writeFile(t, dir, "videoInfo.json", string(tt.data))
+			js, err := readVideoInfo(dir)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readVideoInfo() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := mustString(js.Get("title")); got != "蛇的工作原理" {
+				t.Errorf("title = %q", got)
+			}
+			if got := mustString(js.Get("uname")); got != "珂姬与科技" {
+				t.Errorf("uname = %q", got)
+			}
+		})
+	}
+}
+
+func TestPageDanmakuUrl(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "100")
+	writeFile(t, root, "videoInfo.json", `{"title":"合集","pages":[{"page":1,"cid":1001,"part":"第一集"},{"page":2,"cid":"1002","part":"第二集"}]}`)
+	tests := []struct {
+		name string
+		dir  string // 分P目录名
+		want string
+	}{
+		{"目录名为cid", "1002", "https://comment.bilibili.com/1002.xml"},
+		{"目录名为分P序号", "1", "https://comment.bilibili.com/1001.xml"},
+		{"字符串cid按序号匹配", "2", "https://comment.bilibili.com/1002.xml"},
+		{"手机客户端的c_前缀", "c_1001", "https://comment.bilibili.com/1001.xml"},
+		{"找不到时使用目录名", "9", "https://comment.bilibili.com/9.xml"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := filepath.Join(root, tt.dir)
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if got := joinUrl(DefaultDanmakuBase, dirCid(dir)); got != tt.want {
+				t.Errorf("joinUrl() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJoinUrl(t *testing.T) {
+	tests := []struct {
+		base string
+		want string
+	}{
+		{"https://comment.bilibili.com", "https://comment.bilibili.com/123.xml"},
+		{"https://comment.bilibili.com/", "https://comment.bilibili.com/123.xml"},
+		{"http://127.0.0.1:8080/dm", "http://127.0.0.1:8080/dm/123.xml"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.base, func(t *testing.T) {
+			if got := joinUrl(tt.base, "123"); got != tt.want {
+				t.Errorf("joinUrl(%q) = %q, want %q", tt.base, got, tt.want)
+			}
+		})
+	}
+}
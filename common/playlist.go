@@ -0,0 +1,95 @@
+package common
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// isPlaylist 判断文件名是否为m3u或m3u8播放列表
+func isPlaylist(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	return ext == ".m3u" || ext == ".m3u8"
+}
+
+// writePlaylists 按-playlist在每个输出根目录中生成播放列表，没有合成成功的文件时不生成，dry-run时不生成
+func (c *Config) writePlaylists(results []result) {
+	if c.Playlist == "" || c.DryRun {
+		return
+	}
+	byRoot := make(map[string][]FileResult)
+	for _, r := range results {
+		for _, f := range r.files {
+			if f.Success && r.outputDir != "" {
+				byRoot[r.outputDir] = append(byRoot[r.outputDir], f)
+			}
+		}
+	}
+	for root, files := range byRoot {
+		path := filepath.Join(root, c.Playlist)
+		if err := writePlaylist(path, root, files); err != nil {
+			logrus.Warn("写入播放列表失败:", err)
+			continue
+		}
+		logrus.Info("已生成播放列表:", path)
+	}
+}
+
+// writePlaylist 将files按视频组名称、视频名称和分P排序后写入播放列表，路径为相对root的路径，编码为UTF-8
+func writePlaylist(path, root string, files []FileResult) error {
+	sort.SliceStable(files, func(i, j int) bool {
+		a, b := files[i], files[j]
+		if a.Group != b.Group {
+			return a.Group < b.Group
+		}
+		if a.Title != b.Title {
+			return a.Title < b.Title
+		}
+		return a.Page < b.Page
+	})
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	for _, f := range files {
+		rel, err := filepath.Rel(root, f.Output)
+		if err != nil {
+			rel = f.Output
+		}
+		if strings.HasPrefix(rel, "#") {
+			rel = "." + string(filepath.Separator) + rel // 以#开头的行会被当作注释
+		}
+		fmt.Fprintf(&b, "#EXTINF:%d,%s\n%s\n", playlistDuration(f), playlistName(f), rel)
+	}
+	return os.WriteFile(path, []byte(b.String()), 0644)
+}
+
+// playlistDuration 返回播放列表中的时长，单位秒，优先使用ffprobe读取的时长，都没有时为-1
+func playlistDuration(f FileResult) int {
+	if f.Info != nil && f.Info.Duration > 0 {
+		return int(math.Round(f.Info.Duration))
+	}
+	if d := GetDuration(f.Dir); d > 0 {
+		return int(math.Round(d.Seconds()))
+	}
+	return -1
+}
+
+// playlistName 返回播放列表中显示的名称，为"视频名称 - 用户名"，分P视频加上分P序号
+func playlistName(f FileResult) string {
+	name := f.Title
+	if name == "" {
+		name = strings.TrimSuffix(filepath.Base(f.Output), filepath.Ext(f.Output))
+	}
+	if f.Page > 0 {
+		name += fmt.Sprintf(" P%d", f.Page)
+	}
+	if f.Uname != "" {
+		name += " - " + f.Uname
+	}
+	// 名称中的换行会打断播放列表的格式
+	return strings.Join(strings.Fields(name), " ")
+}
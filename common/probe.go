@@ -0,0 +1,148 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// probeTimeout 执行ffprobe的最长时间
+const probeTimeout = 30 * time.Second
+
+// ProbeInfo ffprobe读取的输出文件信息
+type ProbeInfo struct {
+	Duration float64 `json:"duration"` // 时长，单位秒
+	Width    int     `json:"width"`    // 视频宽度，只有音频时为0
+	Height   int     `json:"height"`   // 视频高度
+	Size     int64   `json:"size"`     // 文件大小，单位字节
+	BitRate  int64   `json:"bitRate"`  // 总码率，单位bit/s
+	Video    string  `json:"video"`    // 视频编码，如h264、hevc
+	Audio    string  `json:"audio"`    // 音频编码，如aac
+}
+
+// String 返回时长、分辨率、编码、大小和码率，用于打印运行结果
+func (p ProbeInfo) String() string {
+	d := time.Duration(p.Duration * float64(time.Second)).Round(time.Second)
+	parts := []string{"时长 " + d.String()}
+	if p.Width > 0 && p.Height > 0 {
+		parts = append(parts, fmt.Sprintf("%dx%d", p.Width, p.Height))
+	}
+	if codecs := strings.Trim(p.Video+"/"+p.Audio, "/"); codecs != "" {
+		parts = append(parts, codecs)
+	}
+	parts = append(parts, fmt.Sprintf("%.1fMB", float64(p.Size)/1024/1024))
+	if p.BitRate > 0 {
+		parts = append(parts, fmt.Sprintf("%dkbps", p.BitRate/1000))
+	}
+	return strings.Join(parts, " ")
+}
+
+// probeOutput ffprobe -of json的输出中用到的字段
+type probeOutput struct {
+	Format struct {
+		Duration string `json:"duration"`
+		Size     string `json:"size"`
+		BitRate  string `json:"bit_rate"`
+	} `json:"format"`
+	Streams []struct {
+		CodecType   string `json:"codec_type"`
+		CodecName   string `json:"codec_name"`
+		Width       int    `json:"width"`
+		Height      int    `json:"height"`
+		Disposition struct {
+			AttachedPic int `json:"attached_pic"`
+		} `json:"disposition"`
+	} `json:"streams"`
+}
+
+// findFFprobe 查找ffprobe，优先使用ffmpeg所在目录中的ffprobe，其次从PATH中查找，找不到时返回空字符串
+func findFFprobe(ffmpegPath string) string {
+	name := "ffprobe" + filepath.Ext(ffmpegPath) // windows下为ffprobe.exe
+	if ffmpegPath != "" {
+		if path := filepath.Join(filepath.Dir(ffmpegPath), name); Exist(path) {
+			return path
+		}
+	}
+	if path, err := exec.LookPath(name); err == nil {
+		return path
+	}
+	return ""
+}
+
+// probeCache 按文件路径缓存ffprobe读取的信息，文件大小或修改时间变化后重新读取
+type probeCache struct {
+	mu    sync.Mutex
+	infos map[string]probeEntry
+}
+
+type probeEntry struct {
+	size  int64
+	mtime time.Time
+	info  ProbeInfo
+}
+
+// Probe 使用ffprobe读取文件的时长、分辨率、大小、码率和编码，同一文件未变化时只执行一次ffprobe
+func (c *Config) Probe(ctx context.Context, file string) (*ProbeInfo, error) {
+	if c.FFProbePath == "" && c.ProbeRunner == nil {
+		return nil, errors.New("找不到ffprobe")
+	}
+	st, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+	if c.probes != nil {
+		c.probes.mu.Lock()
+		e, ok := c.probes.infos[file]
+		c.probes.mu.Unlock()
+		if ok && e.size == st.Size() && e.mtime.Equal(st.ModTime()) {
+			info := e.info
+			return &info, nil
+		}
+	}
+	info, err := c.probe(ctx, file)
+	if err == nil && c.probes != nil {
+		c.probes.mu.Lock()
+		c.probes.infos[file] = probeEntry{size: st.Size(), mtime: st.ModTime(), info: *info}
+		c.probes.mu.Unlock()
+	}
+	return info, err
+}
+
+// probe 执行ffprobe读取文件信息
+func (c *Config) probe(ctx context.Context, file string) (*ProbeInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	out, stderr, err := runOutput(ctx, c.probeRunner(),
+		[]string{"-v", "error", "-show_format", "-show_streams", "-of", "json", file})
+	if err != nil {
+		if msg := strings.TrimSpace(string(stderr)); msg != "" {
+			return nil, fmt.Errorf("ffprobe执行失败: %w: %s", err, msg)
+		}
+		return nil, fmt.Errorf("ffprobe执行失败: %w", err)
+	}
+	var po probeOutput
+	if err = json.Unmarshal(out, &po); err != nil {
+		return nil, fmt.Errorf("无法解析ffprobe输出: %w", err)
+	}
+	info := &ProbeInfo{}
+	info.Duration, _ = strconv.ParseFloat(po.Format.Duration, 64)
+	info.Size, _ = strconv.ParseInt(po.Format.Size, 10, 64)
+	info.BitRate, _ = strconv.ParseInt(po.Format.BitRate, 10, 64)
+	for _, s := range po.Streams {
+		switch {
+		case s.CodecType == "video" && s.Disposition.AttachedPic == 0 && info.Video == "": // 跳过封面图片
+			info.Width, info.Height, info.Video = s.Width, s.Height, s.CodecName
+		case s.CodecType == "audio" && info.Audio == "":
+			info.Audio = s.CodecName
+		}
+	}
+	return info, nil
+}
@@ -0,0 +1,101 @@
+package common
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const probeJson = `{"format":{"duration":"12.5","size":"100","bit_rate":"800"},
+"streams":[{"codec_type":"video","codec_name":"h264","width":1920,"height":1080},{"codec_type":"audio","codec_name":"aac"}]}`
+
+func TestProbeCachesPerFile(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.mp4")
+	b := filepath.Join(dir, "b.mp4")
+	for _, f := range []string{a, b} {
+		if err := os.WriteFile(f, []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := &fakeRunner{stdout: probeJson}
+	c := &Config{FFProbePath: "ffprobe", ProbeRunner: r, probes: &probeCache{infos: make(map[string]probeEntry)}}
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		prepare func()
+		file    string
+		calls   int
+	}{
+		{"首次读取", nil, a, 1},
+		{"未变化时使用缓存", nil, a, 1},
+		{"其它文件单独读取", nil, b, 1},
+		{"修改时间变化后重新读取", func() {
+			later := time.Now().Add(time.Hour)
+			_ = os.Chtimes(a, later, later)
+		}, a, 2},
+		{"大小变化后重新读取", func() { _ = os.WriteFile(b, []byte("longer data"), 0644) }, b, 2},
+		{"重新读取后再次使用缓存", nil, b, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.prepare != nil {
+				tt.prepare()
+			}
+			info, err := c.Probe(ctx, tt.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Duration != 12.5 || info.Width != 1920 || info.Video != "h264" || info.Audio != "aac" {
+				t.Errorf("Probe() = %+v", info)
+			}
+			if got := r.calls(tt.file); got != tt.calls {
+				t.Errorf("ffprobe执行了%d次，应为%d次", got, tt.calls)
+			}
+		})
+	}
+}
+
+func TestProbeRunnerOnly(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "a.mp4")
+	if err := os.WriteFile(file, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// 设置了ProbeRunner时不需要FFProbePath
+	c := &Config{ProbeRunner: &fakeRunner{stdout: probeJson}}
+	info, err := c.Probe(context.Background(), file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Duration != 12.5 {
+		t.Errorf("Probe() = %+v", info)
+	}
+}
+
+func TestProbeErrors(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "a.mp4")
+	if err := os.WriteFile(file, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		c    *Config
+		file string
+	}{
+		{"没有ffprobe", &Config{}, file},
+		{"文件不存在", &Config{FFProbePath: "ffprobe", ProbeRunner: &fakeRunner{}}, filepath.Join(dir, "none.mp4")},
+		{"输出无法解析", &Config{FFProbePath: "ffprobe", ProbeRunner: &fakeRunner{stdout: "not json"}}, file},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.c.Probe(context.Background(), tt.file); err == nil {
+				t.Error("Probe() 应返回错误")
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"m4s-converter/conver"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProgressEvent的阶段
+const (
+	PhaseConvert  = "convert"  // 去掉m4s文件头，转换为音视频文件
+	PhaseDownload = "download" // 下载弹幕
+	PhaseCompose  = "compose"  // 执行ffmpeg合成
+)
+
+// ProgressEvent 通过Config.ProgressFunc通知的进度，用于嵌入到图形界面等程序中
+type ProgressEvent struct {
+	File    string  // 当前处理的文件
+	Phase   string  // 阶段，PhaseConvert、PhaseDownload或PhaseCompose
+	Percent float64 // 进度，0-100，convert为全部m4s文件的总进度，compose为当前文件的进度
+	Err     error   // 出错时不为空，此时该文件已结束
+}
+
+// emit 调用ProgressFunc通知进度，未设置时不做任何事
+func (c *Config) emit(ev ProgressEvent) {
+	if c.ProgressFunc != nil {
+		c.ProgressFunc(ev)
+	}
+}
+
+var timeRegexp = regexp.MustCompile(`time=(\d+):(\d+):(\d+(?:\.\d+)?)`)
+
+// GetDuration 从.playurl文件中读取视频总时长，读取失败时返回0
+func GetDuration(dir string) time.Duration {
+	puDate, err := os.ReadFile(filepath.Join(dir, conver.PlayUrlSuffix))
+	if err != nil {
+		return 0
+	}
+	var p conver.PlayUrl
+	if err = json.Unmarshal(puDate, &p); err != nil {
+		return 0
+	}
+	if p.Data.Timelength > 0 {
+		return time.Duration(p.Data.Timelength) * time.Millisecond
+	}
+	return time.Duration(p.Data.Dash.Duration) * time.Second
+}
+
+// parseTime 解析ffmpeg统计信息中的 time=HH:MM:SS.xx
+func parseTime(line string) (time.Duration, bool) {
+	m := timeRegexp.FindStringSubmatch(line)
+	if m == nil {
+		return 0, false
+	}
+	h, _ := strconv.Atoi(m[1])
+	minute, _ := strconv.Atoi(m[2])
+	sec, _ := strconv.ParseFloat(m[3], 64)
+	return time.Duration(h)*time.Hour + time.Duration(minute)*time.Minute +
+		time.Duration(sec*float64(time.Second)), true
+}
+
+// progressPercent 返回current占total的百分比，最大为100
+func progressPercent(current, total time.Duration) float64 {
+	percent := float64(current) * 100 / float64(total)
+	if percent > 100 {
+		percent = 100
+	}
+	return percent
+}
+
+// printProgress 打印单个文件的进度条
+func printProgress(name string, current, total time.Duration) {
+	percent := int(progressPercent(current, total))
+	const width = 30
+	done := width * percent / 100
+	fmt.Printf("\r%s [%s%s] %3d%%", name, strings.Repeat("=", done), strings.Repeat(" ", width-done), percent)
+}
+
+// scanLines 同时以\r和\n分割，ffmpeg的统计信息使用\r刷新同一行
+func scanLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
@@ -0,0 +1,84 @@
+package common
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// RunReport 本次运行的结果报告，通过-report写入json文件
+type RunReport struct {
+	OutputDir   string       `json:"outputDir"`      // 合成文件所在的目录
+	Composed    []string     `json:"composed"`       // 合成成功的文件
+	Done        []string     `json:"done"`           // 已合成过且完整，本次跳过的文件
+	Skipped     []SkippedDir `json:"skipped"`        // 跳过的目录
+	Files       []FileResult `json:"files"`          // 每个输出文件的合成结果
+	Elapsed     int64        `json:"elapsedSeconds"` // 耗时，单位秒
+	Interrupted bool         `json:"interrupted"`    // 是否被Ctrl+C中断
+	DiskFull    bool         `json:"diskFull"`       // 是否因磁盘空间不足停止合成
+	Fallback    []string     `json:"fallback"`       // -fallback-copy时因ffmpeg不支持而改为直接复制的功能
+	Read        int64        `json:"readBytes"`      // 合成成功的文件读取的音视频文件总大小，单位字节
+	Written     int64        `json:"writtenBytes"`   // 合成成功的文件总大小，单位字节
+}
+
+// 不算失败的跳过原因
+const (
+	SkipUnchanged = "未变化"     // 缓存目录与上次合成成功时相比未变化
+	SkipFiltered  = "不匹配筛选条件" // 不匹配-match等筛选条件
+)
+
+// 程序的退出码，便于脚本判断运行结果
+const (
+	ExitOK      = 0 // 全部合成成功，或没有需要合成的视频
+	ExitFatal   = 1 // 找不到ffmpeg或缓存路径等错误，未能开始合成
+	ExitPartial = 2 // 部分目录被跳过或合成失败，或被中断
+)
+
+// ExitCode 根据本次运行的结果返回退出码，未变化和不匹配筛选条件的目录不算跳过
+func (r *RunReport) ExitCode() int {
+	if r.Interrupted || r.DiskFull {
+		return ExitPartial
+	}
+	for _, s := range r.Skipped {
+		if s.Reason != SkipUnchanged && s.Reason != SkipFiltered {
+			return ExitPartial
+		}
+	}
+	for _, f := range r.Files {
+		if !f.Success {
+			return ExitPartial
+		}
+	}
+	return ExitOK
+}
+
+// SkippedDir 跳过的目录及原因
+type SkippedDir struct {
+	Dir    string `json:"dir"`
+	Reason string `json:"reason"`
+	File   string `json:"file,omitempty"` // 导致跳过的文件，如损坏的m4s
+}
+
+// FileResult 单个输出文件的合成结果
+type FileResult struct {
+	Dir     string     `json:"dir"`               // 缓存目录
+	Output  string     `json:"output"`            // 输出文件
+	Success bool       `json:"success"`           // 是否合成成功
+	Done    bool       `json:"done,omitempty"`    // 输出文件已存在且完整，未重新合成
+	Error   string     `json:"error,omitempty"`   // 失败原因
+	Warning string     `json:"warning,omitempty"` // 合成成功但不完整的原因，如弹幕下载失败
+	Info    *ProbeInfo `json:"info,omitempty"`    // ffprobe读取的输出文件信息，没有ffprobe时为空
+	Title   string     `json:"title"`             // videoInfo中的视频名称
+	Uname   string     `json:"uname"`             // 上传的用户名
+	Group   string     `json:"groupTitle"`        // 视频组名称
+	Page    int        `json:"page,omitempty"`    // 分P序号，单P视频为0
+	Desync  float64    `json:"desync,omitempty"`  // 合成前音视频时长相差的秒数，未超过阈值时为0
+}
+
+// Write 将报告写入json文件
+func (r *RunReport) Write(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
@@ -0,0 +1,41 @@
+package common
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunReportExitCode(t *testing.T) {
+	ok := FileResult{Dir: "1", Output: "a.mp4", Success: true}
+	tests := []struct {
+		name   string
+		report RunReport
+		want   int
+	}{
+		{"全部成功", RunReport{Files: []FileResult{ok, ok}}, ExitOK},
+		{"没有需要合成的视频", RunReport{}, ExitOK},
+		{"未变化和不匹配筛选条件不算跳过", RunReport{Files: []FileResult{ok},
+			Skipped: []SkippedDir{{Dir: "2", Reason: SkipUnchanged}, {Dir: "3", Reason: SkipFiltered}}}, ExitOK},
+		{"部分合成失败", RunReport{Files: []FileResult{ok, {Dir: "2", Output: "b.mp4", Error: "ffmpeg执行失败"}}}, ExitPartial},
+		{"全部合成失败", RunReport{Files: []FileResult{{Dir: "2", Output: "b.mp4", Error: "ffmpeg执行失败"}}}, ExitPartial},
+		{"有跳过的目录", RunReport{Files: []FileResult{ok}, Skipped: []SkippedDir{{Dir: "2", Reason: "找不到videoInfo文件"}}}, ExitPartial},
+		{"被中断", RunReport{Files: []FileResult{ok}, Interrupted: true}, ExitPartial},
+		{"磁盘已满", RunReport{DiskFull: true}, ExitPartial},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.report.ExitCode(); got != tt.want {
+				t.Errorf("ExitCode() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunFatal(t *testing.T) {
+	// 未能开始合成时Run返回错误，main以ExitFatal退出
+	c := &Config{CachePath: t.TempDir(), FFMpegPath: filepath.Join(t.TempDir(), "ffmpeg"), AssOFF: true}
+	if _, err := NewConverter(c).Run(context.Background()); err == nil {
+		t.Error("找不到ffmpeg时Run() 应返回错误")
+	}
+}
@@ -0,0 +1,101 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CacheRoots 返回本次要处理的所有缓存路径
+func (c *Config) CacheRoots() []string {
+	if len(c.CachePaths) > 0 {
+		return c.CachePaths
+	}
+	if c.CachePath != "" {
+		return []string{c.CachePath}
+	}
+	return nil
+}
+
+// checkCacheRoot 检查缓存路径存在且为bilibili缓存，合成文件的output目录等误指定的目录返回错误，name为错误中显示的路径
+func checkCacheRoot(root, name string) error {
+	fi, err := os.Stat(root)
+	if err != nil {
+		return fmt.Errorf("找不到 bilibili 的缓存目录：%w", err)
+	}
+	if !fi.IsDir() {
+		return errors.New("缓存路径不是目录：" + name)
+	}
+	if IsCacheRoot(root) {
+		return nil
+	}
+	if Exist(filepath.Join(root, StateFile)) || strings.EqualFold(filepath.Base(root), "output") {
+		return errors.New("缓存路径是合成文件的output目录，请指定它的上级目录：" + name)
+	}
+	return errors.New("缓存路径中找不到videoInfo.json、.videoInfo、.playurl或load_log文件，不是 bilibili 的缓存目录：" + name)
+}
+
+// splitPaths 拆分逗号分隔的-c参数
+func splitPaths(values []string) []string {
+	var paths []string
+	for _, v := range values {
+		for _, p := range strings.Split(v, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				paths = append(paths, p)
+			}
+		}
+	}
+	return paths
+}
+
+// dedupCachePaths 去掉重复的缓存路径和位于其它缓存路径中的子路径，只有一个路径时原样返回
+func dedupCachePaths(paths []string) []string {
+	if len(paths) == 1 {
+		return paths
+	}
+	abs := make([]string, len(paths))
+	for i, p := range paths {
+		if a, err := filepath.Abs(p); err == nil {
+			abs[i] = a
+		} else {
+			abs[i] = filepath.Clean(p)
+		}
+	}
+	var result []string
+	for i, p := range abs {
+		dup := false
+		for j, q := range abs {
+			if i == j {
+				continue
+			}
+			// 相同路径只保留第一个，子路径由上级路径一起处理
+			if p == q && j < i || p != q && inDir(q, p) {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// inDir 判断path是否位于dir中
+func inDir(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// rootOf 返回dir所在的缓存路径和序号
+func (c *Config) rootOf(dir string) (string, int) {
+	roots := c.CacheRoots()
+	for i, root := range roots {
+		if rel, err := filepath.Rel(root, dir); err == nil && !strings.HasPrefix(rel, "..") {
+			return root, i
+		}
+	}
+	return "", -1
+}
@@ -0,0 +1,71 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// Runner 执行ffmpeg或ffprobe命令，测试时可以替换为不执行真实ffmpeg的实现
+// Run启动命令并返回输出流，输出流读取完后调用wait等待命令结束，ctx取消时应结束命令
+type Runner interface {
+	Run(ctx context.Context, args []string) (stdout, stderr io.Reader, wait func() error)
+}
+
+// execRunner 默认的Runner，执行指定路径的ffmpeg或ffprobe
+type execRunner struct {
+	path string
+}
+
+func (r execRunner) Run(ctx context.Context, args []string) (io.Reader, io.Reader, func() error) {
+	cmd := exec.CommandContext(ctx, r.path, args...)
+	stdout, _ := cmd.StdoutPipe()
+	stderr, _ := cmd.StderrPipe()
+	if err := cmd.Start(); err != nil {
+		return strings.NewReader(""), strings.NewReader(""), func() error { return err }
+	}
+	return stdout, stderr, cmd.Wait
+}
+
+// runner 返回执行ffmpeg使用的Runner，未设置时执行FFMpegPath
+func (c *Config) runner() Runner {
+	if c.Runner != nil {
+		return c.Runner
+	}
+	return execRunner{path: c.FFMpegPath}
+}
+
+// probeRunner 返回执行ffprobe使用的Runner，未设置时执行FFProbePath
+func (c *Config) probeRunner() Runner {
+	if c.ProbeRunner != nil {
+		return c.ProbeRunner
+	}
+	return execRunner{path: c.FFProbePath}
+}
+
+// output 执行ffmpeg并返回全部输出，用于读取-encoders、loudnorm分析结果等
+func (c *Config) output(ctx context.Context, args ...string) (stdout, stderr []byte, err error) {
+	return runOutput(ctx, c.runner(), args)
+}
+
+// runOutput 通过r执行命令并返回全部输出
+func runOutput(ctx context.Context, r Runner, args []string) (stdout, stderr []byte, err error) {
+	outReader, errReader, wait := r.Run(ctx, args)
+	var outBuf, errBuf bytes.Buffer
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(&outBuf, outReader)
+	}()
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(&errBuf, errReader)
+	}()
+	wg.Wait()
+	err = wait()
+	return outBuf.Bytes(), errBuf.Bytes(), err
+}
@@ -0,0 +1,159 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"m4s-converter/conver"
+)
+
+// fakeRunner 不执行ffmpeg、ffprobe，记录每次的参数并返回固定的输出，
+// output为true时创建ffmpeg参数中-hide_banner之前的输出文件
+type fakeRunner struct {
+	mu     sync.Mutex
+	args   [][]string
+	stdout string
+	stderr string
+	err    error
+	output bool
+}
+
+func (r *fakeRunner) Run(_ context.Context, args []string) (io.Reader, io.Reader, func() error) {
+	r.mu.Lock()
+	r.args = append(r.args, args)
+	r.mu.Unlock()
+	if r.output {
+		for i, arg := range args {
+			if arg == "-hide_banner" && i > 0 {
+				_ = os.WriteFile(args[i-1], []byte("output"), 0644)
+			}
+		}
+	}
+	return strings.NewReader(r.stdout), strings.NewReader(r.stderr), func() error { return r.err }
+}
+
+// calls 返回最后一个参数为file的执行次数，ffprobe的最后一个参数为读取的文件
+func (r *fakeRunner) calls(file string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, args := range r.args {
+		if len(args) > 0 && args[len(args)-1] == file {
+			n++
+		}
+	}
+	return n
+}
+
+// hasArgs 判断args中是否有连续的want
+func hasArgs(args []string, want ...string) bool {
+	for i := 0; i+len(want) <= len(args); i++ {
+		if strings.Join(args[i:i+len(want)], "\x00") == strings.Join(want, "\x00") {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCompositionRunnerArgs(t *testing.T) {
+	dir := t.TempDir()
+	video := filepath.Join(dir, "1-100-video.mp4")
+	audio := filepath.Join(dir, "1-30280-audio.mp3")
+	ass := writeFile(t, dir, "1.ass", "[Script Info]")
+	output := filepath.Join(dir, "out.mp4")
+	tests := []struct {
+		name    string
+		c       Config
+		ass     string
+		want    [][]string
+		notWant [][]string
+	}{
+		{"直接复制音视频流", Config{Overlay: "-n"}, "",
+			[][]string{{"-i", video, "-i", audio}, {"-c:v", "copy"}, {"-c:a", "copy"}, {"-n", output}},
+			[][]string{{"-map", "2:s"}}},
+		{"覆盖已有文件", Config{Overlay: "-y"}, "", [][]string{{"-y", output}}, nil},
+		{"封装软字幕", Config{Overlay: "-n", EmbedAss: true}, ass,
+			[][]string{{"-i", ass}, {"-map", "2:s", "-c:s", "mov_text"}}, nil},
+		{"压制弹幕", Config{Overlay: "-n", Burn: true, HWAccel: HWAccelNone, CRF: 23}, ass,
+			[][]string{{"-filter:v:0", subtitleFilter(ass)}, {"-c:v:0", "libx264"}}, [][]string{{"-c:v", "copy"}, {"-map", "2:s"}}},
+		{"追加-ffmpeg-args", Config{Overlay: "-n", FFmpegArgs: []string{"-threads", "2"}}, "",
+			[][]string{{"-threads", "2", "-n", output}}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &fakeRunner{}
+			c := tt.c
+			c.Runner = r
+			if err := c.Composition(context.Background(), video, audio, tt.ass, "", output, map[string]string{"title": "标题"}); err != nil {
+				t.Fatal(err)
+			}
+			if len(r.args) != 1 {
+				t.Fatalf("执行了%d次ffmpeg", len(r.args))
+			}
+			args := r.args[0]
+			for _, w := range append(tt.want, []string{"-metadata", "title=标题"}) {
+				if !hasArgs(args, w...) {
+					t.Errorf("参数中缺少%q: %q", w, args)
+				}
+			}
+			for _, w := range tt.notWant {
+				if hasArgs(args, w...) {
+					t.Errorf("参数中不应有%q: %q", w, args)
+				}
+			}
+		})
+	}
+}
+
+func TestCompositionRunnerOutput(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, conver.PlayUrlSuffix, `{"data":{"timelength":10000}}`)
+	video := filepath.Join(dir, "1-100-video.mp4")
+	audio := filepath.Join(dir, "1-30280-audio.mp3")
+	output := filepath.Join(dir, "out.mp4")
+	tests := []struct {
+		name     string
+		stderr   string
+		err      error
+		percents []float64
+		wantErr  bool
+	}{
+		{"按时长通知进度", "frame=1 time=00:00:05.00 bitrate=1\nframe=2 time=00:00:10.00 bitrate=1\n", nil, []float64{0, 50, 100, 100}, false},
+		{"失败时返回错误", "Conversion failed!\n", errors.New("exit status 1"), []float64{0}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var percents []float64
+			c := &Config{Overlay: "-n", Runner: &fakeRunner{stderr: tt.stderr, err: tt.err}, ProgressFunc: func(ev ProgressEvent) {
+				mu.Lock()
+				defer mu.Unlock()
+				if ev.Err == nil {
+					percents = append(percents, ev.Percent)
+				}
+			}}
+			err := c.Composition(context.Background(), video, audio, "", "", output, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Composition() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !strings.Contains(err.Error(), "Conversion failed!") {
+				t.Errorf("错误中缺少ffmpeg的输出: %v", err)
+			}
+			if len(percents) != len(tt.percents) {
+				t.Fatalf("进度为%v，应为%v", percents, tt.percents)
+			}
+			for i := range percents {
+				if percents[i] != tt.percents[i] {
+					t.Errorf("进度为%v，应为%v", percents, tt.percents)
+					break
+				}
+			}
+		})
+	}
+}
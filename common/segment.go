@@ -0,0 +1,250 @@
+package common
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"m4s-converter/conver"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+)
+
+// segmentName 分段m4s的文件名，如 1332097557-1-30280-2.m4s，第一组为轨道名称，第二组为分段序号
+var segmentName = regexp.MustCompile(`^(.+)[-_.](\d+)$`)
+
+// m4sTrack 一个音频或视频轨道的m4s文件，分段缓存时有多个文件，按顺序合并
+type m4sTrack struct {
+	name string   // 轨道名称，用于生成音视频文件名，如 1332097557-1-30280.m4s
+	srcs []string // 按顺序排列的m4s文件
+}
+
+// segmentBox 读取m4s去掉文件头后的顶层box，返回第一个box的类型和是否有moov
+func segmentBox(src string) (first string, hasMoov bool) {
+	f, err := os.Open(src)
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+	data := make([]byte, trackProbeSize)
+	n, _ := io.ReadFull(f, data)
+	offset, ok := headerOffset(data[:n])
+	if !ok {
+		return "", false
+	}
+	mp4Box(data[offset:n], func(typ string, body []byte) bool {
+		if first == "" {
+			first = typ
+		}
+		hasMoov = hasMoov || typ == "moov"
+		return true
+	})
+	return first, hasMoov
+}
+
+// isSegmented 判断files是否为同一轨道的分段：只有第一段有moov(初始化信息)，
+// 其它段以styp、sidx或moof开头且没有moov，单独的音频和视频m4s都有moov，不会被误判
+func isSegmented(files []string) bool {
+	if len(files) < 2 {
+		return false
+	}
+	if _, moov := segmentBox(files[0]); !moov {
+		return false
+	}
+	for _, f := range files[1:] {
+		first, moov := segmentBox(f)
+		if moov || first != "styp" && first != "sidx" && first != "moof" {
+			return false
+		}
+	}
+	return true
+}
+
+// groupSegments 将同一目录下按序号分段的m4s合并为一个轨道，其它m4s各自为一个轨道
+// 分段按序号排序，与序号前的名称相同的文件（没有序号）作为第一段
+func groupSegments(files []string) []m4sTrack {
+	type segment struct {
+		src   string
+		index int
+	}
+	groups := make(map[string][]segment) // key为目录和轨道名称
+	stems := make(map[string]string)     // 去掉扩展名的文件路径 -> 文件
+	for _, f := range files {
+		stem := trimM4sExt(f)
+		stems[stem] = f
+		if m := segmentName.FindStringSubmatch(filepath.Base(stem)); m != nil {
+			index, _ := strconv.Atoi(m[2])
+			key := filepath.Join(filepath.Dir(f), m[1])
+			groups[key] = append(groups[key], segment{src: f, index: index})
+		}
+	}
+
+	// 较长的轨道名称优先，避免 a-1-30280-1.m4s 被归入 a-1 组
+	keys := make([]string, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return len(keys[i]) > len(keys[j]) })
+
+	used := make(map[string]bool)
+	var tracks []m4sTrack
+	for _, key := range keys {
+		segs := groups[key]
+		sort.Slice(segs, func(i, j int) bool { return segs[i].index < segs[j].index })
+		var srcs []string
+		if f, ok := stems[key]; ok {
+			srcs = append(srcs, f)
+		}
+		for _, s := range segs {
+			srcs = append(srcs, s.src)
+		}
+		conflict := false
+		for _, f := range srcs {
+			conflict = conflict || used[f]
+		}
+		if conflict || !isSegmented(srcs) {
+			continue
+		}
+		for _, f := range srcs {
+			used[f] = true
+		}
+		logrus.Debugf("识别为分段m4s: %s 共%d段", key, len(srcs))
+		tracks = append(tracks, m4sTrack{name: filepath.Base(key) + conver.M4sSuffix, srcs: srcs})
+	}
+	for _, f := range files {
+		if !used[f] {
+			tracks = append(tracks, m4sTrack{name: filepath.Base(f), srcs: []string{f}})
+		}
+	}
+	return tracks
+}
+
+// fragmentRange 返回m4s去掉文件头后的数据范围，并检查从头部之后到文件末尾都是完整的box，
+// 保证合并时每段的边界都是box的边界
+func fragmentRange(f *os.File) (offset, size int64, err error) {
+	st, err := f.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+	data := make([]byte, headerProbeSize)
+	n, _ := f.ReadAt(data, 0)
+	o, ok := headerOffset(data[:n])
+	if !ok {
+		return 0, 0, ErrBadM4sHeader
+	}
+	header := make([]byte, 16)
+	for pos := int64(o); pos < st.Size(); {
+		if _, err = f.ReadAt(header[:8], pos); err != nil {
+			return 0, 0, fmt.Errorf("偏移%d处的box不完整", pos)
+		}
+		boxSize := int64(binary.BigEndian.Uint32(header[:4]))
+		if boxSize == 1 {
+			if _, err = f.ReadAt(header[8:16], pos+8); err != nil {
+				return 0, 0, fmt.Errorf("偏移%d处的box不完整", pos)
+			}
+			boxSize = int64(binary.BigEndian.Uint64(header[8:16]))
+		} else if boxSize == 0 {
+			boxSize = st.Size() - pos
+		}
+		if boxSize < 8 || pos+boxSize > st.Size() {
+			return 0, 0, fmt.Errorf("偏移%d处的box %q 长度错误", pos, header[4:8])
+		}
+		pos += boxSize
+	}
+	return int64(o), st.Size() - int64(o), nil
+}
+
+// mergeSegments 依次去掉每段m4s的文件头后合并为一个音视频文件，某一段不完整时删除dst并返回错误
+func mergeSegments(srcs []string, dst string) (err error) {
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if e := out.Close(); err == nil {
+			err = e
+		}
+		if err != nil {
+			_ = os.Remove(dst)
+		}
+	}()
+	for _, src := range srcs {
+		if err = appendSegment(out, src); err != nil {
+			return fmt.Errorf("%s: %w", filepath.Base(src), err)
+		}
+	}
+	return nil
+}
+
+func appendSegment(out io.Writer, src string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	offset, size, err := fragmentRange(f)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, io.NewSectionReader(f, offset, size))
+	return err
+}
+
+// segmentsUpToDate 判断上次合并的dst是否完整且不比任何一段旧
+func segmentsUpToDate(srcs []string, dst string) bool {
+	dstInfo, err := os.Stat(dst)
+	if err != nil {
+		return false
+	}
+	var total int64
+	for _, src := range srcs {
+		if srcInfo, err := os.Stat(src); err != nil || dstInfo.ModTime().Before(srcInfo.ModTime()) {
+			return false
+		}
+		f, err := os.Open(src)
+		if err != nil {
+			return false
+		}
+		_, size, err := fragmentRange(f)
+		_ = f.Close()
+		if err != nil {
+			return false
+		}
+		total += size
+	}
+	return dstInfo.Size() == total
+}
+
+// convertSegments 将分段的m4s合并为一个音频或视频文件，按第一段判断音视频
+func (c *Config) convertSegments(t m4sTrack) error {
+	dst, err := c.m4sDstAs(t.srcs[0], t.name)
+	if err != nil {
+		return fmt.Errorf("%v 识别异常：%w", t.name, err)
+	}
+	if dst == "" { // 未选中的其它清晰度
+		logrus.Debug("跳过未选中的分段m4s:", t.name)
+		return nil
+	}
+	if c.DryRun {
+		c.plan(dst)
+		return nil
+	}
+	if c.Tmp != "" {
+		if err = os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+			return fmt.Errorf("创建临时目录失败：%w", err)
+		}
+	}
+	if c.Overlay != "-y" && segmentsUpToDate(t.srcs, dst) {
+		logrus.Debug("音视频文件已存在，跳过合并:", dst)
+		return nil
+	}
+	if err = mergeSegments(t.srcs, dst); err != nil {
+		return fmt.Errorf("%v 分段合并异常：%w", t.name, err)
+	}
+	logrus.Infof("已将%d段m4s合并为音视频文件: %s", len(t.srcs), dst)
+	return nil
+}
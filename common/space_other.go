@@ -0,0 +1,10 @@
+//go:build !windows && !linux && !darwin && !freebsd
+
+package common
+
+import "errors"
+
+// freeSpace 其它系统不支持获取剩余空间，不做空间检查
+func freeSpace(string) (int64, error) {
+	return 0, errors.New("不支持获取磁盘剩余空间")
+}
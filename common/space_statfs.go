@@ -0,0 +1,14 @@
+//go:build linux || darwin || freebsd
+
+package common
+
+import "syscall"
+
+// freeSpace 返回目录所在磁盘非root用户可用的剩余空间
+func freeSpace(dir string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+	return int64(uint64(st.Bavail) * uint64(st.Bsize)), nil
+}
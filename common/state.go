@@ -0,0 +1,142 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"m4s-converter/conver"
+)
+
+// StateFile 输出目录中记录已合成目录的状态文件
+const StateFile = ".m4s-converter-state.json"
+
+// State 记录每个缓存目录上次合成时的指纹和结果，用于重复运行时跳过未变化的目录
+type State struct {
+	mu   sync.Mutex
+	Dirs map[string]DirState `json:"dirs"`
+}
+
+// DirState 单个缓存目录的合成状态
+type DirState struct {
+	Fingerprint string   `json:"fingerprint"`       // 缓存文件的数量、总大小和最后修改时间
+	Success     bool     `json:"success"`           // 是否全部合成成功
+	Outputs     []string `json:"outputs"`           // 合成的文件
+	Danmaku     []string `json:"danmaku,omitempty"` // 本程序下载的xml弹幕，-clean时只删除这些xml，不删除客户端缓存的弹幕
+}
+
+// Load 读取状态文件，文件不存在时为空状态
+func (s *State) Load(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Dirs = make(map[string]DirState)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, s)
+}
+
+// Save 将状态写入文件
+func (s *State) Save(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// Unchanged 判断目录的指纹与上次相同，且上次合成成功、合成的文件都还在
+func (s *State) Unchanged(dir, fingerprint string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.Dirs[dir]
+	if !ok || !d.Success || d.Fingerprint != fingerprint || len(d.Outputs) == 0 {
+		return false
+	}
+	for _, f := range d.Outputs {
+		if !Exist(f) {
+			return false
+		}
+	}
+	return true
+}
+
+// Danmaku 返回上次记录的目录dir下载的xml弹幕中仍然存在的文件
+func (s *State) Danmaku(dir string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var files []string
+	for _, f := range s.Dirs[dir].Danmaku {
+		if Exist(f) {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+// DanmakuFiles 返回所有目录记录的下载的xml弹幕
+func (s *State) DanmakuFiles() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var files []string
+	for _, d := range s.Dirs {
+		files = append(files, d.Danmaku...)
+	}
+	return files
+}
+
+// Record 记录目录本次的合成结果
+func (s *State) Record(dir string, d DirState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Dirs == nil {
+		s.Dirs = make(map[string]DirState)
+	}
+	s.Dirs[dir] = d
+}
+
+// Fingerprint 根据目录中m4s、videoInfo和.playurl文件的数量、总大小和最后修改时间生成指纹
+// 不包含弹幕、封面和转换后的音视频文件，这些文件每次运行都可能重新生成
+func Fingerprint(dir string) (string, error) {
+	var count, size, mtime int64
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == "output" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		name := d.Name()
+		if !isM4s(name) && name != conver.VideoInfoJson &&
+			name != conver.VideoInfoSuffix && !strings.HasSuffix(name, conver.PlayUrlSuffix) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		count++
+		size += info.Size()
+		if t := info.ModTime().UnixNano(); t > mtime {
+			mtime = t
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d:%d:%d", count, size, mtime), nil
+}
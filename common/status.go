@@ -0,0 +1,48 @@
+package common
+
+import (
+	"fmt"
+	"m4s-converter/conver"
+
+	"github.com/bitly/go-simplejson"
+)
+
+// cacheStatus 读取videoInfo中的缓存状态，没有status时使用手机客户端的is_completed
+func cacheStatus(js *simplejson.Json) conver.CacheStatus {
+	if v, ok := js.CheckGet("status"); ok {
+		return conver.ParseStatus(v.Interface())
+	}
+	if v, ok := js.CheckGet("is_completed"); ok {
+		return conver.ParseStatus(v.Interface())
+	}
+	return conver.StatusUnknown
+}
+
+// cacheProgress 读取手机客户端记录的已缓存字节数，返回缓存进度的百分比，没有时返回-1
+func cacheProgress(js *simplejson.Json) int {
+	done, err1 := js.Get("downloaded_bytes").Int64()
+	total, err2 := js.Get("total_bytes").Int64()
+	if err1 != nil || err2 != nil || total <= 0 {
+		return -1
+	}
+	return int(done * 100 / total)
+}
+
+// statusReason 返回缓存状态不满足-min-status时跳过的原因，有缓存进度时带上百分比
+func statusReason(js *simplejson.Json, st conver.CacheStatus) string {
+	var reason string
+	switch st {
+	case conver.StatusPending:
+		reason = "等待缓存"
+	case conver.StatusDownloading:
+		reason = "正在缓存"
+	case conver.StatusStopped:
+		reason = "已暂停缓存"
+	default:
+		return fmt.Sprintf("缓存状态未知: %v", js.Get("status").Interface())
+	}
+	if p := cacheProgress(js); p >= 0 {
+		reason += fmt.Sprintf(": %d%%", p)
+	}
+	return reason
+}
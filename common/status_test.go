@@ -0,0 +1,79 @@
+package common
+
+import (
+	"context"
+	"testing"
+
+	"m4s-converter/conver"
+)
+
+func TestCacheStatus(t *testing.T) {
+	tests := []struct {
+		name      string
+		videoInfo string
+		want      conver.CacheStatus
+		reason    string
+	}{
+		{"字符串", `{"status":"completed"}`, conver.StatusCompleted, ""},
+		{"数字", `{"status":1}`, conver.StatusDownloading, "正在缓存"},
+		{"字符串形式的数字", `{"status":"2"}`, conver.StatusStopped, "已暂停缓存"},
+		{"带缓存进度", `{"status":"downloading","downloaded_bytes":63,"total_bytes":100}`, conver.StatusDownloading, "正在缓存: 63%"},
+		{"手机客户端的is_completed", `{"is_completed":false,"downloaded_bytes":1,"total_bytes":4}`, conver.StatusDownloading, "正在缓存: 25%"},
+		{"等待缓存", `{"status":"pending"}`, conver.StatusPending, "等待缓存"},
+		{"无法识别", `{"status":"deleted"}`, conver.StatusUnknown, "缓存状态未知: deleted"},
+		{"没有status", `{}`, conver.StatusUnknown, "缓存状态未知: <nil>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			js, err := parseVideoInfo([]byte(tt.videoInfo))
+			if err != nil {
+				t.Fatal(err)
+			}
+			st := cacheStatus(js)
+			if st != tt.want {
+				t.Errorf("cacheStatus() = %v, want %v", st, tt.want)
+			}
+			if st == conver.StatusCompleted {
+				return
+			}
+			if got := statusReason(js, st); got != tt.reason {
+				t.Errorf("statusReason() = %q, want %q", got, tt.reason)
+			}
+		})
+	}
+}
+
+func TestMinStatus(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    string
+		minStatus conver.CacheStatus
+		force     bool
+		skipped   string
+	}{
+		{"默认跳过已暂停的缓存", "stopped", conver.StatusCompleted, false, "已暂停缓存"},
+		{"-min-status stopped", "stopped", conver.StatusStopped, false, ""},
+		{"-min-status stopped仍跳过正在缓存的", "downloading", conver.StatusStopped, false, "正在缓存"},
+		{"-force", "downloading", conver.StatusCompleted, true, ""},
+		{"缓存完成", "completed", conver.StatusCompleted, false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFile(t, dir, "videoInfo.json", `{"title":"蛇的工作原理","uname":"珂姬与科技","status":"`+tt.status+`"}`)
+			writeFile(t, dir, "1-100-video.mp4", "video")
+			writeFile(t, dir, "1-30280-audio.mp3", "audio")
+			c := &Config{MinStatus: tt.minStatus, Force: tt.force, AssOFF: true, DryRun: true, Layout: LayoutGroupUname}
+			plan, r := prepareDir(context.Background(), c, 1, dir)
+			if r.skipped != tt.skipped {
+				t.Errorf("跳过的原因为%q，应为%q", r.skipped, tt.skipped)
+			}
+			if (plan == nil) != (tt.skipped != "") {
+				t.Errorf("prepareDir() plan = %v", plan)
+			}
+			if plan != nil && plan.forced != (tt.force && tt.status != "completed") {
+				t.Errorf("forced = %v", plan.forced)
+			}
+		})
+	}
+}
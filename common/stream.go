@@ -0,0 +1,86 @@
+package common
+
+import (
+	"fmt"
+	"m4s-converter/conver"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// cachedStreams 返回在目录中有对应m4s文件的流，m4s文件名以"-<id>.m4s"结尾，扩展名不区分大小写
+func cachedStreams(dir string, streams []conver.DashStream) []conver.DashStream {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var cached []conver.DashStream
+	for _, s := range streams {
+		suffix := "-" + strconv.Itoa(s.ID)
+		for _, e := range entries {
+			if !e.IsDir() && isM4s(e.Name()) && strings.HasSuffix(trimM4sExt(e.Name()), suffix) {
+				cached = append(cached, s)
+				break
+			}
+		}
+	}
+	return cached
+}
+
+// better 比较两个流的质量，先比较分辨率，再比较ID和码率
+func better(a, b conver.DashStream) bool {
+	if a.Height != b.Height {
+		return a.Height > b.Height
+	}
+	if a.ID != b.ID {
+		return a.ID > b.ID
+	}
+	return a.Bandwidth > b.Bandwidth
+}
+
+// selectStream 按清晰度从已缓存的流中选择一个
+// quality: 空或max为最高清晰度，min为最低清晰度，1080p等为指定分辨率高度
+func selectStream(streams []conver.DashStream, quality string) (conver.DashStream, error) {
+	if len(streams) == 0 {
+		return conver.DashStream{}, fmt.Errorf("没有已缓存的音视频流")
+	}
+	quality = strings.ToLower(strings.TrimSpace(quality))
+	switch quality {
+	case "", "max":
+		best := streams[0]
+		for _, s := range streams[1:] {
+			if better(s, best) {
+				best = s
+			}
+		}
+		return best, nil
+	case "min":
+		worst := streams[0]
+		for _, s := range streams[1:] {
+			if better(worst, s) {
+				worst = s
+			}
+		}
+		return worst, nil
+	}
+	height, err := strconv.Atoi(strings.TrimSuffix(quality, "p"))
+	if err != nil {
+		return conver.DashStream{}, fmt.Errorf("不支持的清晰度: %s", quality)
+	}
+	for _, s := range streams {
+		if s.Height == height {
+			return s, nil
+		}
+	}
+	return conver.DashStream{}, fmt.Errorf("没有已缓存的%s视频流", quality)
+}
+
+// selectCached 从目录已缓存的流中按清晰度选择
+func selectCached(dir string, streams []conver.DashStream, quality string) (string, error) {
+	s, err := selectStream(cachedStreams(filepath.Clean(dir), streams), quality)
+	if err != nil {
+		return "", err
+	}
+	return strconv.Itoa(s.ID), nil
+}
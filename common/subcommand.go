@@ -0,0 +1,93 @@
+package common
+
+import (
+	"flag"
+	"fmt"
+)
+
+// 子命令，命令行第一个参数为子命令名称时只接受该子命令相关的参数
+// convert与不指定子命令时相同，接受所有参数，兼容原有的用法和双击运行
+const (
+	CmdConvert      = "convert"       // 合成视频
+	CmdList         = "list"          // 同-list
+	CmdClean        = "clean"         // 同-clean-only
+	CmdCheck        = "check"         // 同-check
+	CmdExtractAudio = "extract-audio" // 同-mp3，-audio-format指定格式
+)
+
+// subcommand 子命令及其接受的参数
+type subcommand struct {
+	name  string
+	usage string
+	flags [][]string // 接受的参数，为nil时接受所有参数
+}
+
+// 各子命令共用的参数分组
+var (
+	// baseFlags ffmpeg、日志和交互相关的参数，所有子命令都接受
+	baseFlags = []string{"f", "ffmpeg-sha256", "no-embed-ffmpeg", "log", "log-max-mb", "log-backups",
+		"quiet", "verbose", "no-wait", "no-gui", "v"}
+	// cacheFlags 查找缓存目录的参数
+	cacheFlags = []string{"c", "depth", "include", "exclude", "m4s-ext", "tmp", "out", "readonly-cache", "keep-temp"}
+	// outputFlags 合成或提取音频时选择视频、命名和处理输出文件的参数
+	outputFlags = []string{"o", "j", "progress", "timeout", "cid", "bvid", "match", "match-exclude", "match-field",
+		"min-status", "force", "refresh", "template", "layout", "ascii-safe", "output-suffix", "on-collision",
+		"overwrite-if-better", "preserve-mtime", "dry-run", "report", "playlist", "exec", "exec-timeout",
+		"clean", "y", "fallback-copy"}
+	// audioFlags 提取音频的参数
+	audioFlags = []string{"audio-format", "loudnorm", "loudnorm-2pass"}
+)
+
+var subcommands = []subcommand{
+	{CmdConvert, "合成缓存目录中的音视频和弹幕，接受所有参数，不指定子命令时相同", nil},
+	{CmdList, "只列出缓存目录的视频和文件是否齐全，不合成", [][]string{baseFlags, cacheFlags}},
+	{CmdClean, "不合成，只清理已合成成功且缓存未变化的目录中的中间文件", [][]string{baseFlags, cacheFlags, {"y"}}},
+	{CmdCheck, "只检查ffmpeg能否运行及参数需要的编码器和滤镜", [][]string{baseFlags,
+		{"a", "burn", "embed-sub", "embed-ass", "mp3", "format", "hwaccel", "dm-format", "fallback-copy"}, audioFlags}},
+	{CmdExtractAudio, "只提取音频，默认为mp3，不下载弹幕", [][]string{baseFlags, cacheFlags, outputFlags, audioFlags}},
+}
+
+// parseArgs 解析命令行参数args（不含程序名），返回子命令名称，未指定子命令时返回空并按all解析所有参数
+// 指定子命令时只将all中该子命令接受的参数复制到新的FlagSet中解析，参数的值仍写入all中定义的变量
+func parseArgs(all *flag.FlagSet, args []string) (string, error) {
+	all.Usage = func() {
+		out := all.Output()
+		_, _ = fmt.Fprintf(out, "用法: %s [子命令] [参数]\n\n子命令:\n", all.Name())
+		for _, sc := range subcommands {
+			_, _ = fmt.Fprintf(out, "  %-14s%s\n", sc.name, sc.usage)
+		}
+		_, _ = fmt.Fprintf(out, "\n不指定子命令时与%s相同，并接受以下所有参数，%s <子命令> -h 查看子命令的参数\n\n参数:\n", CmdConvert, all.Name())
+		all.PrintDefaults()
+	}
+	if len(args) == 0 {
+		return "", all.Parse(args)
+	}
+	for _, sc := range subcommands {
+		if args[0] != sc.name {
+			continue
+		}
+		fs := flag.NewFlagSet(all.Name()+" "+sc.name, all.ErrorHandling())
+		fs.SetOutput(all.Output())
+		if sc.flags == nil {
+			all.VisitAll(func(f *flag.Flag) { fs.Var(f.Value, f.Name, f.Usage) })
+		}
+		for _, names := range sc.flags {
+			for _, name := range names {
+				f := all.Lookup(name)
+				if f == nil {
+					return "", fmt.Errorf("子命令%s使用了未定义的参数: %s", sc.name, name)
+				}
+				if fs.Lookup(name) == nil {
+					fs.Var(f.Value, f.Name, f.Usage)
+				}
+			}
+		}
+		usage := sc.usage
+		fs.Usage = func() {
+			_, _ = fmt.Fprintf(fs.Output(), "用法: %s [参数]\n%s\n\n参数:\n", fs.Name(), usage)
+			fs.PrintDefaults()
+		}
+		return sc.name, fs.Parse(args[1:])
+	}
+	return "", all.Parse(args)
+}
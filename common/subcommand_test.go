@@ -0,0 +1,101 @@
+package common
+
+import (
+	"flag"
+	"io"
+	"testing"
+)
+
+// testFlagSet 返回定义了所有子命令参数的FlagSet，另有只在不指定子命令时接受的-list和-dm-font
+func testFlagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("m4s", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.Bool("list", false, "")
+	fs.Bool("mp3", false, "")
+	fs.String("dm-font", "", "")
+	for _, sc := range subcommands {
+		for _, names := range sc.flags {
+			for _, name := range names {
+				if fs.Lookup(name) == nil {
+					fs.String(name, "", "")
+				}
+			}
+		}
+	}
+	return fs
+}
+
+func TestParseArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		cmd     string
+		set     map[string]string
+		wantErr bool
+	}{
+		{"无参数", nil, "", nil, false},
+		{"不指定子命令", []string{"-c", "/cache", "-mp3"}, "", map[string]string{"c": "/cache", "mp3": "true"}, false},
+		{"convert接受所有参数", []string{CmdConvert, "-mp3", "-audio-format", "flac", "-dm-font", "黑体"}, CmdConvert,
+			map[string]string{"mp3": "true", "audio-format": "flac", "dm-font": "黑体"}, false},
+		{"list", []string{CmdList, "-c", "/cache"}, CmdList, map[string]string{"c": "/cache"}, false},
+		{"list不接受合成参数", []string{CmdList, "-mp3"}, CmdList, nil, true},
+		{"extract-audio", []string{CmdExtractAudio, "-audio-format", "opus"}, CmdExtractAudio,
+			map[string]string{"audio-format": "opus"}, false},
+		{"clean不接受-j", []string{CmdClean, "-j", "2"}, CmdClean, nil, true},
+		{"子命令名称作为参数值", []string{"-c", CmdList}, "", map[string]string{"c": CmdList}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := testFlagSet()
+			cmd, err := parseArgs(fs, tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseArgs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if cmd != tt.cmd {
+				t.Errorf("parseArgs() = %q, want %q", cmd, tt.cmd)
+			}
+			for name, want := range tt.set {
+				if got := fs.Lookup(name).Value.String(); got != want {
+					t.Errorf("-%s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseArgsUndefinedFlag(t *testing.T) {
+	fs := testFlagSet()
+	saved := subcommands
+	defer func() { subcommands = saved }()
+	subcommands = []subcommand{{"test", "", [][]string{{"c", "no-such-flag"}}}}
+	if _, err := parseArgs(fs, []string{"test"}); err == nil {
+		t.Error("子命令使用未定义的参数时应返回错误")
+	}
+}
+
+func TestInitConfigSubcommand(t *testing.T) {
+	tests := []struct {
+		name  string
+		args  []string
+		check func(c *Config) bool
+	}{
+		{"不指定子命令", nil, func(c *Config) bool { return !c.Mp3 && !c.List }},
+		{"extract-audio子命令", []string{CmdExtractAudio, "-audio-format", "flac"}, func(c *Config) bool {
+			return c.Mp3 && c.AssOFF && c.AudioFormat == AudioFlac
+		}},
+		{"convert子命令接受-mp3", []string{CmdConvert, "-mp3"}, func(c *Config) bool { return c.Mp3 }},
+		{"list子命令", []string{CmdList}, func(c *Config) bool { return c.List }},
+		{"clean子命令", []string{CmdClean, "-y"}, func(c *Config) bool { return c.CleanOnly && c.Clean }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := initConfig(t, tt.args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.check(c) {
+				t.Errorf("InitConfig(%q) = %+v", tt.args, c)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 音视频时长相差超过maxSyncDiff秒或较长轨道的maxSyncRatio时，视为来自不同的缓存，合成的视频会音画不同步
+const (
+	maxSyncDiff  = 1.0
+	maxSyncRatio = 0.02
+)
+
+// checkSync 用ffprobe读取合成前音频和视频的时长，相差超过阈值时返回相差的秒数和说明，
+// 没有ffprobe或读取失败时不检查，返回0
+func (c *Config) checkSync(ctx context.Context, video, audio string) (float64, string) {
+	if c.FFProbePath == "" || video == "" || audio == "" {
+		return 0, ""
+	}
+	vi, err := c.Probe(ctx, video)
+	if err != nil {
+		logrus.Debug("读取视频时长失败，不检查音视频时长: ", err)
+		return 0, ""
+	}
+	ai, err := c.Probe(ctx, audio)
+	if err != nil {
+		logrus.Debug("读取音频时长失败，不检查音视频时长: ", err)
+		return 0, ""
+	}
+	if vi.Duration <= 0 || ai.Duration <= 0 {
+		return 0, ""
+	}
+	diff := math.Abs(vi.Duration - ai.Duration)
+	if diff <= maxSyncDiff && diff <= maxSyncRatio*math.Max(vi.Duration, ai.Duration) {
+		return 0, ""
+	}
+	return diff, fmt.Sprintf("音视频时长不一致（视频%.1f秒，音频%.1f秒），可能来自不同的缓存，合成后音画不同步", vi.Duration, ai.Duration)
+}
@@ -0,0 +1,147 @@
+package common
+
+import (
+	"errors"
+	"m4s-converter/conver"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// tempDir 返回缓存目录dir对应的中间文件目录，未指定-tmp时为dir本身
+// -tmp目录下按相对缓存路径的目录结构存放，避免不同视频的同名文件冲突，
+// 有多个缓存路径时再按缓存路径的序号分开存放
+func (c *Config) tempDir(dir string) string {
+	if c.Tmp == "" {
+		return dir
+	}
+	root, i := c.rootOf(dir)
+	if i < 0 {
+		return filepath.Join(c.Tmp, filepath.Base(dir))
+	}
+	rel, _ := filepath.Rel(root, dir)
+	if len(c.CacheRoots()) > 1 {
+		rel = filepath.Join(strconv.Itoa(i), rel)
+	}
+	return filepath.Join(c.Tmp, rel)
+}
+
+// findTempFiles 在-tmp目录中查找缓存目录dir对应的音视频文件
+func (c *Config) findTempFiles(dir string, page func(string) *Page) {
+	tmp := c.tempDir(dir)
+	entries, err := os.ReadDir(tmp)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		path := filepath.Join(tmp, e.Name())
+		if strings.HasSuffix(e.Name(), conver.VideoSuffix) {
+			logrus.Debug("找到视频文件:", path)
+			page(dir).Video = path
+		}
+		if strings.HasSuffix(e.Name(), conver.AudioSuffix) {
+			logrus.Debug("找到音频文件:", path)
+			page(dir).Audio = path
+		}
+	}
+}
+
+// RemoveTemp 合成成功后删除-tmp目录中的中间文件，未指定-tmp或指定-keep-temp时保留
+func (c *Config) RemoveTemp(p Page) {
+	if c.Tmp == "" || c.KeepTemp {
+		return
+	}
+	for _, f := range []string{p.Video, p.Audio} {
+		if f == "" {
+			continue
+		}
+		if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+			logrus.Warn("删除临时文件失败:", err)
+		}
+	}
+	_ = os.Remove(c.tempDir(p.Dir)) // 目录为空时一并删除
+}
+
+// cacheDir 与tempDir相反，返回-tmp目录中的dir对应的缓存目录
+func (c *Config) cacheDir(dir string) string {
+	if c.Tmp == "" {
+		return dir
+	}
+	rel, err := filepath.Rel(c.Tmp, dir)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return dir
+	}
+	roots := c.CacheRoots()
+	if len(roots) == 1 {
+		return filepath.Join(roots[0], rel)
+	}
+	parts := strings.SplitN(rel, string(filepath.Separator), 2)
+	i, err := strconv.Atoi(parts[0])
+	if err != nil || i < 0 || i >= len(roots) || len(parts) < 2 {
+		return dir
+	}
+	return filepath.Join(roots[i], parts[1])
+}
+
+// inCache 判断path是否位于某个缓存路径中
+func (c *Config) inCache(path string) bool {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	for _, root := range c.CacheRoots() {
+		if cache, err := filepath.Abs(root); err == nil {
+			if rel, err := filepath.Rel(cache, abs); err == nil && !strings.HasPrefix(rel, "..") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// readonlyDefaults 只读缓存时检查-out和-tmp，未指定时使用当前目录下的output和output/.tmp
+func (c *Config) readonlyDefaults() error {
+	if c.Out == "" {
+		out, err := filepath.Abs("output")
+		if err != nil {
+			return err
+		}
+		c.Out = out
+		logrus.Info("缓存目录只读，合成文件写入:", c.Out)
+	}
+	if c.inCache(c.Out) {
+		return errors.New("缓存目录只读时输出目录不能位于缓存目录中：" + c.Out)
+	}
+	if c.Tmp == "" {
+		c.Tmp = filepath.Join(c.Out, ".tmp")
+		logrus.Info("缓存目录只读，中间文件写入:", c.Tmp)
+	}
+	return nil
+}
+
+// danmakuXml 返回缓存目录dir中cid的弹幕xml路径，只读缓存时为-tmp目录中的路径，
+// 缓存目录中已有的弹幕先复制到-tmp目录，转换的ass和srt也随xml写入-tmp目录
+func (c *Config) danmakuXml(dir, cid string) string {
+	xmlPath := filepath.Join(dir, cid+conver.XmlSuffix)
+	if !c.ReadonlyCache {
+		return xmlPath
+	}
+	dst := filepath.Join(c.tempDir(dir), cid+conver.XmlSuffix)
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		logrus.Warn("无法创建弹幕目录:", err)
+		return dst
+	}
+	if !c.RefreshDm && !localDanmaku(dst) && localDanmaku(xmlPath) {
+		if err := copyFile(xmlPath, dst, func(*os.File) {}); err != nil {
+			logrus.Warn("复制缓存中的弹幕失败，重新下载:", err)
+			_ = os.Remove(dst)
+		}
+	}
+	return dst
+}
@@ -0,0 +1,94 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// snapshotTree 返回root下所有文件和目录的大小与修改时间
+func snapshotTree(t *testing.T, root string) map[string]string {
+	t.Helper()
+	files := make(map[string]string)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(root, path)
+		files[filepath.ToSlash(rel)] = fmt.Sprint(info.Mode(), info.Size(), info.ModTime().UnixNano())
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestReadonlyCacheNoWrites(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?><i><d p="1.0,1,25,16777215,0,0,0,0">弹幕</d></i>`)
+	}))
+	defer srv.Close()
+	payload := append(append([]byte{}, ftypBox...), []byte("\x00\x00\x00\x08mdat")...)
+	tests := []struct {
+		name   string
+		assOFF bool
+	}{
+		{"不下载弹幕", true},
+		{"下载弹幕", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			cache := filepath.Join(root, "cache")
+			dir := filepath.Join(cache, "111")
+			writeFile(t, dir, "videoInfo.json", `{"title":"蛇的工作原理","groupTitle":"3D动画之工作原理","uname":"珂姬与科技","cid":111,"status":"completed"}`)
+			writeFile(t, dir, "111-100.m4s", "000000000"+string(payload)+strings.Repeat("v", 1000))
+			writeFile(t, dir, "111-30280.m4s", "000000000"+string(payload))
+			before := snapshotTree(t, cache)
+
+			out := filepath.Join(root, "out")
+			tmp := filepath.Join(root, "tmp")
+			r := &fakeRunner{output: true}
+			c := &Config{CachePath: cache, Out: out, Tmp: tmp, ReadonlyCache: true, AssOFF: tt.assOFF,
+				FFMpegPath: "ffmpeg", FFProbePath: "ffprobe", ProbeRunner: &fakeRunner{stdout: probeJson},
+				Runner: r, Format: FormatMkv, Overlay: "-n", Jobs: 1, Layout: LayoutGroupUname,
+				DanmakuBase: srv.URL, Client: srv.Client()}
+			report, err := NewConverter(c).Run(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(r.args) != 1 {
+				t.Fatalf("执行了%d次ffmpeg，报告为%+v", len(r.args), report)
+			}
+			after := snapshotTree(t, cache)
+			for path, st := range after {
+				if before[path] != st {
+					t.Errorf("只读缓存中的%s被写入或修改", path)
+				}
+			}
+			for path := range before {
+				if _, ok := after[path]; !ok {
+					t.Errorf("只读缓存中的%s被删除", path)
+				}
+			}
+			if !Exist(filepath.Join(out, "3D动画之工作原理-珂姬与科技", "蛇的工作原理.mkv")) {
+				t.Error("合成的文件不在-out目录中")
+			}
+			if !tt.assOFF && !Exist(filepath.Join(tmp, "111", "111.ass")) {
+				t.Error("转换的弹幕不在-tmp目录中")
+			}
+			for _, arg := range r.args[0] {
+				if strings.HasPrefix(arg, cache) {
+					t.Errorf("ffmpeg参数中的%s位于缓存目录中", arg)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package common
+
+import (
+	"bytes"
+	"github.com/sirupsen/logrus"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+)
+
+// -layout可选的输出目录结构
+const (
+	LayoutGroupUname = "group-uname" // 视频组名称-用户名/视频名称
+	LayoutUnameGroup = "uname/group" // 用户名/视频组名称/视频名称
+	LayoutFlat       = "flat"        // 所有视频直接放在输出目录中
+)
+
+// NameData 输出文件名模板中可用的字段
+type NameData struct {
+	Title      string // 视频名称
+	Uname      string // 上传的用户名
+	GroupTitle string // 视频组名称
+	Index      int    // 序号，从1开始
+	Date       string // 合成日期
+}
+
+// parseTemplate 解析-template指定的文件名模板，失败时回退为默认的命名方式
+func (c *Config) parseTemplate(text string) {
+	if text == "" {
+		return
+	}
+	t, err := template.New("name").Option("missingkey=error").Parse(text)
+	if err != nil {
+		logrus.Warn("文件名模板解析失败，使用默认命名: ", err)
+		return
+	}
+	c.Template = t
+}
+
+// GroupDir 按-layout返回合成文件所在目录，groupTitle和uname为已经过Filter的名称
+func (c *Config) GroupDir(root, groupTitle, uname string) string {
+	switch c.Layout {
+	case LayoutUnameGroup:
+		return filepath.Join(root, uname, groupTitle)
+	case LayoutFlat:
+		return root
+	}
+	return filepath.Join(root, groupTitle+"-"+uname)
+}
+
+// OutputName 返回输出文件名（不含扩展名），未设置模板或渲染失败时使用视频名称
+func (c *Config) OutputName(data NameData) string {
+	if data.Date == "" {
+		data.Date = time.Now().Format("2006-01-02")
+	}
+	if c.Template != nil {
+		var buf bytes.Buffer
+		if err := c.Template.Execute(&buf, data); err != nil {
+			logrus.Warn("文件名模板渲染失败，使用默认命名: ", err)
+		} else if name := c.filterName(buf.String()); name != "" {
+			return name
+		}
+	}
+	return data.Title
+}
+
+// withSuffix 在文件名name（不含扩展名）后加上经过Filter的-output-suffix，保留后缀开头的空格，
+// 加上后超出文件名长度限制时截断name而不截断后缀
+func (c *Config) withSuffix(name string) string {
+	trimmed := strings.TrimLeft(c.TitleSuffix, " ")
+	s := c.filterName(trimmed)
+	if s == "" {
+		return name
+	}
+	s = c.TitleSuffix[:len(c.TitleSuffix)-len(trimmed)] + s
+	for name != "" && truncateName(name+s) != name+s {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	return name + s
+}
@@ -0,0 +1,117 @@
+package common
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// planOutputs 以DryRun准备缓存目录dir，返回每个分P相对于out的输出文件
+func planOutputs(t *testing.T, c *Config, dir, out string) []string {
+	t.Helper()
+	c.Out, c.AssOFF, c.DryRun = out, true, true
+	if c.owners == nil {
+		c.owners = newOutputOwners(nil)
+	}
+	plan, r := prepareDir(context.Background(), c, 1, dir)
+	if plan == nil {
+		t.Fatalf("prepareDir() 跳过了%s: %s", dir, r.skipped)
+	}
+	c.claimOutputs(dir, plan)
+	var got []string
+	for _, file := range plan.outputs {
+		rel, _ := filepath.Rel(out, file)
+		got = append(got, filepath.ToSlash(rel))
+	}
+	return got
+}
+
+func TestLayout(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "videoInfo.json", `{"title":"蛇的工作原理?","groupTitle":"3D动画/工作原理","uname":"珂姬:科技","status":"completed"}`)
+	writeFile(t, dir, "1-100-video.mp4", "video")
+	writeFile(t, dir, "1-30280-audio.mp3", "audio")
+	tests := []struct {
+		layout string
+		want   string
+	}{
+		{LayoutGroupUname, "3D动画_工作原理-珂姬：科技/蛇的工作原理_.mp4"},
+		{LayoutUnameGroup, "珂姬：科技/3D动画_工作原理/蛇的工作原理_.mp4"},
+		{LayoutFlat, "蛇的工作原理_.mp4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.layout, func(t *testing.T) {
+			got := planOutputs(t, &Config{Layout: tt.layout}, dir, t.TempDir())
+			if len(got) != 1 || got[0] != tt.want {
+				t.Errorf("输出文件为%q，应为%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithSuffix(t *testing.T) {
+	long := strings.Repeat("中", 100)
+	tests := []struct {
+		name   string
+		suffix string
+		in     string
+		want   string
+	}{
+		{"默认不加后缀", "", "蛇的工作原理", "蛇的工作原理"},
+		{"保留开头的空格", " [danmaku]", "蛇的工作原理", "蛇的工作原理 [danmaku]"},
+		{"过滤非法字符", " <弹幕>?", "蛇的工作原理", "蛇的工作原理 《弹幕》_"},
+		{"过滤后为空时不加", " ...", "蛇的工作原理", "蛇的工作原理"},
+		{"超出长度时截断名称而不截断后缀", " [danmaku]", long, strings.Repeat("中", (maxNameBytes-10)/3) + " [danmaku]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{TitleSuffix: tt.suffix}
+			got := c.withSuffix(tt.in)
+			if got != tt.want {
+				t.Errorf("withSuffix(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+			if !utf8.ValidString(got) || len(got) > maxNameBytes {
+				t.Errorf("withSuffix(%q) 返回了无效的文件名%q", tt.in, got)
+			}
+		})
+	}
+}
+
+func TestOutputSuffix(t *testing.T) {
+	newDir := func(title string) string {
+		dir := t.TempDir()
+		writeFile(t, dir, "videoInfo.json", `{"title":"`+title+`","groupTitle":"合集","uname":"UP主","status":"completed"}`)
+		writeFile(t, dir, "1-100-video.mp4", "video")
+		writeFile(t, dir, "1-30280-audio.mp3", "audio")
+		return dir
+	}
+	first, second := newDir("蛇的工作原理"), newDir("蛇的工作原理")
+	tests := []struct {
+		name string
+		c    Config
+		dirs []string
+		want []string // 每个目录的输出文件
+	}{
+		{"后缀在扩展名之前", Config{TitleSuffix: " [danmaku]"}, []string{first},
+			[]string{"蛇的工作原理 [danmaku].mp4"}},
+		{"mkv", Config{TitleSuffix: " [danmaku]", Format: FormatMkv}, []string{first},
+			[]string{"蛇的工作原理 [danmaku].mkv"}},
+		{"重名时序号在后缀之后", Config{TitleSuffix: " [danmaku]", OnCollision: CollisionRename}, []string{first, second},
+			[]string{"蛇的工作原理 [danmaku].mp4", "蛇的工作原理 [danmaku] (2).mp4"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := t.TempDir()
+			c := tt.c
+			c.Layout = LayoutFlat
+			for i, dir := range tt.dirs {
+				got := planOutputs(t, &c, dir, out)
+				if len(got) != 1 || got[0] != tt.want[i] {
+					t.Errorf("%s的输出文件为%q，应为%q", dir, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
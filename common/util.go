@@ -1,145 +1,773 @@
 package common
 
 import (
-	"crypto/sha256"
-	"embed"
+	"bufio"
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"github.com/lxn/win"
 	"github.com/sirupsen/logrus"
-	"golang.org/x/sys/windows"
 	"io"
 	"m4s-converter/conver"
+	"net/http"
 	"os"
 	"os/exec"
 	"os/user"
 	"path/filepath"
-	"strconv"
+	"regexp"
+	"runtime"
 	"strings"
-	"syscall"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+	"unicode/utf8"
 )
 
-//go:embed ffmpeg.exe
-var ffmpegFile embed.FS
+type Config struct {
+	FFMpegPath    string
+	FFProbePath   string // ffprobe路径，为空时在ffmpeg所在目录和PATH中查找，找不到时不读取输出文件信息
+	CachePath     string
+	CachePaths    []string // -c指定了多个缓存路径时的全部路径，只有一个时与CachePath相同
+	Overlay       string
+	AssOFF        bool
+	Jobs          int
+	Progress      bool
+	Mp3           bool
+	VideoOnly     bool   // 只输出去掉文件头的视频轨道，不合成，用于排查损坏的轨道
+	AudioOnly     bool   // 只输出去掉文件头的音频轨道，不合成也不转码
+	AudioFormat   string // 提取音频的格式，mp3、wav、flac或aac
+	Template      *template.Template
+	Layout        string // 输出目录结构，group-uname、uname/group或flat
+	ASCIISafe     bool   // 输出的目录和文件名只使用ASCII字符，用于FAT32、exFAT格式的设备
+	OnCollision   string // 不同视频的输出文件名相同时的处理方式，skip、overwrite或rename，为空时-o为overwrite，否则为skip
+	StrictSync    bool   // 音视频时长相差较大时跳过合成，默认只警告，需要ffprobe
+	TitleSuffix   string // 加在输出文件名（扩展名之前）后的后缀，如" [danmaku]"，用于区分合成的文件
+	Burn          bool
+	CRF           int
+	Quality       string
+	Retry         int
+	Client        *http.Client // 下载弹幕使用的http客户端
+	DryRun        bool
+	Report        string // json报告的路径
+	Playlist      string // 在每个输出根目录中生成的播放列表文件名，为空时不生成
+	DanmakuAPI    string // 下载弹幕优先使用的接口，xml或seg
+	DanmakuBase   string // xml弹幕接口的地址，为空时为https://comment.bilibili.com
+	DanmakuFormat string // 弹幕转换的格式，ass或srt
+	RefreshDm     bool   // 缓存目录中已有xml弹幕时也重新下载
+	AssStyle      conver.AssStyle
+	Format        string         // 输出的视频格式，mp4、mkv或mov
+	EmbedAss      bool           // 将弹幕作为可选的软字幕轨道封装进视频，不再复制弹幕文件
+	Chapters      bool           // 多P视频合并为一个文件，并在每个分P处添加章节标记
+	LogLevel      string         // 日志级别
+	Tmp           string         // 存放去掉文件头的中间音视频文件的目录，为空时放在缓存目录中
+	KeepTemp      bool           // 合成后保留-tmp目录中的中间文件
+	Out           string         // 合成文件的根目录，为空时为缓存路径下的output目录
+	HWAccel       string         // 重新编码时使用的硬件加速方式
+	Depth         int            // 查找缓存目录的最大深度，0为不限制
+	Include       string         // 只合成目录名匹配该通配符的缓存目录
+	Exclude       string         // 跳过目录名匹配该通配符的目录
+	Force         bool           // 忽略videoInfo中的缓存状态，强制合成
+	FallbackCopy  bool           // ffmpeg不支持-burn等需要的编码器时改为直接复制，而不是停止运行
+	Match         *regexp.Regexp // 只合成MatchField匹配该正则的视频
+	NotMatch      *regexp.Regexp // 跳过MatchField匹配该正则的视频
+	MatchField    string         // 匹配的videoInfo字段，title、groupTitle或uname
+	FFmpegSHA256  string         // 外部ffmpeg文件的SHA-256，为空时不校验
+	NoEmbedFFmpeg bool           // 不释放内置的ffmpeg.exe，必须通过FFMpegPath指定ffmpeg
+	Loudnorm      bool           // 提取音频时统一音量
+	Loudnorm2Pass bool           // 统一音量时先分析再调整
+	Timeout       time.Duration  // 单个文件执行ffmpeg的超时时间，0为不限制
+	FFmpegArgs    []string       // 追加到合成命令中输出文件之前的ffmpeg参数
+	NoFastStart   bool           // mp4和mov不把moov移到文件开头，省去合成后重写一遍文件
+	Exec          string         // 合成成功后通过shell执行的命令，支持{file}、{title}、{uname}占位符
+	ExecTimeout   time.Duration  // 执行-exec命令的超时时间，0为不限制
+	Runner        Runner         // 执行ffmpeg的Runner，为空时执行FFMpegPath
+	ProbeRunner   Runner         // 执行ffprobe的Runner，为空时执行FFProbePath
+	Cover         bool           // 下载videoInfo中的封面并添加到视频
+	PreserveMtime string         // 合成的文件修改时间的来源，source、pubdate或ctime，为空时不修改
+	Refresh       bool           // 忽略状态文件，重新处理所有目录
+	ReadonlyCache bool           // 不向缓存目录写入任何文件，中间文件和弹幕写入-tmp目录
+	M4sExts       []string       // m4s以外额外识别为m4s的扩展名，如.blv
+	Cid           string         // 只处理videoInfo中cid相同的视频
+	Bvid          string         // 只处理videoInfo中bvid或aid相同的视频
+	Clean         bool           // 合成成功后删除缓存目录中的中间文件
+	CleanOnly     bool           // 不合成，只删除已合成过的缓存目录中的中间文件
+	Yes           bool           // 删除中间文件前不询问
+	List          bool           // -list 只列出缓存目录，不合成
+	Check         bool           // -check 只检查ffmpeg能否运行及支持的编码器，不读取缓存
+	NoWait        bool           // 结束或异常退出时不等待按回车键
+	Headless      bool           // 不弹出任何窗口，也不等待按回车键退出，用于计划任务等无桌面的环境
+	ShowVersion   bool           // -v 只打印版本号
 
-var (
-	FFmpegName    = "ffmpeg.exe"
-	FileHashValue = "3b805cb66ebb0e68f19c939bece693c345b15b7bf277b572ab7b4792ee65aad8"
-)
+	// ProgressFunc 设置后通过回调通知进度，不再在控制台打印进度条，可能在多个goroutine中同时调用
+	ProgressFunc func(ev ProgressEvent)
+	// DanmakuSources 从bilibili下载弹幕前依次尝试的弹幕来源，如DirDanmaku，用于离线或存档的弹幕
+	DanmakuSources []DanmakuSource
+	// OverwriteIfBetter 已有合成的文件时先合成到临时文件，用ffprobe比较后时长不短于且大小不明显小于已有文件时才替换，需要ffprobe
+	OverwriteIfBetter bool
+	// MinStatus 合成所需的最低缓存状态，为StatusUnknown时使用StatusCompleted，低于该状态的目录被跳过
+	MinStatus conver.CacheStatus
+	// RequireDanmaku 弹幕下载或转换失败时跳过该目录，默认合成不含弹幕的视频
+	RequireDanmaku bool
 
-type Config struct {
-	FFMpegPath string
-	CachePath  string
-	Overlay    string
-	File       *os.File
-	AssPath    string
-	AssOFF     bool
+	archives map[string]*zipCache // 解压zip缓存的临时目录对应的zip，Prepare时打开
+	probes   *probeCache          // ffprobe的结果，Prepare时创建
+	owners   *outputOwners        // 每个输出文件属于的缓存目录，Run时创建
+	planned  *plannedFiles        // -dry-run时将要生成的音视频文件，Prepare时创建
 }
 
-func (c *Config) InitConfig() {
+// InitConfig 读取配置文件和命令行参数，参数错误时返回错误
+// 只解析参数，ffmpeg和缓存路径在Prepare中检查
+func (c *Config) InitConfig() error {
 	InitLog()
-	overlay := flag.Bool("o", false, "是否覆盖已存在的视频，默认不覆盖") //nolint
-	c.AssOFF = *flag.Bool("a", false, "是否关闭自动生成ass弹幕，默认不关闭")
-	c.FFMpegPath = *flag.String("f", "", "指定FFMpeg路径，默认使用自带的FFMpeg文件")
-	c.CachePath = *flag.String("c", "", "指定缓存路径，默认使用bilibili默认缓存路径")
-	version := flag.Bool("v", false, "查看版本号")
-	flag.Parse()
-	if *version {
-		fmt.Println("Version:", "1.3.2")
-		os.Exit(0)
+	if Exist(ConfigFile) {
+		if err := c.LoadFile(ConfigFile); err != nil {
+			return fmt.Errorf("配置文件 %s 解析失败：%w", ConfigFile, err)
+		}
+		logrus.Info("已加载配置文件:", ConfigFile)
 	}
-	if c.FFMpegPath == "" {
-		c.GetFFmpegPath()
+	fs := flag.NewFlagSet(filepath.Base(os.Args[0]), flag.ExitOnError)
+	overlay := fs.Bool("o", c.Overlay == "-y", "是否覆盖已存在的视频，默认不覆盖") //nolint
+	assOFF := fs.Bool("a", c.AssOFF, "是否关闭自动生成ass弹幕，默认不关闭")
+	ffmpegPath := fs.String("f", c.FFMpegPath, "指定FFMpeg路径，默认使用自带的FFMpeg文件")
+	ffmpegSHA256 := fs.String("ffmpeg-sha256", "", "校验-f指定的ffmpeg文件的SHA-256，不一致时拒绝运行")
+	noEmbedFFmpeg := fs.Bool("no-embed-ffmpeg", false, "不向工作目录释放内置的ffmpeg.exe，避免被杀毒软件拦截，需要通过-f指定已有的ffmpeg")
+	var cachePaths stringList
+	fs.Var(&cachePaths, "c", "指定缓存路径，可重复指定或用逗号分隔多个路径，也可以是压缩的缓存zip文件，默认使用bilibili默认缓存路径")
+	jobs := fs.Int("j", runtime.NumCPU(), "同时合成的视频数量，默认为CPU核数")
+	progress := fs.Bool("progress", true, "是否显示合成进度条，-progress=false 关闭")
+	mp3 := fs.Bool("mp3", false, "只提取音频为mp3，不合成视频")
+	videoOnly := fs.Bool("video-only", false, "只将去掉文件头的视频轨道保存为mp4，不合成音频和弹幕，用于排查哪个轨道损坏")
+	audioOnly := fs.Bool("audio-only", false, "只将去掉文件头的音频轨道保存为m4a，不转码也不合成，用于排查哪个轨道损坏")
+	audioFormat := fs.String("audio-format", AudioMp3, "提取音频的格式，可选mp3、wav、flac、aac，aac在源文件为aac时直接复制，指定mp3以外的格式时自动只提取音频")
+	loudnorm := fs.Bool("loudnorm", false, "提取音频时按EBU R128标准统一音量")
+	loudnorm2Pass := fs.Bool("loudnorm-2pass", false, "统一音量时先分析整段音频再调整，更准确但耗时加倍")
+	tmpl := fs.String("template", "", "输出文件名模板，可用字段{{.Title}} {{.Uname}} {{.GroupTitle}} {{.Index}} {{.Date}}，默认为视频名称")
+	layout := fs.String("layout", LayoutGroupUname, "输出目录结构，group-uname为\"视频组名称-用户名\"，uname/group为\"用户名/视频组名称\"两级目录，flat为直接放在输出目录中")
+	asciiSafe := fs.Bool("ascii-safe", false, "输出的目录和文件名只使用ASCII字符，全角字符转换为半角，中文和emoji等替换为_，全部被替换时使用cid，用于FAT32、exFAT格式的U盘和移动硬盘")
+	strictSync := fs.Bool("strict-sync", false, "合成前音视频时长相差超过1秒或2%时跳过合成，默认只警告，需要ffprobe")
+	outputSuffix := fs.String("output-suffix", "", "加在输出文件名后（扩展名之前）的后缀，如\" [danmaku]\"，用于在共享的文件夹中区分合成的文件，默认不加")
+	onCollision := fs.String("on-collision", "", "不同视频的输出文件名相同时的处理方式，skip跳过，overwrite覆盖，rename在文件名后加上 (2)、(3)...，默认指定-o时覆盖，否则跳过")
+	preserveMtime := fs.String("preserve-mtime", "", "将合成的文件修改时间设为source(m4s文件的修改时间)、pubdate(发布时间)或ctime(投稿时间)，便于媒体库按时间排序，默认不修改")
+	cover := fs.Bool("cover", false, "下载视频封面并添加到合成的视频中")
+	burn := fs.Bool("burn", false, "是否将ass弹幕压制到视频中，需要重新编码，速度较慢")
+	crf := fs.Int("crf", 23, "压制弹幕时的视频质量，取值0-51，越小质量越高")
+	quality := fs.String("quality", "max", "缓存中有多个清晰度时选择的视频清晰度，max、min或1080p等")
+	timeout := fs.Duration("timeout", 30*time.Minute, "单个文件执行ffmpeg的超时时间，超时后结束ffmpeg并记为失败，0为不限制")
+	retry := fs.Int("retry", 3, "弹幕下载失败时的最大尝试次数")
+	proxy := fs.String("proxy", "", "下载弹幕使用的代理，如http://127.0.0.1:8080或socks5://127.0.0.1:1080，默认使用HTTP_PROXY环境变量")
+	fastStart := fs.Bool("faststart", true, "mp4和mov格式时将moov移到文件开头，便于网络边下边播，合成后需要重写一遍文件，大文件会多花一些时间和磁盘读写，-faststart=false关闭")
+	ffmpegArgs := fs.String("ffmpeg-args", "", "追加到合成命令中的ffmpeg参数，按shell规则拆分，如 -ffmpeg-args \"-map_metadata -1 -metadata comment='bilibili'\"")
+	execCmd := fs.String("exec", "", "每个视频合成成功后通过shell执行的命令，{file}替换为合成的文件，{title}为视频名称，{uname}为上传的用户名，不会自动加引号，如 -exec 'mv \"{file}\" /mnt/nas/'")
+	execTimeout := fs.Duration("exec-timeout", 10*time.Minute, "执行-exec命令的超时时间，0为不限制")
+	httpTimeout := fs.Duration("http-timeout", 30*time.Second, "下载弹幕的超时时间")
+	cid := fs.String("cid", "", "只处理cid为该值的视频，并打印详细的诊断信息，可配合-verbose排查问题")
+	bvid := fs.String("bvid", "", "只处理bvid或av号为该值的视频，如 BV1xx411c7mD 或 av170001")
+	m4sExt := fs.String("m4s-ext", "", "m4s以外额外识别为m4s的扩展名，逗号分隔，如 -m4s-ext .blv，.m4s不区分大小写，.download和.part等未下载完成的文件始终跳过")
+	refresh := fs.Bool("refresh", false, "忽略输出目录中的状态文件，重新处理所有缓存目录")
+	requireDanmaku := fs.Bool("require-danmaku", false, "弹幕下载或转换失败时跳过该目录，不合成，用于完整存档，默认仍合成不含弹幕的视频")
+	fallbackCopy := fs.Bool("fallback-copy", false, "ffmpeg缺少-burn、-embed-ass或提取音频需要的编码器时，改为直接复制音视频流并在报告中注明，默认停止运行")
+	force := fs.Bool("force", false, "忽略videoInfo中的缓存状态，强制合成未标记为缓存完成的目录")
+	minStatus := fs.String("min-status", conver.StatusCompleted.String(), "合成所需的最低缓存状态，按pending、downloading、stopped、completed的顺序，如stopped时也合成已暂停缓存的目录")
+	match := fs.String("match", "", "只合成指定字段匹配该正则表达式的视频")
+	notMatch := fs.String("match-exclude", "", "跳过指定字段匹配该正则表达式的视频")
+	matchField := fs.String("match-field", MatchTitle, "-match和-match-exclude匹配的字段，可选title、groupTitle、uname")
+	dryRun := fs.Bool("dry-run", false, "只列出将要合成的文件，不转换m4s、不下载弹幕也不执行合成，不向缓存和输出目录写入任何文件")
+	report := fs.String("report", "", "将本次运行结果以json格式写入指定文件")
+	playlist := fs.String("playlist", "", "在输出目录中生成包含所有合成成功的文件的播放列表，如playlist.m3u8，按视频组名称和分P排序")
+	dmAPI := fs.String("dm-api", DanmakuXml, "下载弹幕优先使用的接口，xml或seg(protobuf分段接口，弹幕更全)，失败时自动换用另一个")
+	dmBase := fs.String("dm-base", DefaultDanmakuBase, "xml弹幕接口的地址，从<地址>/<cid>.xml下载，用于镜像或代理")
+	dmDir := fs.String("dm-dir", "", "先从该目录读取<cid>.xml弹幕，如第三方存档的弹幕，找不到时再从bilibili下载")
+	refreshDm := fs.Bool("refresh-dm", false, "缓存目录中已有<cid>.xml弹幕时也重新下载")
+	dmFormat := fs.String("dm-format", DanmakuAss, "弹幕转换的格式，ass或srt(普通字幕，兼容不支持ass的播放器)")
+	dmFont := fs.String("dm-font", conver.DefaultAssStyle.FontName, "弹幕字体名称")
+	dmSize := fs.Int("dm-size", conver.DefaultAssStyle.Fontsize, "弹幕字体大小")
+	dmOpacity := fs.Float64("dm-opacity", float64(conver.DefaultAssStyle.Opacity), "弹幕不透明度，取值0-1")
+	dmOutline := fs.Int("dm-outline", conver.DefaultAssStyle.Outline, "弹幕描边大小")
+	dmRollTime := fs.Int("dm-roll-time", conver.DefaultAssStyle.RollTime, "滚动弹幕显示时间，单位秒，越小滚动越快")
+	dmTypes := fs.String("dm-types", "", "保留的弹幕类型，逗号分隔，可选scroll、top、bottom，默认全部保留")
+	var dmBlock stringList
+	fs.Var(&dmBlock, "dm-block", "屏蔽内容匹配该正则表达式的弹幕，可重复指定")
+	format := fs.String("format", FormatMp4, "输出的视频格式，可选mp4、mkv、mov")
+	embedSub := fs.Bool("embed-sub", false, "将弹幕作为软字幕轨道封装进视频，默认在视频旁复制弹幕文件")
+	embedAss := fs.Bool("embed-ass", false, "同-embed-sub，保留用于兼容")
+	chapters := fs.Bool("chapters", false, "多P视频合并为一个文件，并按每个分P的时长和名称添加章节标记，合并后的视频不含弹幕，只对合成视频有效")
+	depth := fs.Int("depth", 0, "查找缓存目录的最大深度，相对缓存路径，0为不限制")
+	include := fs.String("include", "", "只合成目录名匹配该通配符的缓存目录，如 1332*")
+	exclude := fs.String("exclude", "", "跳过目录名匹配该通配符的目录")
+	hwaccel := fs.String("hwaccel", HWAccelNone, "压制弹幕时使用的硬件加速，可选none、nvenc、qsv、videotoolbox、vaapi")
+	tmp := fs.String("tmp", "", "存放中间音视频文件的目录，默认写入bilibili缓存目录")
+	out := fs.String("out", "", "合成文件的根目录，默认为bilibili缓存路径下的output目录")
+	overwriteIfBetter := fs.Bool("overwrite-if-better", false, "已有合成的文件时重新合成到临时文件，时长不短于且大小不小于已有文件的80%时才替换，否则保留已有文件，需要ffprobe")
+	readonlyCache := fs.Bool("readonly-cache", false, "缓存目录只读，如网络共享，不向缓存目录写入任何文件，中间文件和弹幕写入-tmp目录，合成文件写入-out目录，未指定时分别使用当前目录下的output和output/.tmp")
+	keepTemp := fs.Bool("keep-temp", false, "合成后保留-tmp目录中的中间文件")
+	logPath := fs.String("log", LogFile, "日志文件路径")
+	logMaxMB := fs.Int("log-max-mb", defaultLogMaxMB, "单个日志文件的最大大小，单位MB，超过后轮转")
+	logBackups := fs.Int("log-backups", defaultLogBackups, "轮转后最多保留的旧日志文件数量")
+	quiet := fs.Bool("quiet", false, "只输出警告和错误日志")
+	verbose := fs.Bool("verbose", false, "输出调试日志")
+	clean := fs.Bool("clean", false, "合成成功后删除缓存目录中生成的音视频文件、本程序下载的xml弹幕和ass/srt文件，保留m4s、videoInfo和客户端缓存的弹幕")
+	cleanOnly := fs.Bool("clean-only", false, "不合成，只清理已合成成功且缓存未变化的目录中的中间文件")
+	yes := fs.Bool("y", false, "清理中间文件前不询问")
+	check := fs.Bool("check", false, "只检查ffmpeg能否运行、版本及-burn、-mp3等模式需要的编码器和滤镜，不读取缓存，检查失败时返回非0")
+	list := fs.Bool("list", false, "只列出缓存目录的视频组、视频名称、UP主、缓存状态以及音视频和弹幕文件是否齐全，不合成")
+	noWait := fs.Bool("no-wait", false, "结束时不等待按回车键，标准输出不是终端时自动开启，用于脚本中调用")
+	noGUI := fs.Bool("no-gui", false, "不弹出任何窗口，也不等待按回车键退出，标准输入不是终端时自动开启")
+	version := fs.Bool("v", false, "查看版本号")
+	cmd, err := parseArgs(fs, os.Args[1:])
+	if err != nil {
+		return err
+	}
+	// 解析参数之后才能取到命令行参数的值
+	c.Headless = *noGUI || !isTerminal(os.Stdin) // 先于其它参数设置，参数错误时也不弹窗
+	c.NoWait = *noWait || !isTerminal(os.Stdout)
+	if *logPath != LogFile || *logMaxMB != defaultLogMaxMB || *logBackups != defaultLogBackups {
+		if *logMaxMB < 1 || *logBackups < 0 {
+			return errors.New("日志参数错误，-log-max-mb需大于0，-log-backups不能小于0")
+		}
+		SetupLog(*logPath, *logMaxMB, *logBackups)
+	}
+	switch {
+	case *verbose:
+		c.LogLevel = "debug"
+	case *quiet:
+		c.LogLevel = "warn"
+	case c.LogLevel == "":
+		c.LogLevel = "info"
+	}
+	if err := SetLogLevel(c.LogLevel); err != nil {
+		return err
+	}
+	c.AssOFF = *assOFF
+	c.RequireDanmaku = *requireDanmaku
+	if c.RequireDanmaku && c.AssOFF {
+		return errors.New("-require-danmaku与-a不能同时使用")
+	}
+	c.FFMpegPath = *ffmpegPath
+	c.FFmpegSHA256 = strings.TrimSpace(*ffmpegSHA256)
+	c.NoEmbedFFmpeg = *noEmbedFFmpeg
+	if paths := splitPaths(cachePaths); len(paths) > 0 {
+		c.CachePaths = dedupCachePaths(paths)
+		c.CachePath = c.CachePaths[0]
+	}
+	c.Jobs = *jobs
+	c.Progress = *progress
+	c.Mp3 = *mp3 || cmd == CmdExtractAudio
+	if cmd == CmdExtractAudio {
+		c.AssOFF = true // 只提取音频，不需要下载和转换弹幕
+	}
+	c.VideoOnly, c.AudioOnly = *videoOnly, *audioOnly
+	if c.VideoOnly && c.AudioOnly {
+		return errors.New("-video-only与-audio-only不能同时使用")
+	}
+	c.AudioFormat = strings.ToLower(*audioFormat)
+	if _, ok := audioSuffix[c.AudioFormat]; !ok {
+		return errors.New("不支持的音频格式：" + *audioFormat + "，可选mp3、wav、flac、aac")
+	}
+	if c.AudioFormat != AudioMp3 {
+		c.Mp3 = true
+	}
+	c.Loudnorm = *loudnorm || *loudnorm2Pass
+	c.Loudnorm2Pass = *loudnorm2Pass
+	c.parseTemplate(*tmpl)
+	c.ASCIISafe = *asciiSafe
+	c.OnCollision = *onCollision
+	c.TitleSuffix = *outputSuffix
+	c.StrictSync = *strictSync
+	if c.OnCollision != "" && c.OnCollision != CollisionSkip && c.OnCollision != CollisionOverwrite && c.OnCollision != CollisionRename {
+		return errors.New("不支持的文件名冲突处理方式：" + c.OnCollision + "，可选skip、overwrite、rename")
+	}
+	c.Layout = *layout
+	if c.Layout != LayoutGroupUname && c.Layout != LayoutUnameGroup && c.Layout != LayoutFlat {
+		return errors.New("不支持的目录结构：" + c.Layout + "，可选group-uname、uname/group、flat")
+	}
+	c.Burn = *burn
+	c.Cover = *cover
+	c.PreserveMtime = strings.ToLower(*preserveMtime)
+	if c.PreserveMtime != "" && c.PreserveMtime != MtimeSource && c.PreserveMtime != MtimePubdate && c.PreserveMtime != MtimeCtime {
+		return errors.New("不支持的修改时间来源：" + *preserveMtime + "，可选source、pubdate、ctime")
+	}
+	c.CRF = *crf
+	c.Quality = *quality
+	c.Retry = *retry
+	c.Timeout = *timeout
+	extra, err := splitArgs(*ffmpegArgs)
+	if err != nil {
+		return fmt.Errorf("-ffmpeg-args参数错误：%w", err)
+	}
+	c.FFmpegArgs = extra
+	c.NoFastStart = !*fastStart
+	c.Exec = *execCmd
+	c.ExecTimeout = *execTimeout
+	client, err := NewHTTPClient(*proxy, *httpTimeout)
+	if err != nil {
+		return err
+	}
+	c.Client = client
+	c.DryRun = *dryRun
+	c.Force = *force
+	c.FallbackCopy = *fallbackCopy
+	if c.MinStatus = conver.ParseStatus(*minStatus); c.MinStatus == conver.StatusUnknown {
+		return errors.New("不支持的缓存状态：" + *minStatus + "，可选pending、downloading、stopped、completed")
+	}
+	c.Refresh = *refresh
+	c.M4sExts = splitPaths([]string{*m4sExt})
+	c.Cid = strings.TrimSpace(*cid)
+	c.Bvid = strings.TrimSpace(*bvid)
+	if c.Match, err = compileMatch(*match); err != nil {
+		return err
+	}
+	if c.NotMatch, err = compileMatch(*notMatch); err != nil {
+		return err
+	}
+	c.MatchField = *matchField
+	if c.MatchField != MatchTitle && c.MatchField != MatchGroupTitle && c.MatchField != MatchUname {
+		return errors.New("不支持的匹配字段：" + c.MatchField + "，可选title、groupTitle、uname")
+	}
+	c.Report = *report
+	c.Playlist = *playlist
+	if c.Playlist != "" && (filepath.Base(c.Playlist) != c.Playlist || !isPlaylist(c.Playlist)) {
+		return errors.New("播放列表应为.m3u或.m3u8文件名，不能包含目录：" + c.Playlist)
+	}
+	c.DanmakuAPI = *dmAPI
+	if c.DanmakuBase, err = parseDanmakuBase(*dmBase); err != nil {
+		return err
 	}
-	if c.CachePath == "" {
-		c.GetCachePath()
+	c.DanmakuFormat = *dmFormat
+	c.RefreshDm = *refreshDm
+	if *dmDir != "" {
+		c.DanmakuSources = append(c.DanmakuSources, DirDanmaku{Dir: *dmDir})
+	}
+	c.AssStyle = conver.AssStyle{
+		FontName: *dmFont,
+		Fontsize: *dmSize,
+		Opacity:  float32(*dmOpacity),
+		Outline:  *dmOutline,
+		RollTime: *dmRollTime,
+	}
+	if c.AssStyle.Filter, err = conver.NewDanmakuFilter(*dmTypes, dmBlock); err != nil {
+		return err
+	}
+	if c.AssStyle.Opacity < 0 || c.AssStyle.Opacity > 1 || c.AssStyle.Fontsize <= 0 || c.AssStyle.RollTime <= 0 {
+		return errors.New("弹幕样式参数错误，不透明度取值0-1，字体大小和滚动时间需大于0")
+	}
+	if c.DanmakuAPI != DanmakuXml && c.DanmakuAPI != DanmakuSeg {
+		return errors.New("不支持的弹幕接口：" + c.DanmakuAPI)
+	}
+	if c.DanmakuFormat != DanmakuAss && c.DanmakuFormat != DanmakuSrt {
+		return errors.New("不支持的弹幕格式：" + c.DanmakuFormat + "，可选ass、srt")
+	}
+	c.Format = strings.ToLower(*format)
+	if _, ok := formatSuffix[c.Format]; !ok {
+		return errors.New("不支持的输出格式：" + *format + "，可选mp4、mkv、mov")
+	}
+	c.EmbedAss = *embedSub || *embedAss
+	c.Chapters = *chapters
+	if c.Chapters && c.Burn {
+		return errors.New("-chapters合并分P时不添加弹幕，不能与-burn同时使用")
+	}
+	c.Depth = *depth
+	c.Include = *include
+	c.Exclude = *exclude
+	for _, pattern := range []string{c.Include, c.Exclude} {
+		if _, e := filepath.Match(pattern, ""); e != nil {
+			return errors.New("目录通配符错误：" + pattern)
+		}
+	}
+	c.HWAccel = strings.ToLower(*hwaccel)
+	if _, ok := hwEncoder[c.HWAccel]; !ok {
+		return errors.New("不支持的硬件加速：" + *hwaccel + "，可选none、nvenc、qsv、videotoolbox、vaapi")
+	}
+	if c.Jobs < 1 {
+		c.Jobs = 1
+	}
+	c.ShowVersion = *version
+	c.List = *list || cmd == CmdList
+	c.Check = *check || cmd == CmdCheck
+	c.CleanOnly = *cleanOnly || cmd == CmdClean
+	c.Clean = *clean || c.CleanOnly
+	c.ReadonlyCache = *readonlyCache
+	if c.ReadonlyCache && c.Clean {
+		return errors.New("-readonly-cache时不能使用-clean和-clean-only清理缓存目录")
+	}
+	c.Yes = *yes
+	if *tmp != "" {
+		if c.Tmp, err = filepath.Abs(*tmp); err != nil {
+			return fmt.Errorf("临时目录无效：%w", err)
+		}
+	}
+	c.KeepTemp = *keepTemp
+	if *out != "" {
+		if c.Out, err = filepath.Abs(*out); err != nil {
+			return fmt.Errorf("输出目录无效：%w", err)
+		}
 	}
 	c.Overlay = "-n"
 	if *overlay {
 		c.Overlay = "-y"
 	}
+	c.OverwriteIfBetter = *overwriteIfBetter
+	if c.OverwriteIfBetter && *overlay {
+		return errors.New("-overwrite-if-better与-o不能同时使用")
+	}
+	if c.VideoOnly || c.AudioOnly {
+		if c.Mp3 || c.Burn || c.EmbedAss || c.RequireDanmaku {
+			return errors.New("-video-only和-audio-only不合成，不能与-mp3、-audio-format、-burn、-embed-sub、-require-danmaku同时使用")
+		}
+		c.AssOFF = true // 不合成弹幕，也不用下载
+	}
+	return nil
 }
 
-func (c *Config) Composition(videoFile, audioFile, outputFile string) error {
-	// 构建FFmpeg命令行参数
-	args := []string{
-		"-i", videoFile,
-		"-i", audioFile,
-		"-c:v", "copy", // video不指定编解码，使用bilibili原有编码
-		"-c:a", "copy", // audio不指定编解码，使用bilibili原有编码
-		"-strict", "experimental", // 宽松编码控制器
-		c.Overlay, // 是否覆盖已存在视频
-		outputFile,
-		"-hide_banner", // 隐藏版本信息和版权声明
-		"-stats",       // 只显示统计信息
+// Prepare 检查合成前需要的ffmpeg路径、缓存路径和临时目录，Converter.Run开始时调用
+func (c *Config) Prepare() error {
+	if c.FFMpegPath == "" {
+		if err := c.GetFFmpegPath(); err != nil {
+			return err
+		}
 	}
+	if c.Runner == nil {
+		if _, err := exec.LookPath(c.FFMpegPath); err != nil {
+			return fmt.Errorf("找不到ffmpeg：%w", err)
+		}
+	}
+	if err := c.verifyFFmpeg(); err != nil {
+		return err
+	}
+	if c.FFProbePath == "" {
+		c.FFProbePath = findFFprobe(c.FFMpegPath)
+	}
+	if c.probes == nil {
+		c.probes = &probeCache{infos: make(map[string]probeEntry)}
+	}
+	if c.DryRun && c.planned == nil {
+		c.planned = &plannedFiles{files: make(map[string]bool)}
+	}
+	if c.OverwriteIfBetter && c.FFProbePath == "" {
+		return errors.New("-overwrite-if-better需要ffprobe比较合成的文件，找不到ffprobe")
+	}
+	if c.StrictSync && c.FFProbePath == "" {
+		return errors.New("-strict-sync需要ffprobe读取音视频时长，找不到ffprobe")
+	}
+	if len(c.CacheRoots()) == 0 {
+		return errors.New("未指定 bilibili 缓存路径")
+	}
+	if err := c.openArchives(); err != nil {
+		return err
+	}
+	if c.ReadonlyCache {
+		if err := c.readonlyDefaults(); err != nil {
+			return err
+		}
+	}
+	if c.Tmp != "" && c.inCache(c.Tmp) {
+		return errors.New("临时目录不能位于缓存目录中：" + c.Tmp)
+	}
+	if c.Out != "" && !c.DryRun {
+		if err := checkWritable(c.Out); err != nil {
+			return fmt.Errorf("输出目录不可写：%w", err)
+		}
+	}
+	// 作为库使用时未设置的字段使用与命令行相同的默认值
+	if c.Jobs < 1 {
+		c.Jobs = 1
+	}
+	if c.Overlay == "" {
+		c.Overlay = "-n"
+	}
+	if c.Retry < 1 {
+		c.Retry = 1
+	}
+	if c.Client == nil {
+		c.Client, _ = NewHTTPClient("", 30*time.Second)
+	}
+	if c.DanmakuAPI == "" {
+		c.DanmakuAPI = DanmakuXml
+	}
+	if c.MinStatus == conver.StatusUnknown {
+		c.MinStatus = conver.StatusCompleted
+	}
+	if c.DanmakuBase == "" {
+		c.DanmakuBase = DefaultDanmakuBase
+	}
+	if c.DanmakuFormat == "" {
+		c.DanmakuFormat = DanmakuAss
+	}
+	setM4sExts(c.M4sExts)
+	if c.AssStyle.Fontsize == 0 {
+		c.AssStyle = conver.DefaultAssStyle
+	}
+	if c.Format == "" {
+		c.Format = FormatMp4
+	}
+	if c.AudioFormat == "" {
+		c.AudioFormat = AudioMp3
+	}
+	if c.HWAccel == "" {
+		c.HWAccel = HWAccelNone
+	}
+	return nil
+}
 
-	//logrus.Info(c.FFMpegPath, args)
-	cmd := exec.Command(c.FFMpegPath, args...)
-
-	// 设置输出和错误流 pipe
-	stdout, _ := cmd.StdoutPipe()
-	stderr, _ := cmd.StderrPipe()
+// FFmpegEnvs 未指定-f时依次读取的ffmpeg路径环境变量，用于容器等通过环境变量配置工具路径的环境
+var FFmpegEnvs = []string{"M4S_FFMPEG", "FFMPEG"}
 
-	// 启动命令
-	if err := cmd.Start(); err != nil {
-		c.MessageBox(fmt.Sprintf("执行FFmpeg命令失败: %s", err))
-		os.Exit(1)
+// ffmpegFromEnv 返回环境变量指定的ffmpeg路径，都没有设置时返回空，指定的文件不存在或不可执行时返回错误
+func ffmpegFromEnv() (string, error) {
+	for _, name := range FFmpegEnvs {
+		path := strings.TrimSpace(os.Getenv(name))
+		if path == "" {
+			continue
+		}
+		found, err := exec.LookPath(path)
+		if err != nil {
+			return "", fmt.Errorf("环境变量%s指定的ffmpeg无法执行：%w", name, err)
+		}
+		logrus.Debugf("使用环境变量%s指定的ffmpeg: %s", name, found)
+		return found, nil
 	}
+	return "", nil
+}
 
-	// 读取并打印输出流
-	go printOutput(stdout)
+// fastStart 是否为输出文件加上+faststart，只有mp4和mov支持
+func (c *Config) fastStart() bool {
+	return !c.NoFastStart && (c.Format == FormatMp4 || c.Format == FormatMov)
+}
 
-	// 读取并打印错误流
-	go printError(stderr, outputFile)
+// additiveArgs 可以重复指定、与程序生成的参数不冲突的ffmpeg参数
+var additiveArgs = map[string]bool{"-metadata": true}
 
-	assFile := strings.ReplaceAll(outputFile, filepath.Ext(outputFile), conver.AssSuffix)
-	if err := copyFile(c.AssPath, assFile, func(*os.File) {}); err != nil {
-		logrus.Error(err)
+// extraArgs 返回-ffmpeg-args指定的参数，与程序已生成的args中的参数重复时警告，但仍然使用
+func (c *Config) extraArgs(args []string) []string {
+	for _, a := range c.FFmpegArgs {
+		if strings.HasPrefix(a, "-") && !additiveArgs[a] && hasArg(args, a) {
+			logrus.Warnf("-ffmpeg-args中的%s与程序生成的参数重复，可能覆盖原有设置", a)
+		}
 	}
-	// 等待命令执行完成
-	if err := cmd.Wait(); err == nil {
-		fmt.Println()
-		logrus.Info("已合成视频文件:", filepath.Base(outputFile))
+	return c.FFmpegArgs
+}
+
+// hasArg 判断ffmpeg参数中是否有指定的选项
+func hasArg(args []string, name string) bool {
+	for _, a := range args {
+		if a == name {
+			return true
+		}
 	}
-	return nil
+	return false
 }
 
-func (c *Config) FindM4sFiles(src string, info os.DirEntry, err error) error {
+// checkWritable 创建目录并写入一个临时文件，检查目录是否可写
+func checkWritable(dir string) error {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".m4s-converter-*")
 	if err != nil {
 		return err
 	}
-	// 查找.m4s文件
-	if filepath.Ext(info.Name()) == conver.M4sSuffix {
-		var dst string
-		if videoId, audioId := GetVAId(src); videoId != "" && audioId != "" {
-			if strings.Contains(info.Name(), audioId) { // 音频文件
-				dst = strings.ReplaceAll(src, conver.M4sSuffix, conver.AudioSuffix)
-			} else {
-				dst = strings.ReplaceAll(src, conver.M4sSuffix, conver.VideoSuffix)
-			}
+	_ = f.Close()
+	return os.Remove(f.Name())
+}
+
+// Composition 合成音视频文件，metadata为写入视频的元数据，如title、artist、comment
+// coverFile为封面图片，为空时不添加封面
+func (c *Config) Composition(ctx context.Context, videoFile, audioFile, assFile, coverFile, outputFile string, metadata map[string]string) error {
+	burn := c.Burn && assFile != ""
+	if c.Burn && !burn {
+		logrus.Warn("没有ass弹幕文件，不压制弹幕:", filepath.Base(outputFile))
+	}
+	embed := c.EmbedAss && !burn && assFile != ""
+	logrus.Debugf("合成%s: burn=%v embed=%v ass=%q cover=%q", filepath.Base(outputFile), burn, embed, assFile, coverFile)
+	opts := conver.MuxOptions{
+		Overwrite: c.Overlay == "-y",
+		Metadata:  metadata,
+		Cover:     coverFile,
+		FastStart: c.fastStart() && !hasArg(c.FFmpegArgs, "-movflags"),
+	}
+	if embed {
+		opts.Subtitle = assFile
+	}
+	if burn {
+		// 压制弹幕需要重新编码视频
+		opts.InputArgs = c.hwInputArgs()
+		opts.VideoArgs = c.encodeArgs(subtitleFilter(assFile))
+	}
+	// 先生成不含-ffmpeg-args的参数，检查与程序生成的参数是否重复
+	opts.ExtraArgs = c.extraArgs(conver.MuxArgs(videoFile, audioFile, outputFile, opts))
+	args := conver.MuxArgs(videoFile, audioFile, outputFile, opts)
+
+	var total time.Duration
+	if c.Progress || c.ProgressFunc != nil {
+		total = GetDuration(c.cacheDir(filepath.Dir(videoFile)))
+	}
+	logrus.Debug("ffmpeg参数:", args)
+
+	// 已压制或封装弹幕时不再复制ass文件，避免播放器重复显示
+	if !burn && !embed && assFile != "" {
+		dstAssFile := sidecarPath(outputFile, assFile)
+		if err := copyFile(assFile, dstAssFile, func(*os.File) {}); isDiskFull(err) {
+			return fmt.Errorf("复制弹幕文件失败：%w", err)
+		} else if err != nil {
+			logrus.Error(err)
 		}
-		if err = M4sToAV(src, dst); err != nil {
-			c.MessageBox(fmt.Sprintf("%v 转换异常：%v", src, err))
+	}
+	if err := c.runFFmpeg(ctx, args, outputFile, total); err != nil {
+		return err
+	}
+	logrus.Info("已合成视频文件:", filepath.Base(outputFile))
+	return nil
+}
+
+// listM4sFiles 返回root下的所有m4s文件
+func listM4sFiles(root string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
 			return err
 		}
-		logrus.Info("已将m4s转换为音视频文件:", dst)
+		if d.IsDir() {
+			return nil
+		}
+		if isM4s(d.Name()) {
+			files = append(files, path)
+		} else if isPartial(d.Name()) && isM4s(d.Name()[:len(d.Name())-len(filepath.Ext(d.Name()))]) {
+			logrus.Warn("跳过未下载完成的文件:", path)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// ConvertM4sFiles 按-j指定的数量并发去掉m4s的文件头，转换为音视频文件，同一轨道分段的m4s按顺序合并
+// 单个文件转换失败不影响其它文件，返回所有失败文件的错误，每个错误为*M4sError
+func (c *Config) ConvertM4sFiles(files []string) error {
+	jobs := c.Jobs
+	if jobs < 1 {
+		jobs = 1
+	}
+	tracks := groupSegments(files) // 分段的m4s合并为一个轨道
+	errs := make([]error, len(tracks))
+	var done int32
+	ch := make(chan int)
+	var wg sync.WaitGroup
+	for n := 0; n < jobs; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range ch {
+				if len(tracks[i].srcs) > 1 {
+					errs[i] = c.convertSegments(tracks[i])
+				} else {
+					errs[i] = c.convertM4s(tracks[i].srcs[0])
+				}
+				if errs[i] != nil {
+					errs[i] = &M4sError{File: tracks[i].srcs[0], Err: errs[i]}
+				}
+				n := atomic.AddInt32(&done, 1)
+				c.emit(ProgressEvent{File: tracks[i].srcs[0], Phase: PhaseConvert, Percent: float64(n) * 100 / float64(len(tracks)), Err: errs[i]})
+			}
+		}()
+	}
+	for i := range tracks {
+		ch <- i
+	}
+	close(ch)
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// upToDate 判断上次转换的dst是否完整且不比src旧，dst的大小应为src去掉文件头后的大小，
+// 避免把中断时留下的不完整文件当作已转换；文件系统的时间精度有限，同一时刻写入的文件修改时间可能相同
+func upToDate(src, dst string) bool {
+	srcInfo, err := os.Stat(src)
+	if err != nil {
+		return false
 	}
+	dstInfo, err := os.Stat(dst)
+	if err != nil || dstInfo.ModTime().Before(srcInfo.ModTime()) {
+		return false
+	}
+	f, err := os.Open(src)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	data := make([]byte, headerProbeSize)
+	n, _ := io.ReadFull(f, data)
+	offset, _ := headerOffset(data[:n])
+	return dstInfo.Size() == srcInfo.Size()-int64(offset)
+}
+
+// convertM4s 将单个m4s文件去掉文件头，转换为音频或视频文件
+func (c *Config) convertM4s(src string) error {
+	dst, err := c.m4sDst(src)
+	if err != nil {
+		return fmt.Errorf("%v 识别异常：%w", src, err)
+	}
+	if dst == "" { // 未选中的其它清晰度
+		logrus.Debug("跳过未选中的m4s:", src)
+		return nil
+	}
+	logrus.Debug("m4s识别为:", filepath.Base(dst))
+	if c.DryRun {
+		c.plan(dst)
+		return nil
+	}
+	if c.Tmp != "" {
+		if err = os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+			return fmt.Errorf("创建临时目录失败：%w", err)
+		}
+	}
+	if c.Overlay != "-y" && upToDate(src, dst) {
+		logrus.Debug("音视频文件已存在，跳过转换:", dst)
+		return nil
+	}
+	if err = M4sToAV(src, dst); err != nil {
+		return fmt.Errorf("%v 转换异常：%w", src, err)
+	}
+	logrus.Info("已将m4s转换为音视频文件:", dst)
 	return nil
 }
 
-func GetCacheDir(cachePath string) ([]string, error) {
+// GetCacheDir 查找缓存路径下的视频缓存目录，跳过隐藏目录、系统目录和output目录
+// -depth限制相对cachePath的查找深度，-include和-exclude按目录名匹配通配符
+func (c *Config) GetCacheDir(cachePath string) ([]string, error) {
 	var dirs []string
-	err := filepath.Walk(cachePath, func(path string, info os.FileInfo, err error) error {
+	err := filepath.WalkDir(cachePath, func(path string, d os.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
-		if info.IsDir() && path != cachePath {
-			if !strings.Contains(path, "output") {
-				dirs = append(dirs, path)
+		if !d.IsDir() || path == cachePath {
+			return nil
+		}
+		name := d.Name()
+		if isHiddenDir(name) || name == "output" {
+			return filepath.SkipDir
+		}
+		if c.Depth > 0 && pathDepth(cachePath, path) > c.Depth {
+			return filepath.SkipDir
+		}
+		if c.Exclude != "" {
+			if ok, _ := filepath.Match(c.Exclude, name); ok {
+				logrus.Debug("排除目录:", path)
+				return filepath.SkipDir
 			}
 		}
+		if !isCacheEntry(path) {
+			// 缓存目录下只有分P子目录，其它目录不再遍历
+			if isCacheEntry(filepath.Dir(path)) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if c.Include != "" && !IsPageDir(path) {
+			if ok, _ := filepath.Match(c.Include, name); !ok {
+				return filepath.SkipDir
+			}
+		}
+		dirs = append(dirs, path)
 		return nil
 	})
 
@@ -150,21 +778,52 @@ func GetCacheDir(cachePath string) ([]string, error) {
 	return dirs, nil
 }
 
-func joinUrl(cid string) string {
+// isHiddenDir 判断是否为隐藏目录或Windows系统目录
+func isHiddenDir(name string) bool {
+	return strings.HasPrefix(name, ".") || strings.HasPrefix(name, "$") ||
+		strings.EqualFold(name, "System Volume Information")
+}
+
+// pathDepth 返回path相对root的目录层级，root的子目录为1
+func pathDepth(root, path string) int {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return 0
+	}
+	return strings.Count(rel, string(filepath.Separator)) + 1
+}
+
+func joinUrl(base, cid string) string {
 	//return "https://api.bilibili.com/x/v1/dm/list.so?oid=" + cid
-	return "https://comment.bilibili.com/" + cid + conver.XmlSuffix
+	return strings.TrimSuffix(base, "/") + "/" + cid + conver.XmlSuffix
+}
+
+// convertDanmaku 按-dm-format将xml弹幕转换为ass或srt，返回转换后的文件
+func (c *Config) convertDanmaku(xmlPath string) string {
+	if c.DanmakuFormat == DanmakuSrt {
+		return conver.Xml2srtWithFilter(xmlPath, c.AssStyle.Filter)
+	}
+	return conver.Xml2assWithStyle(xmlPath, c.AssStyle)
 }
 
-// GetAudioAndVideo 从给定的缓存路径中查找音频和视频文件，并尝试下载并转换xml弹幕为ass格式
+// GetAudioAndVideo 从给定的缓存路径中查找音频和视频文件，并尝试下载并转换xml弹幕为ass或srt格式
 // 参数:
+// - ctx: 取消时停止下载弹幕
 // - cachePath: 缓存路径，用于搜索音频、视频文件以及存储下载的弹幕文件
 // 返回值:
-// - video: 查找到的视频文件路径
-// - audio: 查找到的音频文件路径
-// - error: 在搜索、下载或转换过程中遇到的任何错误
-func (c *Config) GetAudioAndVideo(cachePath string) (string, string, error) {
-	var video string
-	var audio string
+// - pages: 按分P目录分组的音视频和弹幕文件，单P视频只有一个元素
+// - error: 遍历目录失败时pages为nil；否则为各分P的Page.Err，pages仍然可用
+func (c *Config) GetAudioAndVideo(ctx context.Context, cachePath string) ([]Page, error) {
+	pageByDir := make(map[string]*Page)
+	assByDir := make(map[string]string)
+	xmlByDir := make(map[string]string)
+	issues := make(map[string][]error) // 每个目录中不影响合成的问题
+	page := func(dir string) *Page {
+		if pageByDir[dir] == nil {
+			pageByDir[dir] = &Page{Dir: dir}
+		}
+		return pageByDir[dir]
+	}
 
 	// 遍历给定路径下的所有文件和目录
 	err := filepath.Walk(cachePath, func(path string, info os.FileInfo, err error) error {
@@ -172,32 +831,76 @@ func (c *Config) GetAudioAndVideo(cachePath string) (string, string, error) {
 			return err // 如果遇到错误，立即返回
 		}
 		if !info.IsDir() {
+			if c.Tmp != "" {
+				return nil // 指定-tmp时忽略缓存目录中以前留下的音视频文件
+			}
 			// 如果是文件，检查是否为视频或音频文件
 			if strings.Contains(path, conver.VideoSuffix) {
-				video = path // 找到视频文件
+				logrus.Debug("找到视频文件:", path)
+				page(filepath.Dir(path)).Video = path // 找到视频文件
 			}
 			if strings.Contains(path, conver.AudioSuffix) {
-				audio = path // 找到音频文件
+				logrus.Debug("找到音频文件:", path)
+				page(filepath.Dir(path)).Audio = path // 找到音频文件
 			}
 		} else {
+			if c.Tmp != "" {
+				c.findTempFiles(path, page) // 音视频文件在-tmp目录中
+			}
+			if c.DryRun {
+				c.findPlanned(path, page)
+			}
 			// 如果是目录，尝试下载并转换xml弹幕为ass格式
-			if !c.AssOFF {
-				xmlPath := filepath.Join(path, info.Name()+conver.XmlSuffix)
-				if e := DownloadFile(joinUrl(info.Name()), xmlPath); e != nil {
-					logrus.Warn("XML弹幕下载失败:", err) // 记录下载失败的日志
-					return nil
+			if c.AssOFF {
+				logrus.Debug("已关闭弹幕，跳过:", path)
+			} else if c.DryRun {
+				assByDir[path] = c.plannedDanmaku(path, dirCid(path)) // dry-run时不下载也不转换
+			} else {
+				cid := dirCid(path)
+				xmlPath := c.danmakuXml(path, cid)
+				if !c.RefreshDm && localDanmaku(xmlPath) {
+					logrus.Info("使用本地弹幕:", xmlPath) // 缓存目录中已有弹幕，不重新下载
+				} else {
+					logrus.Debugf("下载弹幕: dir=%s cid=%s", path, cid)
+					e := c.downloadDanmaku(ctx, cid, path, xmlPath)
+					c.emit(ProgressEvent{File: xmlPath, Phase: PhaseDownload, Percent: 100, Err: e})
+					if e != nil {
+						logrus.Warn("XML弹幕下载失败:", e) // 记录下载失败的日志
+						issues[path] = append(issues[path], fmt.Errorf("%w: %v", ErrDanmakuDownload, e))
+						return nil
+					}
+					logrus.Info("已下载弹幕:", xmlPath)
+					xmlByDir[path] = xmlPath
+				}
+				assByDir[path] = c.convertDanmaku(xmlPath) // 转换xml弹幕文件为ass或srt格式
+				if assByDir[path] == "" {
+					issues[path] = append(issues[path], fmt.Errorf("%w: %s", ErrDanmakuConvert, xmlPath))
 				}
-				c.AssPath = conver.Xml2ass(xmlPath) // 转换xml弹幕文件为ass格式
 			}
 		}
 		return nil
 	})
 
 	if err != nil {
-		return "", "", err // 如果遍历过程中发生错误，返回错误信息
+		return nil, err // 如果遍历过程中发生错误，返回错误信息
 	}
 
-	return video, audio, nil // 返回找到的视频和音频文件路径
+	pages := sortPages(pageByDir, assByDir) // 找到的视频、音频和弹幕文件路径
+	var errs []error
+	for i := range pages {
+		p := &pages[i]
+		p.Xml = xmlByDir[p.Dir]
+		if p.Video == "" && c.needVideo() {
+			issues[p.Dir] = append(issues[p.Dir], fmt.Errorf("%w: %s", ErrNoVideoStream, p.Dir))
+		}
+		if p.Audio == "" && c.needAudio() {
+			issues[p.Dir] = append(issues[p.Dir], fmt.Errorf("%w: %s", ErrNoAudioStream, p.Dir))
+		}
+		if p.Err = errors.Join(issues[p.Dir]...); p.Err != nil {
+			errs = append(errs, p.Err)
+		}
+	}
+	return pages, errors.Join(errs...)
 }
 
 func copyFile(src, dst string, fn func(*os.File)) error {
@@ -223,41 +926,85 @@ func copyFile(src, dst string, fn func(*os.File)) error {
 	return nil
 }
 
+// M4sToAV 去掉m4s文件的头部，转换为可以被ffmpeg识别的音视频文件
+// 找不到合法的MP4 box时返回ErrBadM4sHeader，不生成dst
 func M4sToAV(src, dst string) error {
-	return copyFile(src, dst, func(srcFile *os.File) {
-		// 读取前9个字符
-		data := make([]byte, 9)
-		_, _ = io.ReadAtLeast(srcFile, data, 9)
-		if string(data) != "000000000" {
-			logrus.Errorf("音视频文件不是9个0的头，跳过转换")
-			return
-		}
-		// 移动到第9个字节
-		_, err := srcFile.Seek(9, 0) // 从文件开头偏移
-		if err != nil {
-			logrus.Errorf("文件字节偏移失败: %v", err)
-		}
-	})
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+	data := make([]byte, headerProbeSize)
+	n, _ := io.ReadFull(srcFile, data)
+	offset, ok := headerOffset(data[:n])
+	if !ok {
+		return ErrBadM4sHeader
+	}
+	// 移动到头部之后
+	if _, err = srcFile.Seek(int64(offset), io.SeekStart); err != nil {
+		return fmt.Errorf("文件字节偏移失败: %w", err)
+	}
+	dstFile, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dstFile, srcFile)
+	if e := dstFile.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		_ = os.Remove(dst)
+	}
+	return err
 }
 
-// GetCachePath 获取用户视频缓存路径
-func (c *Config) GetCachePath() {
+// DefaultCachePath 按顺序检查当前系统的bilibili默认缓存路径，返回第一个有m4s文件的路径
+// 都没有m4s文件时返回第一个候选路径和错误
+func DefaultCachePath() (string, error) {
 	u, err := user.Current()
 	if err != nil {
-		c.MessageBox(fmt.Sprintf("无法获取当前用户：%v", err))
-		return
+		return "", fmt.Errorf("无法获取当前用户：%w", err)
 	}
 
-	videosDir := filepath.Join(u.HomeDir, "Videos", "bilibili")
-	if findM4sFiles(videosDir) != nil {
-		c.MessageBox("未使用 bilibili 默认缓存路径 " + videosDir + ",\n请选择 bilibili 当前设置的缓存路径！")
-		c.SelectDirectory()
-		return
+	candidates := cacheCandidates(u.HomeDir)
+	if found := findCachePaths(candidates); len(found) > 0 {
+		return found[0], nil
 	}
-	c.CachePath = videosDir
-	logrus.Info("选择的 bilibili 缓存目录为: ", c.CachePath)
-	return
+	return candidates[0], fmt.Errorf("未使用 bilibili 默认缓存路径 %s", strings.Join(candidates, "、"))
+}
+
+// DefaultCachePaths 返回所有存在m4s文件的bilibili默认缓存路径，如同时安装了多个客户端
+func DefaultCachePaths() []string {
+	u, err := user.Current()
+	if err != nil {
+		return nil
+	}
+	return findCachePaths(cacheCandidates(u.HomeDir))
+}
 
+// findCachePaths 返回candidates中存在m4s文件的路径
+func findCachePaths(candidates []string) []string {
+	var found []string
+	for _, dir := range candidates {
+		if findM4sFiles(dir) == nil {
+			found = append(found, dir)
+			continue
+		}
+		logrus.Debug("默认缓存路径中没有m4s文件:", dir)
+	}
+	return found
+}
+
+// EntryCount 返回缓存路径root下的缓存目录数量，分P子目录不单独计算
+func (c *Config) EntryCount(root string) int {
+	dirs, _ := c.cacheDirs(root)
+	n := 0
+	for _, v := range dirs {
+		if !IsPageDir(v) {
+			n++
+		}
+	}
+	return n
 }
 
 // 查找 m4s 文件
@@ -267,7 +1014,7 @@ func findM4sFiles(directory string) error {
 		if err != nil {
 			return err
 		}
-		if !info.IsDir() && filepath.Ext(path) == conver.M4sSuffix {
+		if !info.IsDir() && isM4s(path) {
 			m4sFiles = append(m4sFiles, path)
 			return nil
 		}
@@ -282,41 +1029,6 @@ func findM4sFiles(directory string) error {
 	return nil
 }
 
-// GetFFmpegPath 获取 ffmpeg 路径
-func (c *Config) GetFFmpegPath() {
-	wd, _ := os.Getwd()
-	c.FFMpegPath = filepath.Join(wd, FFmpegName) // 指定ffmpeg路径
-	if !Exist(c.FFMpegPath) {
-		logrus.Info("第一次运行,自动释放ffmpeg.exe")
-		if err := DecFile(); err != nil {
-			logrus.Error(err)
-		}
-	}
-	if !c.FileHashCompare() {
-		logrus.Info("文件不完整,重新释放ffmpeg.exe")
-		if err := DecFile(); err != nil {
-			logrus.Error(err)
-			return
-		}
-	}
-}
-
-// DecFile 解压ffmpeg.exe
-func DecFile() error {
-	file, err := ffmpegFile.Open(FFmpegName)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	// 使用文件
-	data, err := io.ReadAll(file)
-	if err != nil {
-		return err
-	}
-	return os.WriteFile(FFmpegName, data, os.ModePerm)
-}
-
 func Exist(path string) bool {
 	_, err := os.Stat(path)
 	if err != nil {
@@ -326,7 +1038,7 @@ func Exist(path string) bool {
 }
 
 // Filter 过滤文件名
-func Filter(name string, err error) string {
+func Filter(name string) string {
 	name = strings.ReplaceAll(name, "<", "《")
 	name = strings.ReplaceAll(name, ">", "》")
 	name = strings.ReplaceAll(name, `\`, "#")
@@ -338,124 +1050,144 @@ func Filter(name string, err error) string {
 	name = strings.ReplaceAll(name, "【", "[")
 	name = strings.ReplaceAll(name, "】", "]")
 	name = strings.ReplaceAll(name, ":", "：")
-	name = strings.TrimSpace(name)
+	// 去掉控制字符
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, name)
+	name = truncateName(name)
+	// windows不允许文件名以点或空格结尾
+	name = strings.TrimRight(strings.TrimSpace(name), ". ")
+	if isReservedName(name) {
+		name = "_" + name
+	}
 
 	return name
 }
 
-func (c *Config) PanicHandler() {
-	if e := recover(); e != nil {
-		c.File.Close()
-		fmt.Print("按回车键退出...")
-		fmt.Scanln()
-	}
-}
+const (
+	maxNameRunes = 200 // 文件名最大字符数
+	maxNameBytes = 240 // 文件名最大字节数，大部分文件系统限制为255字节
+)
 
-func (c *Config) FileHashCompare() bool {
-	file, err := os.ReadFile(c.FFMpegPath)
-	if err != nil {
-		logrus.Error("打开文件失败:", err)
-		return false
+// truncateName 按字符数和UTF-8字节数截断文件名，不截断半个字符
+func truncateName(name string) string {
+	n, size := 0, 0
+	for i, r := range name {
+		if n == maxNameRunes || size+utf8.RuneLen(r) > maxNameBytes {
+			return name[:i]
+		}
+		n++
+		size += utf8.RuneLen(r)
 	}
-
-	// 计算文件的SHA-256哈希值
-	hash := sha256.Sum256(file)
-	sha256Str := fmt.Sprintf("%x", hash)
-
-	return FileHashValue == sha256Str
-}
-
-func _TEXT(str string) *uint16 {
-	ptr, _ := syscall.UTF16PtrFromString(str)
-	return ptr
-}
-
-func (c *Config) MessageBox(text string) {
-	logrus.Error(text)
-	win.MessageBox(win.HWND_TOP, _TEXT(text), _TEXT("消息"), win.MB_ICONWARNING)
+	return name
 }
 
-// SelectDirectory 选择bilimini缓存目录
-func (c *Config) SelectDirectory() {
-	var bsi win.BROWSEINFO
-	bsi.LpszTitle = _TEXT("请选择 bilibili 缓存目录")
-
-	pid := win.SHBrowseForFolder(&bsi)
-	if pid == 0 {
-		logrus.Warn("关闭对话框后自动退出程序")
-		os.Exit(1)
+// isReservedName 判断是否为windows保留的设备名，如CON、NUL、COM1，带扩展名的也不允许
+func isReservedName(name string) bool {
+	base := strings.ToUpper(name)
+	if i := strings.IndexByte(base, '.'); i >= 0 {
+		base = base[:i]
 	}
-
-	defer win.CoTaskMemFree(pid)
-
-	path := make([]uint16, win.MAX_PATH)
-	win.SHGetPathFromIDList(pid, &path[0])
-
-	c.CachePath = syscall.UTF16ToString(path)
-	if Exist(filepath.Join(c.CachePath, conver.VideoInfoSuffix)) ||
-		Exist(filepath.Join(c.CachePath, conver.VideoInfoJson)) ||
-		Exist(filepath.Join(c.CachePath, "load_log")) {
-		logrus.Info("选择的 bilibili 缓存目录为:", c.CachePath)
-		return
+	switch base {
+	case "CON", "PRN", "AUX", "NUL":
+		return true
+	}
+	if len(base) == 4 && (strings.HasPrefix(base, "COM") || strings.HasPrefix(base, "LPT")) {
+		return base[3] >= '1' && base[3] <= '9'
 	}
-	c.MessageBox("选择的 bilibili 缓存目录不正确，请重新选择！")
-	c.SelectDirectory()
+	return false
 }
 
-// LockMutex windows下的单实例锁
-func (c *Config) LockMutex(name string) error {
-	_, err := windows.CreateMutex(nil, true, _TEXT(name))
-	if err != nil {
-		return err
+// PanicHandler 记录异常信息后以非0退出码退出，交互运行时先等待按回车键
+func (c *Config) PanicHandler() {
+	if e := recover(); e != nil {
+		logrus.Error("程序异常退出:", e)
+		CloseLog()
+		if !c.Headless && !c.NoWait {
+			fmt.Print("按回车键退出...")
+			fmt.Scanln()
+		}
+		os.Exit(ExitFatal) // 非0退出码，便于脚本判断失败
 	}
-	return nil
 }
 
-func printOutput(stdout io.ReadCloser) {
-	buf := make([]byte, 1024)
-	for {
-		n, e := stdout.Read(buf)
-		if e != nil {
-			//logrus.Error("读取标准输出错误:", e)
-			return
-		}
-		if n > 0 {
-			fmt.Print(string(buf[:n]))
-		}
+// printOutput 按行打印输出流，并加上文件名前缀，避免同时合成多个视频时输出混在一起
+func printOutput(stdout io.Reader, outputFile string) {
+	name := filepath.Base(outputFile)
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		fmt.Printf("[%s] %s\n", name, scanner.Text())
 	}
 }
 
-func printError(stderr io.ReadCloser, outputFile string) {
-	fmt.Println("准备合成:", filepath.Base(outputFile))
-	buf := make([]byte, 1024)
-	for {
-		n, e := stderr.Read(buf)
-		if e != nil {
-			//logrus.Error("读取标准错误输出错误:", e)
-			return
+// stderrTailLines 合成失败时返回的ffmpeg错误输出行数
+const stderrTailLines = 10
+
+// printError 读取ffmpeg错误流并显示进度，返回最后几行输出和是否因文件已存在而跳过
+func printError(stderr io.Reader, outputFile string, total time.Duration, onTime func(time.Duration)) (tail []string, exists bool) {
+	name := filepath.Base(outputFile)
+	fmt.Println("准备合成:", name)
+	scanner := bufio.NewScanner(stderr)
+	scanner.Split(scanLines)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.Contains(line, "exists") {
+			exists = true
+			logrus.Warn("跳过已经存在的音视频文件:", name)
 		}
-		if n > 0 {
-			cmdErr := string(buf[:n])
-			if strings.Contains(cmdErr, "exists") {
-				logrus.Warn("跳过已经存在的音视频文件:", filepath.Base(outputFile))
+		if current, ok := parseTime(line); ok {
+			if onTime != nil {
+				onTime(current)
+			} else if total > 0 {
+				printProgress(name, current, total)
 			}
+			continue
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		tail = append(tail, line)
+		if len(tail) > stderrTailLines {
+			tail = tail[1:]
 		}
 	}
+	return
 }
 
-// GetVAId 返回.playurl文件中视频文件或音频文件件数组
-func GetVAId(patch string) (videoID string, audioID string) {
-	pu := filepath.Join(filepath.Dir(patch), conver.PlayUrlSuffix)
-	puDate, e := os.ReadFile(pu)
-	if e != nil {
-		logrus.Error("找不到.playurl文件: ", pu)
-		return
+// GetAudioAndVideo返回的各分P中不影响查找其它文件的问题，用errors.Is判断
+var (
+	ErrDanmakuDownload = errors.New("弹幕下载失败")
+	ErrDanmakuConvert  = errors.New("弹幕转换失败")
+	ErrNoVideoStream   = errors.New("找不到视频文件")
+	ErrNoAudioStream   = errors.New("找不到音频文件")
+)
+
+// ErrAlreadyRunning 已有其它实例正在运行
+var ErrAlreadyRunning = errors.New("只能运行一个实例！")
+
+// ErrNoPlayUrl 缓存目录中没有.playurl文件
+var ErrNoPlayUrl = errors.New("找不到.playurl文件")
+
+// GetVAId 返回.playurl文件中与已缓存m4s文件对应的视频ID和音频ID，quality用于选择视频清晰度
+func GetVAId(patch, quality string) (videoID string, audioID string, err error) {
+	dir := filepath.Dir(patch)
+	pu := filepath.Join(dir, conver.PlayUrlSuffix)
+	puDate, err := os.ReadFile(pu)
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %s", ErrNoPlayUrl, pu)
 	}
 	var p conver.PlayUrl
-	if err := json.Unmarshal(puDate, &p); err != nil {
-		logrus.Error("解析.playurl文件失败: ", err)
-		return
+	if err = json.Unmarshal(puDate, &p); err != nil {
+		return "", "", fmt.Errorf("解析.playurl文件失败: %v", err)
 	}
-
-	return strconv.Itoa(p.Data.Dash.Video[0].ID), strconv.Itoa(p.Data.Dash.Audio[0].ID)
+	if videoID, err = selectCached(dir, p.Data.Dash.Video, quality); err != nil {
+		return "", "", fmt.Errorf("视频流: %v", err)
+	}
+	if audioID, err = selectCached(dir, p.Data.Dash.Audio, ""); err != nil {
+		return "", "", fmt.Errorf("音频流: %v", err)
+	}
+	return videoID, audioID, nil
 }
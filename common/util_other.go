@@ -0,0 +1,68 @@
+//go:build !windows
+
+package common
+
+import (
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+)
+
+var FFmpegName = "ffmpeg"
+
+// CanSelectDirectory 是否可以弹出目录选择对话框
+const CanSelectDirectory = false
+
+// cacheCandidates 非windows系统可能的缓存路径，依次为macOS客户端、
+// 从安卓设备复制出的缓存和常见的下载目录
+func cacheCandidates(home string) []string {
+	return []string{
+		filepath.Join(home, "Movies", "bilibili"),
+		filepath.Join(home, "Videos", "bilibili"),
+		filepath.Join(home, "Downloads", "tv.danmaku.bili", "download"),
+		filepath.Join(home, "Download", "tv.danmaku.bili", "download"),
+		filepath.Join(home, "Downloads", "bilibili"),
+		filepath.Join(home, "bilibili"),
+	}
+}
+
+// GetFFmpegPath 未指定-f时获取ffmpeg路径，依次使用环境变量M4S_FFMPEG或FFMPEG、PATH中的ffmpeg，非windows系统没有内置ffmpeg
+func (c *Config) GetFFmpegPath() error {
+	path, err := ffmpegFromEnv()
+	if err != nil || path != "" {
+		c.FFMpegPath = path
+		return err
+	}
+	path, err = exec.LookPath(FFmpegName)
+	if err != nil {
+		return fmt.Errorf("找不到系统安装的ffmpeg，请先安装或通过-f指定路径: %w", err)
+	}
+	c.FFMpegPath = path
+	return nil
+}
+
+func (c *Config) MessageBox(text string) {
+	logrus.Error(text)
+}
+
+// SelectDirectory 非windows系统没有目录选择对话框
+func (c *Config) SelectDirectory() error {
+	return errors.New("当前系统不支持选择目录，请通过-c指定 bilibili 缓存路径")
+}
+
+// isTerminal 判断文件是否为终端，/dev/null虽然是字符设备但不是终端
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	null, err := os.Stat(os.DevNull)
+	return err != nil || !os.SameFile(fi, null)
+}
+
+// diskFullErrnos 表示磁盘已满的系统错误
+var diskFullErrnos = []error{syscall.ENOSPC, syscall.EDQUOT}
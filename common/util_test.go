@@ -0,0 +1,173 @@
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/sirupsen/logrus"
+)
+
+// restoreLog 测试结束时关闭日志文件并还原logrus，
+// SetupLog会给logrus添加写日志文件的hook，关闭后再写日志会在当前目录重新创建日志文件
+func restoreLog(t *testing.T) {
+	l := logrus.StandardLogger()
+	out, formatter, hooks := l.Out, l.Formatter, make(logrus.LevelHooks)
+	for level, hs := range l.Hooks {
+		hooks[level] = append([]logrus.Hook{}, hs...)
+	}
+	t.Cleanup(func() {
+		CloseLog() // 关闭日志文件后才能删除临时目录
+		l.ReplaceHooks(hooks)
+		l.SetOutput(out)
+		l.SetFormatter(formatter)
+	})
+}
+
+// initConfig 在临时工作目录中按命令行参数args调用InitConfig，不读取工作目录的配置文件，日志也写入临时目录
+func initConfig(t *testing.T, args ...string) (*Config, error) {
+	t.Helper()
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	savedArgs := os.Args
+	t.Cleanup(func() {
+		os.Args = savedArgs
+		_ = os.Chdir(wd)
+	})
+	restoreLog(t)
+	os.Args = append([]string{"m4s-converter"}, args...)
+	c := &Config{}
+	return c, c.InitConfig()
+}
+
+func TestInitConfig(t *testing.T) {
+	tests := []struct {
+		name  string
+		args  []string
+		check func(c *Config) bool
+	}{
+		{"默认值", nil, func(c *Config) bool {
+			return !c.AssOFF && c.FFMpegPath == "" && c.CachePath == "" && c.Overlay == "-n" && c.Jobs == runtime.NumCPU()
+		}},
+		{"-a -f -c在解析后生效", []string{"-a", "-f", "/opt/ffmpeg", "-c", "/cache"}, func(c *Config) bool {
+			return c.AssOFF && c.FFMpegPath == "/opt/ffmpeg" && c.CachePath == "/cache"
+		}},
+		{"-o", []string{"-o"}, func(c *Config) bool { return c.Overlay == "-y" }},
+		{"-v", []string{"-v"}, func(c *Config) bool { return c.ShowVersion }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := initConfig(t, tt.args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.check(c) {
+				t.Errorf("InitConfig(%q) = %+v", tt.args, c)
+			}
+		})
+	}
+}
+
+func TestFilter(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"普通名称", "蛇的工作原理", "蛇的工作原理"},
+		{"替换非法字符", `a<b>c\d"e/f|g?h*i:j`, "a《b》c#d'e_f_g_h_i：j"},
+		{"替换全角括号", "【合集】", "[合集]"},
+		{"去掉控制字符", "a\x00b\tc\nd\x1fe\x7f", "abcde"},
+		{"去掉结尾的点和空格", " 视频... . ", "视频"},
+		{"保留开头和中间的点", ".a.b", ".a.b"},
+		{"保留的设备名", "CON", "_CON"},
+		{"小写设备名", "nul", "_nul"},
+		{"带扩展名的设备名", "com1.txt", "_com1.txt"},
+		{"不是设备名", "CONSOLE", "CONSOLE"},
+		{"COM0不是设备名", "COM0", "COM0"},
+		{"结尾的点去掉后为设备名", "PRN.", "_PRN"},
+		{"只有点", "...", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Filter(tt.in); got != tt.want {
+				t.Errorf("Filter(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterTruncate(t *testing.T) {
+	tests := []struct {
+		name  string
+		in    string
+		runes int
+		bytes int
+	}{
+		{"ASCII按字符数截断", strings.Repeat("a", 300), maxNameRunes, maxNameRunes},
+		{"中文按字节数截断", strings.Repeat("中", 100), maxNameBytes / 3, maxNameBytes},
+		{"不截断半个字符", "a" + strings.Repeat("中", 100), 80, 1 + 79*3},
+		{"截断后去掉结尾的空格", strings.Repeat("a", 199) + " b", 199, 199},
+		{"未超过长度", "abc", 3, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Filter(tt.in)
+			if !utf8.ValidString(got) {
+				t.Fatalf("Filter() 返回了不完整的UTF-8字符: %q", got)
+			}
+			if n := utf8.RuneCountInString(got); n != tt.runes {
+				t.Errorf("Filter() 有%d个字符，应为%d个", n, tt.runes)
+			}
+			if len(got) != tt.bytes {
+				t.Errorf("Filter() 有%d字节，应为%d字节", len(got), tt.bytes)
+			}
+		})
+	}
+}
+
+func TestGetCacheDir(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"222", "222/1", "a/111", "deep/x/y/666", ".hidden/333", "$RECYCLE.BIN/444",
+		"output/555", "222/junk/777", "1332a"} {
+		writeFile(t, root, filepath.Join(filepath.FromSlash(dir), "videoInfo.json"), "{}")
+	}
+	tests := []struct {
+		name string
+		c    Config
+		want []string
+	}{
+		{"跳过隐藏、系统和output目录", Config{}, []string{"1332a", "222", "222/1", "a/111", "deep/x/y/666"}},
+		{"限制深度", Config{Depth: 2}, []string{"1332a", "222", "222/1", "a/111"}},
+		{"深度为1", Config{Depth: 1}, []string{"1332a", "222"}},
+		{"只合成匹配的目录，分P子目录不匹配", Config{Include: "2*"}, []string{"222", "222/1"}},
+		{"排除目录", Config{Exclude: "a"}, []string{"1332a", "222", "222/1", "deep/x/y/666"}},
+		{"排除通配符", Config{Exclude: "*3*"}, []string{"222", "222/1", "a/111", "deep/x/y/666"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dirs, err := tt.c.GetCacheDir(root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, d := range dirs {
+				rel, _ := filepath.Rel(root, d)
+				got = append(got, filepath.ToSlash(rel))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetCacheDir() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package common
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"github.com/lxn/win"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/windows"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+)
+
+//go:embed ffmpeg.exe
+var ffmpegFile embed.FS
+
+var (
+	FFmpegName    = "ffmpeg.exe"
+	FileHashValue = "3b805cb66ebb0e68f19c939bece693c345b15b7bf277b572ab7b4792ee65aad8"
+)
+
+// CanSelectDirectory 是否可以弹出目录选择对话框
+const CanSelectDirectory = true
+
+// cacheCandidates windows下bilibili客户端的默认缓存路径
+func cacheCandidates(home string) []string {
+	return []string{filepath.Join(home, "Videos", "bilibili")}
+}
+
+// GetFFmpegPath 未指定-f时获取 ffmpeg 路径，依次使用环境变量M4S_FFMPEG或FFMPEG、PATH中的ffmpeg.exe和内置的ffmpeg.exe，
+// 第一次运行或文件不完整时释放内置的ffmpeg.exe，指定-no-embed-ffmpeg时不释放并返回错误
+func (c *Config) GetFFmpegPath() error {
+	path, err := ffmpegFromEnv()
+	if err != nil || path != "" {
+		c.FFMpegPath = path
+		return err
+	}
+	// 工作目录中释放的ffmpeg.exe返回exec.ErrDot，按内置的ffmpeg.exe校验
+	if path, err = exec.LookPath(FFmpegName); err == nil {
+		logrus.Debug("使用PATH中的ffmpeg:", path)
+		c.FFMpegPath = path
+		return nil
+	}
+	if c.NoEmbedFFmpeg {
+		return errors.New("已指定-no-embed-ffmpeg，不释放内置的ffmpeg.exe，请通过-f或环境变量M4S_FFMPEG指定ffmpeg路径")
+	}
+	wd, _ := os.Getwd()
+	c.FFMpegPath = filepath.Join(wd, FFmpegName) // 指定ffmpeg路径
+	if !Exist(c.FFMpegPath) {
+		logrus.Info("第一次运行,自动释放ffmpeg.exe")
+	} else if !c.FileHashCompare() {
+		logrus.Info("文件不完整,重新释放ffmpeg.exe")
+	} else {
+		return nil
+	}
+	if err := DecFile(); err != nil {
+		return fmt.Errorf("释放ffmpeg.exe失败，请检查工作目录是否可写、是否被杀毒软件拦截，或通过-f指定ffmpeg路径：%w", err)
+	}
+	return nil
+}
+
+// decAttempts 释放内置ffmpeg.exe的最大次数
+const decAttempts = 3
+
+// DecFile 将内置的ffmpeg.exe释放到工作目录，写入临时文件并校验SHA-256后再替换，
+// 磁盘空间不足或被杀毒软件隔离时不会留下不完整的ffmpeg.exe，失败时重试，最多decAttempts次
+func DecFile() error {
+	data, err := ffmpegFile.ReadFile(FFmpegName)
+	if err != nil {
+		return err
+	}
+	for i := 1; i <= decAttempts; i++ {
+		if err = writeVerified(FFmpegName, data, FileHashValue); err == nil {
+			return nil
+		}
+		logrus.Warnf("第%d次释放ffmpeg.exe失败: %v", i, err)
+	}
+	return fmt.Errorf("已尝试%d次：%w", decAttempts, err)
+}
+
+func (c *Config) FileHashCompare() bool {
+	sha256Str, err := HashFile(c.FFMpegPath)
+	if err != nil {
+		logrus.Error("打开文件失败:", err)
+		return false
+	}
+	return FileHashValue == sha256Str
+}
+
+func _TEXT(str string) *uint16 {
+	ptr, _ := syscall.UTF16PtrFromString(str)
+	return ptr
+}
+
+// MessageBox 记录错误日志并弹出提示框，Headless时只记录日志
+func (c *Config) MessageBox(text string) {
+	logrus.Error(text)
+	if c.Headless {
+		return
+	}
+	win.MessageBox(win.HWND_TOP, _TEXT(text), _TEXT("消息"), win.MB_ICONWARNING)
+}
+
+// selectAttempts 选择缓存目录的最大次数
+const selectAttempts = 5
+
+// SelectDirectory 选择bilimini缓存目录，关闭对话框或多次选择的目录都不正确时返回错误
+func (c *Config) SelectDirectory() error {
+	if c.Headless {
+		return errors.New("已关闭窗口，无法选择目录，请通过 -c 指定 bilibili 缓存路径")
+	}
+	for i := 0; i < selectAttempts; i++ {
+		var bsi win.BROWSEINFO
+		bsi.LpszTitle = _TEXT("请选择 bilibili 缓存目录")
+
+		pid := win.SHBrowseForFolder(&bsi)
+		if pid == 0 {
+			return errors.New("关闭对话框后自动退出程序")
+		}
+
+		path := make([]uint16, win.MAX_PATH)
+		win.SHGetPathFromIDList(pid, &path[0])
+		win.CoTaskMemFree(pid)
+
+		dir := syscall.UTF16ToString(path)
+		if IsCacheRoot(dir) {
+			c.CachePath = dir
+			logrus.Info("选择的 bilibili 缓存目录为:", c.CachePath)
+			return nil
+		}
+		if i < selectAttempts-1 {
+			c.MessageBox("选择的 bilibili 缓存目录不正确，请重新选择！")
+		}
+	}
+	return fmt.Errorf("已连续%d次选择了不正确的 bilibili 缓存目录，请通过 -c 指定缓存路径", selectAttempts)
+}
+
+// isTerminal 判断文件是否为控制台，计划任务中运行或重定向到文件时不是控制台
+func isTerminal(f *os.File) bool {
+	var mode uint32
+	return windows.GetConsoleMode(windows.Handle(f.Fd()), &mode) == nil
+}
+
+// LockMutex windows下的单实例锁，互斥锁已存在时返回ErrAlreadyRunning
+// 互斥锁句柄在进程退出时由系统释放
+func (c *Config) LockMutex(name string) error {
+	h, err := windows.CreateMutex(nil, true, _TEXT(name))
+	if errors.Is(err, windows.ERROR_ALREADY_EXISTS) || h != 0 && windows.GetLastError() == windows.ERROR_ALREADY_EXISTS {
+		_ = windows.CloseHandle(h)
+		return ErrAlreadyRunning
+	}
+	return err
+}
+
+// diskFullErrnos windows下表示磁盘已满的系统错误
+var diskFullErrnos = []error{windows.ERROR_DISK_FULL, windows.ERROR_HANDLE_DISK_FULL, syscall.ENOSPC}
+
+// freeSpace 返回目录所在磁盘当前用户可用的剩余空间
+func freeSpace(dir string) (int64, error) {
+	ptr, err := syscall.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, err
+	}
+	var avail, total, free uint64
+	if err = windows.GetDiskFreeSpaceEx(ptr, &avail, &total, &free); err != nil {
+		return 0, err
+	}
+	return int64(avail), nil
+}
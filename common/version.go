@@ -0,0 +1,19 @@
+package common
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// 构建信息，编译时通过 -ldflags "-X" 注入
+var (
+	version   = "1.3.2"
+	commit    = "unknown"
+	buildDate = "unknown"
+)
+
+// VersionString 返回单行版本信息
+func VersionString() string {
+	return fmt.Sprintf("m4s-converter %s (commit %s, built %s, %s %s/%s)",
+		version, commit, buildDate, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+}
@@ -0,0 +1,118 @@
+package conver
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// 弹幕类型
+const (
+	TypeScroll = "scroll" // 滚动弹幕
+	TypeTop    = "top"    // 顶部弹幕
+	TypeBottom = "bottom" // 底部弹幕
+)
+
+// DanmakuFilter 按类型和正则过滤弹幕
+type DanmakuFilter struct {
+	Types []string         // 保留的弹幕类型，为空时保留全部
+	Block []*regexp.Regexp // 内容匹配任一正则的弹幕被过滤
+}
+
+// NewDanmakuFilter 解析逗号分隔的类型和正则表达式
+func NewDanmakuFilter(types string, block []string) (DanmakuFilter, error) {
+	var f DanmakuFilter
+	for _, t := range strings.Split(types, ",") {
+		switch t = strings.TrimSpace(t); t {
+		case "":
+		case TypeScroll, TypeTop, TypeBottom:
+			f.Types = append(f.Types, t)
+		default:
+			return f, fmt.Errorf("不支持的弹幕类型: %s", t)
+		}
+	}
+	for _, b := range block {
+		re, err := regexp.Compile(b)
+		if err != nil {
+			return f, fmt.Errorf("屏蔽规则 %s 格式错误: %v", b, err)
+		}
+		f.Block = append(f.Block, re)
+	}
+	return f, nil
+}
+
+// Empty 没有任何过滤规则
+func (f DanmakuFilter) Empty() bool {
+	return len(f.Types) == 0 && len(f.Block) == 0
+}
+
+// modeType 将xml中的弹幕模式转换为类型，4为底部，5为顶部，其它视为滚动
+func modeType(mode int) string {
+	switch mode {
+	case 4:
+		return TypeBottom
+	case 5:
+		return TypeTop
+	default:
+		return TypeScroll
+	}
+}
+
+// Allow 判断弹幕是否保留
+func (f DanmakuFilter) Allow(mode int, content string) bool {
+	if len(f.Types) > 0 {
+		t := modeType(mode)
+		allowed := false
+		for _, v := range f.Types {
+			if v == t {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+	for _, re := range f.Block {
+		if re.MatchString(content) {
+			return false
+		}
+	}
+	return true
+}
+
+type xmlDanmaku struct {
+	D []struct {
+		P       string `xml:"p,attr"`
+		Content string `xml:",chardata"`
+	} `xml:"d"`
+}
+
+// Apply 过滤xml弹幕，返回过滤后的xml
+func (f DanmakuFilter) Apply(src io.Reader) (io.Reader, error) {
+	var doc xmlDanmaku
+	if err := xml.NewDecoder(src).Decode(&doc); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.WriteString(xmlHeader)
+	for _, d := range doc.D {
+		p := strings.Split(d.P, ",")
+		if len(p) < 2 {
+			continue
+		}
+		mode, _ := strconv.Atoi(p[1])
+		if !f.Allow(mode, d.Content) {
+			continue
+		}
+		if err := writeD(&buf, d.P, d.Content); err != nil {
+			return nil, err
+		}
+	}
+	buf.WriteString(xmlFooter)
+	return &buf, nil
+}
@@ -0,0 +1,151 @@
+package conver
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MuxOptions 合成一组音视频文件时的选项
+type MuxOptions struct {
+	Overwrite bool              // 覆盖已存在的输出文件，否则不覆盖
+	Metadata  map[string]string // 写入的元数据，如title、artist、comment
+	Subtitle  string            // 作为软字幕轨道封装的ass或srt文件，为空时不封装
+	Cover     string            // 封面图片，mkv作为附件封装，mp4和mov作为attached_pic视频流，为空时不添加
+	InputArgs []string          // 放在输入文件之前的参数，如硬件解码的-hwaccel
+	VideoArgs []string          // 视频的编码参数，如压制弹幕的滤镜和编码器，为空时直接复制视频流
+	FastStart bool              // 把moov移到文件开头，便于边下边播，只对mp4和mov有效
+	ExtraArgs []string          // 追加到输出文件之前的其它ffmpeg参数
+	Concat    bool              // video和audio为concat分离器的文件列表，合并多P视频时使用
+	Chapters  string            // 包含章节标记的ffmpeg元数据文件，为空时不添加
+}
+
+// MuxArgs 返回将video和audio合成为output的ffmpeg参数，输出格式按output的扩展名判断
+func MuxArgs(video, audio, output string, opts MuxOptions) []string {
+	mkv := strings.EqualFold(filepath.Ext(output), MkvSuffix)
+	args := append([]string{}, opts.InputArgs...)
+	var demuxer []string
+	if opts.Concat {
+		demuxer = []string{"-f", "concat", "-safe", "0"} // 列表中为绝对路径
+	}
+	args = append(args, demuxer...)
+	args = append(args, "-i", video)
+	args = append(args, demuxer...)
+	args = append(args, "-i", audio)
+	inputs := 2
+	if opts.Subtitle != "" {
+		args = append(args, "-i", opts.Subtitle)
+		inputs++
+	}
+	// mkv的封面作为附件封装，mp4和mov的封面作为attached_pic视频流
+	coverInput := -1
+	if opts.Cover != "" && !mkv {
+		args = append(args, "-i", opts.Cover)
+		coverInput = inputs
+		inputs++
+	}
+	chaptersInput := -1
+	if opts.Chapters != "" {
+		args = append(args, "-i", opts.Chapters)
+		chaptersInput = inputs
+		inputs++
+	}
+	if inputs > 2 {
+		args = append(args, "-map", "0:v", "-map", "1:a")
+		if opts.Subtitle != "" {
+			// mkv可以直接封装ass和srt，mp4和mov只支持mov_text
+			codec := "mov_text"
+			if mkv {
+				codec = "copy"
+			}
+			args = append(args, "-map", "2:s", "-c:s", codec)
+		}
+		if coverInput >= 0 {
+			args = append(args, "-map", strconv.Itoa(coverInput))
+		}
+		if chaptersInput >= 0 {
+			args = append(args, "-map_metadata", strconv.Itoa(chaptersInput))
+		}
+	}
+	if len(opts.VideoArgs) > 0 {
+		args = append(args, opts.VideoArgs...)
+	} else {
+		args = append(args, "-c:v", "copy") // video不指定编解码，使用bilibili原有编码
+	}
+	if coverInput >= 0 {
+		args = append(args, "-c:v:1", "copy", "-disposition:v:1", "attached_pic")
+	}
+	if opts.Cover != "" && mkv {
+		args = append(args, "-attach", opts.Cover, "-metadata:s:t:0", "mimetype="+coverMime(opts.Cover))
+	}
+	args = append(args,
+		"-c:a", "copy", // audio不指定编解码，使用bilibili原有编码
+		"-strict", "experimental", // 宽松编码控制器
+	)
+	args = append(args, MetadataArgs(opts.Metadata)...)
+	if opts.FastStart && !mkv {
+		args = append(args, "-movflags", "+faststart") // 将moov移到文件开头，便于边下边播
+	}
+	args = append(args, opts.ExtraArgs...)
+	overwrite := "-n"
+	if opts.Overwrite {
+		overwrite = "-y"
+	}
+	return append(args,
+		overwrite, // 是否覆盖已存在视频
+		output,
+		"-hide_banner", // 隐藏版本信息和版权声明
+		"-stats",       // 只显示统计信息
+	)
+}
+
+// MuxAV 使用ffmpegPath将一组音视频文件合成为output，video和audio为已去掉m4s文件头的音视频文件，
+// 不显示进度也不处理超时，需要时自行通过MuxArgs生成参数执行
+func MuxAV(ffmpegPath, video, audio, output string, opts MuxOptions) error {
+	out, err := exec.Command(ffmpegPath, MuxArgs(video, audio, output, opts)...).CombinedOutput()
+	if err != nil && len(bytes.TrimSpace(out)) == 0 {
+		return fmt.Errorf("ffmpeg执行失败: %v", err)
+	}
+	if err != nil {
+		lines := strings.Split(string(bytes.TrimSpace(out)), "\n")
+		if len(lines) > 10 {
+			lines = lines[len(lines)-10:]
+		}
+		return fmt.Errorf("ffmpeg执行失败: %v\n%s", err, strings.Join(lines, "\n"))
+	}
+	return nil
+}
+
+// MetadataArgs 生成ffmpeg的-metadata参数，按key排序保证参数顺序稳定，值为空的key不写入
+// 参数直接传给exec.Command不经过shell，无需转义引号和空格，中文按UTF-8原样传递
+func MetadataArgs(metadata map[string]string) []string {
+	keys := make([]string, 0, len(metadata))
+	for k := range metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var args []string
+	for _, k := range keys {
+		v := strings.TrimSpace(metadata[k])
+		if v == "" {
+			continue
+		}
+		args = append(args, "-metadata", k+"="+v)
+	}
+	return args
+}
+
+// coverMime 返回封面图片的mime类型，mkv附件需要指定
+func coverMime(file string) string {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".png":
+		return "image/png"
+	case ".webp":
+		return "image/webp"
+	}
+	return "image/jpeg"
+}
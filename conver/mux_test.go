@@ -0,0 +1,119 @@
+package conver
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// containsArgs 判断args中是否有连续的want
+func containsArgs(args []string, want ...string) bool {
+	for i := 0; i+len(want) <= len(args); i++ {
+		if reflect.DeepEqual(args[i:i+len(want)], want) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestMuxArgs(t *testing.T) {
+	const video, audio = "v.mp4", "a.mp3"
+	tests := []struct {
+		name    string
+		output  string
+		opts    MuxOptions
+		want    [][]string
+		notWant [][]string
+	}{
+		{"默认不覆盖并复制音视频流", "out.mp4", MuxOptions{},
+			[][]string{{"-i", video, "-i", audio, "-c:v", "copy"}, {"-c:a", "copy"}, {"-n", "out.mp4", "-hide_banner", "-stats"}},
+			[][]string{{"-map", "0:v"}, {"-y"}}},
+		{"覆盖", "out.mp4", MuxOptions{Overwrite: true}, [][]string{{"-y", "out.mp4"}}, [][]string{{"-n"}}},
+		{"元数据按名称排序并忽略空值", "out.mp4", MuxOptions{Metadata: map[string]string{"title": "标题", "artist": " UP主 ", "comment": " "}},
+			[][]string{{"-metadata", "artist=UP主", "-metadata", "title=标题"}}, [][]string{{"-metadata", "comment="}}},
+		{"mp4的软字幕", "out.mp4", MuxOptions{Subtitle: "1.ass"},
+			[][]string{{"-i", audio, "-i", "1.ass", "-map", "0:v", "-map", "1:a", "-map", "2:s", "-c:s", "mov_text"}}, nil},
+		{"mkv的软字幕", "out.mkv", MuxOptions{Subtitle: "1.ass"}, [][]string{{"-map", "2:s", "-c:s", "copy"}}, nil},
+		{"mp4的封面", "out.mp4", MuxOptions{Cover: "cover.jpg"},
+			[][]string{{"-i", "cover.jpg", "-map", "0:v", "-map", "1:a", "-map", "2"}, {"-c:v:1", "copy", "-disposition:v:1", "attached_pic"}},
+			[][]string{{"-attach"}}},
+		{"mkv的封面作为附件", "out.mkv", MuxOptions{Cover: "cover.png"},
+			[][]string{{"-attach", "cover.png", "-metadata:s:t:0", "mimetype=image/png"}}, [][]string{{"-i", "cover.png"}}},
+		{"字幕和封面的输入序号", "out.mp4", MuxOptions{Subtitle: "1.ass", Cover: "cover.jpg"}, [][]string{{"-map", "2:s"}, {"-map", "3"}}, nil},
+		{"压制时使用编码参数", "out.mp4", MuxOptions{VideoArgs: []string{"-c:v", "libx264"}}, [][]string{{"-c:v", "libx264"}}, [][]string{{"-c:v", "copy"}}},
+		{"输入参数在输入文件之前", "out.mp4", MuxOptions{InputArgs: []string{"-hwaccel", "cuda"}}, [][]string{{"-hwaccel", "cuda", "-i", video}}, nil},
+		{"mp4的faststart", "out.mp4", MuxOptions{FastStart: true}, [][]string{{"-movflags", "+faststart"}}, nil},
+		{"mkv忽略faststart", "out.mkv", MuxOptions{FastStart: true}, nil, [][]string{{"-movflags", "+faststart"}}},
+		{"其它参数在输出文件之前", "out.mp4", MuxOptions{ExtraArgs: []string{"-threads", "2"}}, [][]string{{"-threads", "2", "-n", "out.mp4"}}, nil},
+		{"concat分离器", "out.mp4", MuxOptions{Concat: true},
+			[][]string{{"-f", "concat", "-safe", "0", "-i", video, "-f", "concat", "-safe", "0", "-i", audio}}, nil},
+		{"章节", "out.mp4", MuxOptions{Concat: true, Chapters: "chapters.txt"},
+			[][]string{{"-i", "chapters.txt", "-map", "0:v", "-map", "1:a", "-map_metadata", "2"}}, nil},
+		{"字幕之后的章节", "out.mkv", MuxOptions{Subtitle: "1.ass", Chapters: "chapters.txt"}, [][]string{{"-map_metadata", "3"}}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := MuxArgs(video, audio, tt.output, tt.opts)
+			for _, w := range tt.want {
+				if !containsArgs(args, w...) {
+					t.Errorf("参数中缺少%q: %q", w, args)
+				}
+			}
+			for _, w := range tt.notWant {
+				if containsArgs(args, w...) {
+					t.Errorf("参数中不应有%q: %q", w, args)
+				}
+			}
+			if got := strings.Join(args[len(args)-3:], " "); got != tt.output+" -hide_banner -stats" {
+				t.Errorf("参数应以输出文件结尾: %q", args)
+			}
+		})
+	}
+}
+
+func TestMuxAV(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("模拟的ffmpeg为sh脚本")
+	}
+	tests := []struct {
+		name    string
+		script  string
+		wantErr []string // 错误中应包含的内容
+		notErr  []string // 错误中不应包含的内容
+	}{
+		{"成功", "exit 0", nil, nil},
+		{"失败时带上最后10行输出", "for i in $(seq 1 15); do echo line$i; done; exit 1", []string{"exit status 1", "line6", "line15"}, []string{"line5\n"}},
+		{"失败且没有输出", "exit 2", []string{"exit status 2"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ffmpeg := filepath.Join(t.TempDir(), "ffmpeg")
+			if err := os.WriteFile(ffmpeg, []byte("#!/bin/sh\n"+tt.script+"\n"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			err := MuxAV(ffmpeg, "v.mp4", "a.mp3", "out.mp4", MuxOptions{})
+			if (err != nil) != (tt.wantErr != nil) {
+				t.Fatalf("MuxAV() error = %v", err)
+			}
+			if err == nil {
+				return
+			}
+			for _, w := range tt.wantErr {
+				if !strings.Contains(err.Error(), w) {
+					t.Errorf("错误中缺少%q: %v", w, err)
+				}
+			}
+			for _, w := range tt.notErr {
+				if strings.Contains(err.Error()+"\n", w) {
+					t.Errorf("错误中不应有%q: %v", w, err)
+				}
+			}
+		})
+	}
+	if err := MuxAV(filepath.Join(t.TempDir(), "none"), "v.mp4", "a.mp3", "out.mp4", MuxOptions{}); err == nil {
+		t.Error("找不到ffmpeg时应返回错误")
+	}
+}
@@ -0,0 +1,155 @@
+package conver
+
+import (
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Danmaku 一条弹幕，字段与xml弹幕的p属性对应
+type Danmaku struct {
+	ID       int64  // 弹幕ID
+	Progress int32  // 出现时间，单位毫秒
+	Mode     int32  // 类型，1-3滚动，4底部，5顶部，6逆向，7高级
+	Fontsize int32  // 字号
+	Color    uint32 // 颜色，RGB的十进制形式
+	MidHash  string // 发送者ID的哈希
+	Content  string // 弹幕内容
+	Ctime    int64  // 发送时间戳
+	Pool     int32  // 弹幕池
+}
+
+// ParseSeg 解析seg.so接口返回的protobuf数据(DmSegMobileReply)
+func ParseSeg(data []byte) ([]Danmaku, error) {
+	var list []Danmaku
+	err := walkProto(data, func(field int, wire int, v uint64, b []byte) error {
+		if field != 1 || wire != 2 { // repeated DanmakuElem elems = 1
+			return nil
+		}
+		d, err := parseElem(b)
+		if err != nil {
+			return err
+		}
+		list = append(list, d)
+		return nil
+	})
+	return list, err
+}
+
+func parseElem(data []byte) (Danmaku, error) {
+	var d Danmaku
+	err := walkProto(data, func(field int, wire int, v uint64, b []byte) error {
+		switch field {
+		case 1:
+			d.ID = int64(v)
+		case 2:
+			d.Progress = int32(v)
+		case 3:
+			d.Mode = int32(v)
+		case 4:
+			d.Fontsize = int32(v)
+		case 5:
+			d.Color = uint32(v)
+		case 6:
+			d.MidHash = string(b)
+		case 7:
+			d.Content = string(b)
+		case 8:
+			d.Ctime = int64(v)
+		case 11:
+			d.Pool = int32(v)
+		}
+		return nil
+	})
+	return d, err
+}
+
+// walkProto 遍历protobuf消息的字段，varint和定长字段的值放在v，length-delimited字段的值放在b
+func walkProto(data []byte, fn func(field int, wire int, v uint64, b []byte) error) error {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return errors.New("protobuf格式错误")
+		}
+		data = data[n:]
+		field, wire := int(key>>3), int(key&7)
+		var v uint64
+		var b []byte
+		switch wire {
+		case 0: // varint
+			v, n = binary.Uvarint(data)
+			if n <= 0 {
+				return errors.New("protobuf格式错误")
+			}
+			data = data[n:]
+		case 1: // fixed64
+			if len(data) < 8 {
+				return errors.New("protobuf格式错误")
+			}
+			v, data = binary.LittleEndian.Uint64(data), data[8:]
+		case 2: // length-delimited
+			l, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < l {
+				return errors.New("protobuf格式错误")
+			}
+			b, data = data[n:n+int(l)], data[n+int(l):]
+		case 5: // fixed32
+			if len(data) < 4 {
+				return errors.New("protobuf格式错误")
+			}
+			v, data = uint64(binary.LittleEndian.Uint32(data)), data[4:]
+		default:
+			return fmt.Errorf("不支持的protobuf类型: %d", wire)
+		}
+		if err := fn(field, wire, v, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const (
+	xmlHeader = `<?xml version="1.0" encoding="UTF-8"?>` + "\n<i>\n"
+	xmlFooter = "</i>\n"
+)
+
+// writeD 写入一条<d>弹幕
+func writeD(w io.Writer, p, content string) error {
+	if _, err := io.WriteString(w, `<d p="`+p+`">`); err != nil {
+		return err
+	}
+	if err := xml.EscapeText(w, []byte(content)); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "</d>\n")
+	return err
+}
+
+// WriteXml 将弹幕写为comment.bilibili.com格式的xml，便于复用Xml2ass转换
+func WriteXml(w io.Writer, cid string, list []Danmaku) error {
+	var sb strings.Builder
+	sb.WriteString(xmlHeader)
+	sb.WriteString("<chatserver>chat.bilibili.com</chatserver><chatid>" + cid + "</chatid>\n")
+	for _, d := range list {
+		p := strings.Join([]string{
+			strconv.FormatFloat(float64(d.Progress)/1000, 'f', 5, 64),
+			strconv.Itoa(int(d.Mode)),
+			strconv.Itoa(int(d.Fontsize)),
+			strconv.FormatUint(uint64(d.Color), 10),
+			strconv.FormatInt(d.Ctime, 10),
+			strconv.Itoa(int(d.Pool)),
+			d.MidHash,
+			strconv.FormatInt(d.ID, 10),
+		}, ",")
+		if err := writeD(&sb, p, d.Content); err != nil {
+			return err
+		}
+	}
+	sb.WriteString(xmlFooter)
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
@@ -0,0 +1,77 @@
+package conver
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// CacheStatus videoInfo中status字段表示的缓存状态，按缓存的进度从小到大排列
+type CacheStatus int
+
+const (
+	StatusUnknown     CacheStatus = iota // 无法识别的状态
+	StatusPending                        // pending，等待缓存，还没有缓存文件
+	StatusDownloading                    // downloading，正在缓存中
+	StatusStopped                        // stopped，已暂停缓存，可能只缓存了一部分
+	StatusCompleted                      // completed，缓存完成
+)
+
+var statusNames = []string{"unknown", "pending", "downloading", "stopped", "completed"}
+
+// statusAliases 部分客户端版本使用的其它写法
+var statusAliases = map[string]CacheStatus{
+	"waiting":  StatusPending,
+	"paused":   StatusStopped,
+	"pause":    StatusStopped,
+	"complete": StatusCompleted,
+	"finished": StatusCompleted,
+}
+
+// String 返回videoInfo中使用的状态名称，如completed
+func (s CacheStatus) String() string {
+	if s < 0 || int(s) >= len(statusNames) {
+		return statusNames[StatusUnknown]
+	}
+	return statusNames[s]
+}
+
+// ParseStatus 解析videoInfo中字符串或数字形式的status，数字形式的0-3依次为pending、downloading、stopped、completed，
+// 布尔值为手机客户端的is_completed，无法识别时返回StatusUnknown
+func ParseStatus(v interface{}) CacheStatus {
+	switch s := v.(type) {
+	case string:
+		s = strings.ToLower(strings.TrimSpace(s))
+		if n, err := strconv.Atoi(s); err == nil {
+			return statusNumber(int64(n))
+		}
+		for i, name := range statusNames {
+			if i > 0 && s == name {
+				return CacheStatus(i)
+			}
+		}
+		return statusAliases[s]
+	case json.Number:
+		if n, err := s.Int64(); err == nil {
+			return statusNumber(n)
+		}
+	case float64:
+		return statusNumber(int64(s))
+	case int:
+		return statusNumber(int64(s))
+	case bool:
+		if s {
+			return StatusCompleted
+		}
+		return StatusDownloading
+	}
+	return StatusUnknown
+}
+
+// statusNumber 将数字形式的status转换为CacheStatus
+func statusNumber(n int64) CacheStatus {
+	if n < 0 || n > 3 {
+		return StatusUnknown
+	}
+	return CacheStatus(n + 1)
+}
@@ -0,0 +1,50 @@
+package conver
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseStatus(t *testing.T) {
+	tests := []struct {
+		name string
+		v    interface{}
+		want CacheStatus
+	}{
+		{"completed", "completed", StatusCompleted},
+		{"大小写和空格", " Downloading ", StatusDownloading},
+		{"stopped", "stopped", StatusStopped},
+		{"pending", "pending", StatusPending},
+		{"其它写法paused", "paused", StatusStopped},
+		{"其它写法finished", "finished", StatusCompleted},
+		{"unknown不是有效状态", "unknown", StatusUnknown},
+		{"无法识别的字符串", "deleted", StatusUnknown},
+		{"字符串形式的数字", "3", StatusCompleted},
+		{"json.Number", json.Number("1"), StatusDownloading},
+		{"float64", float64(2), StatusStopped},
+		{"int", 0, StatusPending},
+		{"超出范围的数字", 4, StatusUnknown},
+		{"负数", -1, StatusUnknown},
+		{"is_completed为true", true, StatusCompleted},
+		{"is_completed为false", false, StatusDownloading},
+		{"null", nil, StatusUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseStatus(tt.v); got != tt.want {
+				t.Errorf("ParseStatus(%v) = %v, want %v", tt.v, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCacheStatusString(t *testing.T) {
+	for _, s := range []CacheStatus{StatusPending, StatusDownloading, StatusStopped, StatusCompleted} {
+		if got := ParseStatus(s.String()); got != s {
+			t.Errorf("ParseStatus(%q) = %v, want %v", s.String(), got, s)
+		}
+	}
+	if got := CacheStatus(99).String(); got != "unknown" {
+		t.Errorf("CacheStatus(99).String() = %q, want unknown", got)
+	}
+}
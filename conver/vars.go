@@ -2,9 +2,16 @@ package conver
 
 var (
 	AssSuffix       = ".ass"
+	SrtSuffix       = ".srt"
 	XmlSuffix       = ".xml"
 	M4sSuffix       = ".m4s"
 	Mp4Suffix       = ".mp4"
+	Mp3Suffix       = ".mp3"
+	MkvSuffix       = ".mkv"
+	MovSuffix       = ".mov"
+	WavSuffix       = ".wav"
+	FlacSuffix      = ".flac"
+	M4aSuffix       = ".m4a"
 	VideoInfoSuffix = ".videoInfo"
 	VideoInfoJson   = "videoInfo.json"
 	AudioSuffix     = "-audio.mp3"
@@ -23,15 +30,19 @@ var (
 
 type PlayUrl struct {
 	Data struct {
-		Dash struct {
-			Video []struct {
-				ID     int `json:"id"`
-				Width  int `json:"width"`
-				Height int `json:"height"`
-			} `json:"video"`
-			Audio []struct {
-				ID int `json:"id"`
-			} `json:"audio"`
+		Timelength int64 `json:"timelength"` // 总时长，单位毫秒
+		Dash       struct {
+			Duration int          `json:"duration"` // 总时长，单位秒
+			Video    []DashStream `json:"video"`
+			Audio    []DashStream `json:"audio"`
 		} `json:"dash"`
 	} `json:"data"`
 }
+
+// DashStream .playurl中的音视频流，同一清晰度可能有多个不同编码的流
+type DashStream struct {
+	ID        int `json:"id"`
+	Width     int `json:"width"`
+	Height    int `json:"height"`
+	Bandwidth int `json:"bandwidth"`
+}
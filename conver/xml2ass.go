@@ -3,6 +3,7 @@ package conver
 import (
 	"fmt"
 	"github.com/sirupsen/logrus"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -10,7 +11,44 @@ import (
 	"github.com/mzky/converter"
 )
 
+// AssStyle 可调整的ass弹幕样式
+type AssStyle struct {
+	FontName string  // 字体名称
+	Fontsize int     // 字体大小
+	Opacity  float32 // 不透明度，0-1，1为完全不透明
+	Outline  int     // 描边大小
+	RollTime int     // 滚动弹幕显示时间，单位秒，越小滚动越快
+
+	Filter DanmakuFilter // 转换前过滤弹幕
+}
+
+// DefaultAssStyle 默认样式，与DefaultSetting一致
+var DefaultAssStyle = AssStyle{
+	FontName: DefaultSetting.FontName,
+	Fontsize: DefaultSetting.Fontsize,
+	Opacity:  0.7, // 对应DefaultSetting.Alpha为0.3
+	Outline:  DefaultSetting.Outline,
+	RollTime: DefaultSetting.RollTime,
+}
+
+// setting 在默认设置的基础上应用样式
+func (s AssStyle) setting() Setting {
+	setting := DefaultSetting
+	setting.FontName = s.FontName
+	setting.Fontsize = s.Fontsize
+	setting.Alpha = 1 - s.Opacity // Alpha为透明度
+	setting.Outline = s.Outline
+	setting.RollTime = s.RollTime
+	return setting
+}
+
+// Xml2ass 使用默认样式将xml弹幕转换为ass
 func Xml2ass(xml string) string {
+	return Xml2assWithStyle(xml, DefaultAssStyle)
+}
+
+// Xml2assWithStyle 使用指定样式将xml弹幕转换为ass
+func Xml2assWithStyle(xml string, style AssStyle) string {
 	dstFile := ""
 	xmlState, err := os.Stat(xml)
 	if err != nil {
@@ -28,7 +66,7 @@ func Xml2ass(xml string) string {
 		return dstFile
 	}
 
-	setting := DefaultSetting
+	setting := style.setting()
 	assConfig := setting.GetAssConfig()
 	chain := converter.NewFilterChain()
 	keywordFilter, typeFilter := setting.GetFilter()
@@ -37,25 +75,46 @@ func Xml2ass(xml string) string {
 	failed := 0
 	for _, file := range xmls {
 		//加载xml文件
-		src, _ := os.Open(file)
+		src, e := os.Open(file)
 		if src == nil {
 			failed++
 			continue
 		}
 
-		dstFile = strings.ReplaceAll(file, filepath.Ext(file), AssSuffix)
-		dst, e := os.Create(dstFile)
+		var reader io.Reader = src
+		if !style.Filter.Empty() {
+			if reader, e = style.Filter.Apply(src); e != nil {
+				logrus.Warnf("弹幕过滤失败：%v", e)
+				_ = src.Close()
+				failed++
+				continue
+			}
+		}
+
+		//如果在go程中加载xml，当文件过多时会出现过高的内存占用
+		pool := converter.LoadPool(reader, chain)
+		_ = src.Close()
+		if pool == nil || pool.BulletChat == nil || pool.BulletChat.Len() == 0 { // 没有弹幕或全部被过滤，不生成空的ass，与没有弹幕相同
+			logrus.Warnf("%s中没有弹幕或弹幕全部被过滤", file)
+			failed++
+			continue
+		}
+		assFile := strings.ReplaceAll(file, filepath.Ext(file), AssSuffix)
+		dst, e := os.Create(assFile)
 		if e != nil {
 			failed++
 			continue
 		}
-		//如果在go程中加载xml，当文件过多时会出现过高的内存占用
-		pool := converter.LoadPool(src, chain)
-		if er := pool.Convert(dst, assConfig); er != nil {
+		e = pool.Convert(dst, assConfig)
+		if er := dst.Close(); e == nil {
+			e = er
+		}
+		if e != nil {
+			_ = os.Remove(assFile)
 			failed++
+			continue
 		}
-		_ = src.Close()
-		_ = dst.Close()
+		dstFile = assFile
 	}
 	fmt.Println("转换弹幕:", "成功数", len(xmls)-failed, "失败数", failed)
 	return dstFile
@@ -0,0 +1,142 @@
+package conver
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	srtDuration = 3000 // 每条字幕的显示时间，单位毫秒，下一条字幕开始时提前结束
+	srtMaxLines = 4    // 同一秒内的弹幕合并为一条字幕，最多显示的行数
+)
+
+// srtCue 一条srt字幕，合并了同一秒内的所有弹幕
+type srtCue struct {
+	second int
+	lines  []string
+}
+
+// Xml2srt 将xml弹幕转换为srt字幕
+func Xml2srt(xml string) string {
+	return Xml2srtWithFilter(xml, DanmakuFilter{})
+}
+
+// Xml2srtWithFilter 过滤xml弹幕后转换为srt字幕，返回生成的srt文件
+func Xml2srtWithFilter(xml string, filter DanmakuFilter) string {
+	dstFile := ""
+	xmlState, err := os.Stat(xml)
+	if err != nil {
+		if os.IsNotExist(err) {
+			logrus.Warnf("文件：%s不存在", xml)
+			return dstFile
+		}
+		logrus.Warn(err)
+		return dstFile
+	}
+
+	xmls, err := listXmlFiles(xml, xmlState)
+	if err != nil {
+		logrus.Warnf("无法列出XML文件：%v", err)
+		return dstFile
+	}
+
+	failed := 0
+	for _, file := range xmls {
+		cues, e := readSrtCues(file, filter)
+		if e != nil || len(cues) == 0 { // 读取失败、没有弹幕或全部被过滤
+			failed++
+			continue
+		}
+		srtFile := strings.ReplaceAll(file, filepath.Ext(file), SrtSuffix)
+		if e = writeSrtFile(srtFile, cues); e != nil {
+			logrus.Warnf("写入srt字幕失败：%v", e)
+			_ = os.Remove(srtFile)
+			failed++
+			continue
+		}
+		dstFile = srtFile
+	}
+	fmt.Println("转换弹幕:", "成功数", len(xmls)-failed, "失败数", failed)
+	return dstFile
+}
+
+// readSrtCues 读取xml弹幕，按出现的秒数合并为字幕
+func readSrtCues(file string, filter DanmakuFilter) ([]srtCue, error) {
+	src, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	var doc xmlDanmaku
+	if err = xml.NewDecoder(src).Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	bySecond := make(map[int]*srtCue)
+	for _, d := range doc.D {
+		// p属性依次为出现时间(秒)、类型、字号、颜色等
+		p := strings.Split(d.P, ",")
+		if len(p) < 2 {
+			continue
+		}
+		t, e := strconv.ParseFloat(p[0], 64)
+		if e != nil || t < 0 {
+			continue
+		}
+		mode, _ := strconv.Atoi(p[1])
+		content := strings.TrimSpace(d.Content)
+		if content == "" || !filter.Allow(mode, content) {
+			continue
+		}
+		second := int(t)
+		cue := bySecond[second]
+		if cue == nil {
+			cue = &srtCue{second: second}
+			bySecond[second] = cue
+		}
+		if len(cue.lines) < srtMaxLines {
+			cue.lines = append(cue.lines, content)
+		}
+	}
+
+	cues := make([]srtCue, 0, len(bySecond))
+	for _, cue := range bySecond {
+		cues = append(cues, *cue)
+	}
+	sort.Slice(cues, func(i, j int) bool { return cues[i].second < cues[j].second })
+	return cues, nil
+}
+
+// writeSrtFile 写入srt字幕，每条字幕显示srtDuration，与下一条重叠时提前结束
+func writeSrtFile(dstFile string, cues []srtCue) error {
+	dst, err := os.Create(dstFile)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(dst)
+	for i, cue := range cues {
+		start := cue.second * 1000
+		end := start + srtDuration
+		if i+1 < len(cues) && cues[i+1].second*1000 < end {
+			end = cues[i+1].second * 1000
+		}
+		_, _ = fmt.Fprintf(w, "%d\n%s --> %s\n%s\n\n", i+1, srtTime(start), srtTime(end), strings.Join(cue.lines, "\n"))
+	}
+	if err = w.Flush(); err != nil {
+		_ = dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+// srtTime 将毫秒格式化为srt的时间格式 HH:MM:SS,mmm
+func srtTime(ms int) string {
+	return fmt.Sprintf("%02d:%02d:%02d,%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
@@ -17,4 +17,6 @@ require (
 	github.com/mzky/converter v0.0.0-20240218092920-bfbd07560669
 	github.com/sirupsen/logrus v1.9.3
 	golang.org/x/sys v0.19.0
+	golang.org/x/text v0.9.0
+	gopkg.in/yaml.v3 v3.0.1
 )
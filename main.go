@@ -1,125 +1,310 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"github.com/bitly/go-simplejson"
 	"github.com/sirupsen/logrus"
 	"m4s-converter/common"
-	"m4s-converter/conver"
 	"os"
-	"os/exec"
-	"path/filepath"
+	"os/signal"
+	"strconv"
 	"strings"
-	"time"
+	"syscall"
 )
 
 func main() {
 	var c common.Config
-	c.InitConfig()
+	if err := c.InitConfig(); err != nil {
+		c.MessageBox(err.Error())
+		os.Exit(common.ExitFatal)
+	}
+	if c.ShowVersion {
+		fmt.Println(common.VersionString())
+		os.Exit(0)
+	}
+
+	// Ctrl+C或SIGTERM时取消合成，并结束正在运行的ffmpeg
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	defer c.PanicHandler()
-	defer c.File.Close()
+	defer common.CloseLog()
+	defer c.PanicHandler() // 先于CloseLog执行，保证异常信息写入日志文件
+
+	if c.Check {
+		os.Exit(checkFFmpeg(ctx, &c))
+	}
 
-	if c.LockMutex("m4sTool") != nil {
-		c.MessageBox("只能运行一个实例！")
-		os.Exit(1)
+	if err := c.LockMutex("m4sTool"); err != nil {
+		c.MessageBox(err.Error())
+		os.Exit(common.ExitFatal)
 	}
 
-	begin := time.Now().Unix()
+	if c.CachePath == "" {
+		selectCachePath(&c)
+	}
+
+	if c.List {
+		entries, err := common.NewConverter(&c).List()
+		if err != nil {
+			c.MessageBox(err.Error())
+			wait(&c, common.ExitFatal)
+		}
+		common.PrintList(os.Stdout, entries)
+		return
+	}
 
-	// 查找m4s文件，并转换为mp4和mp3
-	if err := filepath.WalkDir(c.CachePath, c.FindM4sFiles); err != nil {
-		c.MessageBox(fmt.Sprintf("找不到 bilibili 目录下的 m4s 文件：%v", err))
-		wait()
+	if c.CleanOnly {
+		dirs, err := common.NewConverter(&c).CleanableDirs()
+		if err != nil {
+			c.MessageBox(err.Error())
+			wait(&c, common.ExitFatal)
+		}
+		logrus.Infof("找到%d个已合成成功的缓存目录", len(dirs))
+		cleanDirs(&c, dirs)
+		wait(&c, common.ExitOK)
 	}
 
-	dirs, err := common.GetCacheDir(c.CachePath) // 缓存根目录模式
+	report, err := common.NewConverter(&c).Run(ctx)
+	stop() // 恢复默认的信号处理，再次Ctrl+C可直接退出
 	if err != nil {
-		c.MessageBox(fmt.Sprintf("找不到 bilibili 的缓存目录：%v", err))
-		wait()
+		c.MessageBox(err.Error())
+		wait(&c, common.ExitFatal)
 	}
 
-	if dirs == nil {
-		// 判断非缓存根目录时，验证是否为子目录
-		if common.Exist(filepath.Join(c.CachePath, conver.VideoInfoSuffix)) ||
-			common.Exist(filepath.Join(c.CachePath, conver.VideoInfoJson)) {
-			dirs = append(dirs, c.CachePath)
+	if c.Report != "" {
+		if e := report.Write(c.Report); e != nil {
+			logrus.Error("写入报告失败:", e)
 		}
 	}
+	printSummary(&c, report)
+	if c.Clean && !c.DryRun && !report.Interrupted {
+		cleanDirs(&c, composedDirs(report))
+	}
+	wait(&c, report.ExitCode())
+}
 
-	// 合成音视频文件
-	var outputDir string
-	var outputFiles []string
-	var skipFilePaths []string
-	for _, v := range dirs {
-		video, audio, e := c.GetAudioAndVideo(v)
-		if e != nil {
-			logrus.Error("找不到已修复的音频和视频文件:", err)
-			continue
+// selectCachePath 使用bilibili默认缓存路径，默认路径下没有缓存时弹出目录选择对话框，不支持对话框时提示使用-c
+// 多个默认缓存路径中都有缓存时列出供选择
+func selectCachePath(c *common.Config) {
+	if paths := common.DefaultCachePaths(); len(paths) > 1 {
+		chosen := chooseCachePaths(c, paths)
+		if len(chosen) == 0 {
+			logrus.Info("已取消")
+			wait(c, common.ExitOK)
 		}
-		info := filepath.Join(v, conver.VideoInfoJson)
-		if !common.Exist(info) {
-			info = filepath.Join(v, conver.VideoInfoSuffix)
+		c.CachePaths, c.CachePath = chosen, chosen[0]
+		logrus.Info("选择的 bilibili 缓存目录为: ", strings.Join(chosen, "、"))
+		return
+	}
+	path, err := common.DefaultCachePath()
+	if err != nil && common.CanSelectDirectory && !c.Headless {
+		c.MessageBox(err.Error() + ",\n请选择 bilibili 当前设置的缓存路径！")
+		if err = c.SelectDirectory(); err != nil {
+			c.MessageBox(err.Error())
+			os.Exit(common.ExitFatal)
 		}
-		infoStr, e := os.ReadFile(info)
-		if e != nil {
-			logrus.Error("找不到videoInfo相关文件: ", info)
-			continue
+		return
+	}
+	if err != nil {
+		c.MessageBox(err.Error() + "\n请通过 -c 指定 bilibili 缓存路径，如 -c ~/Movies/bilibili")
+		os.Exit(common.ExitFatal)
+	}
+	c.CachePath = path
+	logrus.Info("选择的 bilibili 缓存目录为: ", c.CachePath)
+}
+
+// chooseCachePaths 列出找到的缓存路径及其中的缓存目录数量，由用户选择一个、全部或取消，取消时返回空
+// 无法询问时使用全部路径
+func chooseCachePaths(c *common.Config, paths []string) []string {
+	if c.Headless {
+		logrus.Infof("找到%d个 bilibili 缓存路径，全部合成", len(paths))
+		return paths
+	}
+	fmt.Println("找到多个 bilibili 缓存路径：")
+	for i, p := range paths {
+		fmt.Printf("  %d. %s（%d个缓存目录）\n", i+1, p, c.EntryCount(p))
+	}
+	fmt.Println("  a. 全部")
+	fmt.Println("  q. 取消")
+	for {
+		fmt.Print("请选择 (默认a): ")
+		var answer string
+		_, _ = fmt.Scanln(&answer)
+		switch answer = strings.ToLower(strings.TrimSpace(answer)); answer {
+		case "", "a":
+			return paths
+		case "q":
+			return nil
 		}
-		js, errb := simplejson.NewJson(infoStr)
-		if errb != nil {
-			logrus.Error("videoInfo相关文件解析失败: ", info)
-			continue
+		if n, err := strconv.Atoi(answer); err == nil && n >= 1 && n <= len(paths) {
+			return paths[n-1 : n]
 		}
-		groupTitle := common.Filter(js.Get("groupTitle").String())
-		title := common.Filter(js.Get("title").String())
-		uname := common.Filter(js.Get("uname").String())
-		status := common.Filter(js.Get("status").String())
+		fmt.Println("输入无效，请输入序号、a或q")
+	}
+}
 
-		if status != "completed" {
-			skipFilePaths = append(skipFilePaths, v)
-			logrus.Warn("未缓存完成,跳过合成", v, title+"-"+uname)
+// checkFFmpeg 打印ffmpeg的检查结果，返回退出码，ffmpeg无法运行或缺少当前参数需要的功能时返回1
+func checkFFmpeg(ctx context.Context, c *common.Config) int {
+	r, err := c.CheckFFmpeg(ctx)
+	if err != nil {
+		logrus.Error("ffmpeg检查失败: ", err)
+		common.CloseLog()
+		return common.ExitFatal
+	}
+	r.Print(os.Stdout)
+	code := common.ExitOK
+	for _, it := range r.Failed() {
+		logrus.Errorf("ffmpeg不支持%s，无法使用%s", it.Name, it.Usage)
+		code = common.ExitFatal
+	}
+	if code == common.ExitOK {
+		logrus.Info("ffmpeg检查通过")
+	}
+	common.CloseLog()
+	return code
+}
+
+// printSummary 打印本次运行的结果，并打开合成文件目录
+func printSummary(c *common.Config, report common.RunReport) {
+	var skipFilePaths []string
+	for _, s := range report.Skipped {
+		if s.File != "" {
+			skipFilePaths = append(skipFilePaths, s.Dir+"  "+s.Reason+": "+s.File)
 			continue
 		}
-		outputDir = filepath.Join(filepath.Dir(v), "output")
-		if !common.Exist(outputDir) {
-			os.Mkdir(outputDir, os.ModePerm)
-		}
-		groupDir := filepath.Join(outputDir, groupTitle+"-"+uname)
-		if !common.Exist(groupDir) {
-			if err = os.Mkdir(groupDir, os.ModePerm); err != nil {
-				c.MessageBox("无法创建目录：" + groupDir)
-				wait()
-			}
-		}
-		outputFile := filepath.Join(groupDir, title+conver.Mp4Suffix)
-		if er := c.Composition(video, audio, outputFile); er != nil {
-			logrus.Error("合成失败:", er)
-			continue
+		skipFilePaths = append(skipFilePaths, s.Dir)
+	}
+	for _, f := range report.Files {
+		if !f.Success {
+			skipFilePaths = append(skipFilePaths, f.Dir)
 		}
-		outputFiles = append(outputFiles, outputFile)
 	}
 
-	end := time.Now().Unix()
 	logrus.Print("==========================================")
+	if report.Interrupted {
+		logrus.Warn("任务已中断，未完成的文件已删除")
+	}
+	if report.DiskFull {
+		logrus.Error("磁盘空间不足，已停止合成剩余的视频，清理磁盘后重新运行即可继续")
+	}
+	if report.Fallback != nil {
+		logrus.Warn("ffmpeg不支持的功能已改为直接复制:\n" + strings.Join(report.Fallback, "\n"))
+	}
 	if skipFilePaths != nil {
 		logrus.Print("跳过的目录:\n" + strings.Join(skipFilePaths, "\n"))
 	}
-	if outputFiles != nil {
-		logrus.Print("合成的文件:\n" + strings.Join(outputFiles, "\n"))
+	if partial := partialLines(report); partial != nil {
+		logrus.Warn("合成成功但不完整的文件:\n" + strings.Join(partial, "\n"))
+	}
+	if report.Done != nil {
+		logrus.Print("已合成过，跳过的文件:\n" + strings.Join(report.Done, "\n"))
+	}
+	if report.Composed != nil && c.DryRun {
+		logrus.Print("将合成的文件:\n" + strings.Join(report.Composed, "\n"))
+	} else if report.Composed != nil {
+		logrus.Print("合成的文件:\n" + strings.Join(composedLines(report), "\n"))
 		// 打开合成文件目录
-		go exec.Command("explorer", outputDir).Start()
-	} else {
+		if !c.Headless {
+			_ = common.OpenDir(report.OutputDir)
+		}
+	} else if report.Done == nil {
 		logrus.Warn("未合成任何文件！")
 	}
-	logrus.Print("已完成本次任务，耗时:", end-begin, "秒")
+	if report.Written > 0 {
+		elapsed := report.Elapsed
+		if elapsed < 1 {
+			elapsed = 1
+		}
+		logrus.Printf("读取%s，写入%s，平均%.1fMB/s", common.FormatSize(report.Read), common.FormatSize(report.Written),
+			float64(report.Read)/(1<<20)/float64(elapsed))
+	}
+	logrus.Print("已完成本次任务，耗时:", report.Elapsed, "秒")
 	logrus.Print("==========================================")
+}
+
+// partialLines 返回合成成功但有问题的文件及原因，如视频已合成但弹幕下载失败
+func partialLines(report common.RunReport) []string {
+	var lines []string
+	for _, f := range report.Files {
+		if f.Success && !f.Done && f.Warning != "" {
+			lines = append(lines, f.Output+": "+strings.ReplaceAll(f.Warning, "\n", "; "))
+		}
+	}
+	return lines
+}
+
+// composedLines 返回合成的文件，有ffprobe读取的信息时附加在文件名后
+func composedLines(report common.RunReport) []string {
+	infos := make(map[string]*common.ProbeInfo)
+	for _, f := range report.Files {
+		if f.Info != nil {
+			infos[f.Output] = f.Info
+		}
+	}
+	lines := make([]string, 0, len(report.Composed))
+	for _, file := range report.Composed {
+		if info := infos[file]; info != nil {
+			file += "  [" + info.String() + "]"
+		}
+		lines = append(lines, file)
+	}
+	return lines
+}
 
-	wait()
+// composedDirs 返回所有文件都合成成功（包括已合成过）的分P目录
+func composedDirs(report common.RunReport) []string {
+	ok := make(map[string]bool)
+	var dirs []string
+	for _, f := range report.Files {
+		if _, seen := ok[f.Dir]; !seen {
+			dirs = append(dirs, f.Dir)
+			ok[f.Dir] = true
+		}
+		ok[f.Dir] = ok[f.Dir] && f.Success
+	}
+	var composed []string
+	for _, d := range dirs {
+		if ok[d] {
+			composed = append(composed, d)
+		}
+	}
+	return composed
 }
 
-func wait() {
-	fmt.Print("按回车键退出...")
-	fmt.Scanln()
-	os.Exit(0)
+// cleanDirs 删除目录中的中间文件，未指定-y时先询问，无法询问时不删除
+func cleanDirs(c *common.Config, dirs []string) {
+	files := c.CleanTargets(dirs)
+	if len(files) == 0 {
+		logrus.Info("没有需要清理的中间文件")
+		return
+	}
+	size := common.FilesSize(files)
+	if !c.Yes {
+		if c.Headless {
+			logrus.Warn("无法确认是否删除，未清理中间文件，可加上 -y 直接删除")
+			return
+		}
+		fmt.Printf("将删除%d个中间文件，共%s，确认删除？(y/N) ", len(files), common.FormatSize(size))
+		var answer string
+		_, _ = fmt.Scanln(&answer)
+		if !strings.EqualFold(strings.TrimSpace(answer), "y") {
+			logrus.Info("已取消清理")
+			return
+		}
+	}
+	reclaimed, err := common.RemoveFiles(files)
+	if err != nil {
+		logrus.Warn("部分中间文件删除失败:\n", err)
+	}
+	logrus.Infof("已清理中间文件，释放%s", common.FormatSize(reclaimed))
+}
+
+// wait 等待按回车键后以退出码code退出，Headless或NoWait时直接退出
+func wait(c *common.Config, code int) {
+	if !c.Headless && !c.NoWait {
+		fmt.Print("按回车键退出...")
+		fmt.Scanln()
+	}
+	common.CloseLog()
+	os.Exit(code)
 }